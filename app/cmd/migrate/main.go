@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"goweb/app/internal/application"
+	"goweb/app/internal/config"
+	"goweb/app/internal/migrate"
+	"os"
+	"strconv"
+)
+
+const usage = `Usage: migrate <command> [flags]
+
+Commands:
+  up            apply the pending migrations
+  down [steps]  revert the last migrations, one by default
+  status        print the schema version and the pending migrations
+
+The database is configured like the server, run migrate up -help to list the flags.`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return errors.New("missing command")
+	}
+	command, args := args[0], args[1:]
+
+	// down takes the number of migrations to revert
+	steps := 1
+	if command == "down" && len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			if n < 1 {
+				return errors.New("down needs at least 1 step")
+			}
+			steps, args = n, args[1:]
+		}
+	}
+
+	// load the configuration: file, environment variables and flags
+	cfg, err := config.Load(args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Println(usage)
+		fmt.Println()
+		config.Usage(os.Stdout)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if cfg.Repository.Backend != config.BackendMySQL {
+		return fmt.Errorf("migrations are only needed by the mysql backend, the %s backend creates its storage on startup", cfg.Repository.Backend)
+	}
+
+	// connect to the database
+	db, err := application.OpenMySQL(cfg.Repository)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db, migrate.MySQL)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("the schema is up to date")
+		}
+	case "down":
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %d, the code expects %d\n", version, migrator.Latest())
+		for _, migration := range migrator.Migrations() {
+			if migration.Version > version {
+				fmt.Printf("pending %d_%s\n", migration.Version, migration.Name)
+			}
+		}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	return nil
+}
@@ -1,19 +1,45 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"goweb/app/internal/application"
+	"goweb/app/internal/config"
+	"goweb/app/internal/logging"
+	"log/slog"
+	"os"
 )
 
 func main() {
 
+	// load the configuration: file, environment variables and flags
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		config.Usage(os.Stdout)
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// every package logs through the default logger
+	logger, err := logging.New(os.Stdout, cfg.Log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
 	// create the server
-	server := application.NewServer(":8080")
+	server := application.NewServer(cfg)
 
 	// run the server
-	err := server.Start()
+	err = server.Start()
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("the server stopped", "error", err)
+		os.Exit(1)
 	}
 
 }
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// the scopes an API key can be granted, they are also the permissions the
+// roles of the bearer tokens grant
+const (
+	ScopeProductsRead = "products:read"
+	// ScopeProductsReadUnpublished allows reading the products with is_published=false
+	ScopeProductsReadUnpublished = "products:read:unpublished"
+	// ScopeProductsWrite allows changing every field but the protected ones, like the price
+	ScopeProductsWrite = "products:write"
+	// ScopeProductsWritePrice allows changing the price
+	ScopeProductsWritePrice = "products:write:price"
+	ScopeProductsDelete     = "products:delete"
+	// ScopeProductsConsumerPrice allows calculating the consumer price
+	ScopeProductsConsumerPrice = "products:consumer_price"
+	// ScopeAPIKeysAdmin allows managing the API keys
+	ScopeAPIKeysAdmin = "apikeys:admin"
+)
+
+// Scopes lists every scope
+var Scopes = []string{
+	ScopeProductsRead,
+	ScopeProductsReadUnpublished,
+	ScopeProductsWrite,
+	ScopeProductsWritePrice,
+	ScopeProductsDelete,
+	ScopeProductsConsumerPrice,
+	ScopeAPIKeysAdmin,
+}
+
+// IsValidScope reports whether scope is one of Scopes
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey authenticates a client. Only the SHA-256 hash of the secret is
+// stored, Prefix keeps its first characters so the key can be recognized.
+type APIKey struct {
+	ID     int
+	Name   string
+	Prefix string
+	Hash   string
+	Scopes []string
+	// ExpiresAt is the zero time for keys that never expire
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// Expired reports whether the key is expired at now
+func (k APIKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+type APIKeyRepository interface {
+	GetAllAPIKeys(ctx context.Context) ([]APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (APIKey, error)
+	AddAPIKey(ctx context.Context, key APIKey) (APIKey, error)
+	DeleteAPIKey(ctx context.Context, id int) error
+}
+
+type APIKeyService interface {
+	GetAllAPIKeys(ctx context.Context) ([]APIKey, error)
+	// CreateAPIKey stores a new key and returns it with its secret, which is not kept
+	CreateAPIKey(ctx context.Context, name string, scopes []string, expiresAt time.Time) (APIKey, string, error)
+	// EnsureAPIKey stores the key with the given secret unless it already exists
+	EnsureAPIKey(ctx context.Context, name, secret string, scopes []string) (APIKey, error)
+	DeleteAPIKey(ctx context.Context, id int) error
+	// Authenticate returns the principal of the key with the secret
+	Authenticate(ctx context.Context, secret string) (Principal, error)
+}
+
+var (
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+	ErrAPIKeyInvalid      = errors.New("invalid api key")
+	ErrAPIKeyExpired      = errors.New("api key expired")
+	ErrAPIKeyInvalidScope = errors.New("invalid api key scope")
+	ErrAPIKeyNameEmpty    = errors.New("api key name is empty")
+)
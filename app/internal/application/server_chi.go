@@ -1,76 +1,505 @@
 package application
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"goweb/app/internal"
+	"goweb/app/internal/config"
 	"goweb/app/internal/handler"
+	"goweb/app/internal/jwtauth"
+	appMetrics "goweb/app/internal/metrics"
 	"goweb/app/internal/middleware"
+	"goweb/app/internal/migrate"
+	"goweb/app/internal/ratelimit"
+	"goweb/app/internal/rbac"
 	"goweb/app/internal/repository"
 	"goweb/app/internal/service"
+	"goweb/app/internal/tracing"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type ServerChi struct {
-	address string
+	// server holds the address, the timeouts and the limits of the http.Server
+	server config.ServerConfig
+	// repository is the configuration of the products repository
+	repository config.RepositoryConfig
+	auth       config.AuthConfig
+	rateLimit  config.RateLimitConfig
+	// idempotency is where the responses of the idempotency keys are kept
+	idempotency config.IdempotencyConfig
+	tracing     config.TracingConfig
 }
 
-func NewServer(address string) *ServerChi {
-	defaultAddress := ":8080"
-	if address == "" {
-		address = defaultAddress
-	}
+// NewServer creates the server from a configuration already validated by config.Load
+func NewServer(cfg config.Config) *ServerChi {
 	return &ServerChi{
-		address: address,
+		server:      cfg.Server,
+		repository:  cfg.Repository,
+		auth:        cfg.Auth,
+		rateLimit:   cfg.RateLimit,
+		idempotency: cfg.Idempotency,
+		tracing:     cfg.Tracing,
 	}
 }
 
+// Start runs the server until it receives a SIGINT or a SIGTERM, then shuts it down gracefully
 func (s *ServerChi) Start() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return s.Run(ctx)
+}
+
+// Run listens on the configured address and serves until ctx is done
+func (s *ServerChi) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.server.Address)
+	if err != nil {
+		return fmt.Errorf("an error occurred while starting the server: %w", err)
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves the API on listener until ctx is done. Then it stops accepting
+// connections, waits up to the shutdown timeout for the requests in flight and
+// closes the repository and flushes the traces.
+func (s *ServerChi) Serve(ctx context.Context, listener net.Listener) error {
 
 	// Initialize the dependencies
 	// 1. Repository
 	// 2. Service
 	// 3. Handler
 
-	// 0. create the db connection
-	db, err := repository.NewMySQLConnection()
+	// the roles get their permissions from the policy file, or the default one
+	policy := rbac.Default()
+	if s.auth.RBACPolicyFile != "" {
+		var err error
+		if policy, err = rbac.Load(s.auth.RBACPolicyFile); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	// the spans are exported from the start, so the ones of the migrations check are too
+	shutdownTracing, err := tracing.Setup(ctx, s.tracing, os.Stdout)
 	if err != nil {
-		return errors.New("an error occurred while connecting to the database")
+		listener.Close()
+		return err
 	}
-	defer db.Close()
+	defer func() {
+		// flush the pending spans, the shutdown context may be done by now
+		flushCtx, cancel := context.WithTimeout(context.Background(), s.server.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Error("flushing the traces", "error", err)
+		}
+	}()
 
-	// 1. create the repo (aqui elijo especificamente que repo usar)
-	repo := repository.NewProductRepositorySQL(db)
-	// 2. create the service
-	service := service.NewProductService(repo)
-	// 3. create the handler
-	handler := handler.NewProductHandler(service)
+	// 1. create the repos for the configured backend
+	storage, err := newStorage(s.repository)
+	if err != nil {
+		listener.Close()
+		return err
+	}
 
-	// create a router with chi
-	router := chi.NewRouter()
+	// the repository operations are traced and measured and the state of the catalog and of the pool is exported
+	metrics := appMetrics.New()
+	products := metrics.InstrumentRepository(tracing.InstrumentRepository(storage.products, s.repository.Backend), s.repository.Backend)
+	collectors := []prometheus.Collector{appMetrics.NewCatalogCollector(storage.products)}
+	if pool, ok := storage.products.(appMetrics.PoolStater); ok {
+		collectors = append(collectors, appMetrics.NewPoolCollector(pool, s.repository.Backend))
+	}
+	if err := metrics.Register(collectors...); err != nil {
+		listener.Close()
+		storage.close()
+		return fmt.Errorf("registering the metrics: %w", err)
+	}
 
-	// add the middleware
-	router.Use(middleware.Logs, middleware.Auth)
+	// 2. create the api keys service, storing the bootstrap key
+	keys := service.NewAPIKeyService(storage.apiKeys)
+	if s.auth.BootstrapAPIKey != "" {
+		_, err := keys.EnsureAPIKey(ctx, "bootstrap", s.auth.BootstrapAPIKey, internal.Scopes)
+		if err != nil {
+			listener.Close()
+			storage.close()
+			return fmt.Errorf("storing the bootstrap api key: %w", err)
+		}
+	}
+
+	// the bearer tokens are verified against the keys of the jwks file
+	var tokens internal.TokenVerifier
+	if jwt := s.auth.JWT; jwt.JWKSFile != "" {
+		jwks, err := jwtauth.LoadJWKS(jwt.JWKSFile)
+		if err != nil {
+			listener.Close()
+			storage.close()
+			return err
+		}
+		tokens = jwtauth.NewVerifier(jwtauth.Config{
+			Keys:       jwks,
+			Issuer:     jwt.Issuer,
+			Audience:   jwt.Audience,
+			RolesClaim: jwt.RolesClaim,
+			Leeway:     jwt.Leeway,
+		})
+	}
 
-	// create the routes
-	router.Get("/ping", handler.Ping)
+	routeLimits := make(map[string]ratelimit.Limit, len(s.rateLimit.Routes))
+	for route, limit := range s.rateLimit.Routes {
+		routeLimits[route] = rateLimit(limit)
+	}
 
-	router.Route("/products", func(r chi.Router) {
-		r.Get("/", handler.GetAllProducts)
-		r.Get("/{id}", handler.GetProductByID)
-		r.Get("/search", handler.GetProductsByPriceGreaterThan)
-		r.Post("/", handler.CreateProduct)
-		r.Put("/{id}", handler.UpdateProduct)
-		r.Patch("/{id}", handler.ParcialUpdateProduct)
-		r.Delete("/{id}", handler.DeleteProduct)
+	// the idempotency keys are kept in memory unless they go to the database of the backend
+	var idempotency internal.IdempotencyStore = repository.NewIdempotencyStoreMap()
+	if s.idempotency.Store == config.IdempotencyStoreSQL {
+		idempotency = storage.idempotency
+	}
 
-		r.Get("/consumer_price", handler.CalculateConsumerPrice)
+	// 2-3. create the services, the handlers and the routes
+	router := NewRouter(Dependencies{
+		Products: products,
+		APIKeys:  keys,
+		Tokens:   tokens,
+		Policy:   policy,
+		RateLimits: middleware.RateLimits{
+			Store:   ratelimit.NewMemoryStore(),
+			IP:      rateLimit(s.rateLimit.IP),
+			Default: rateLimit(s.rateLimit.Default),
+			Routes:  routeLimits,
+		},
+		Idempotency: middleware.IdempotencyKeys{Store: idempotency, TTL: s.idempotency.TTL, Lease: s.idempotency.Lease},
+		Logger:      slog.Default(),
+		Metrics:     metrics,
 	})
 
+	// 4. create the server
+	server := &http.Server{
+		Handler:           router,
+		ReadHeaderTimeout: s.server.ReadHeaderTimeout,
+		ReadTimeout:       s.server.ReadTimeout,
+		WriteTimeout:      s.server.WriteTimeout,
+		IdleTimeout:       s.server.IdleTimeout,
+		MaxHeaderBytes:    s.server.MaxHeaderBytes,
+	}
+
 	// 5. start the server
-	err = http.ListenAndServe(":8080", router)
+	slog.Info("serving the API", "address", listener.Addr().String(), "repository", s.repository.Backend)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	var errs []error
+	select {
+	case err := <-served:
+		errs = append(errs, fmt.Errorf("an error occurred while running the server: %w", err))
+	case <-ctx.Done():
+		// 6. drain the requests in flight, cutting them at the deadline
+		slog.Info("shutting down, draining the requests in flight", "timeout", s.server.ShutdownTimeout.String())
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.server.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			server.Close()
+			errs = append(errs, fmt.Errorf("draining the requests: %w", err))
+		}
+		<-served
+	}
+
+	// 7. release the repository once no handler uses it
+	if err := storage.close(); err != nil {
+		errs = append(errs, fmt.Errorf("closing the repository: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// storage holds the repositories of the configured backend
+type storage struct {
+	products internal.ProductRepository
+	apiKeys  internal.APIKeyRepository
+	// idempotency keeps the idempotency keys in the database, nil for the backends without one
+	idempotency internal.IdempotencyStore
+	// close releases the backend, flushing the pending writes
+	close func() error
+}
+
+// newStorage creates the repositories for the configured backend. The memory
+// backends keep the API keys in memory too, the file and wal backends in an
+// api_keys.json file next to their data and the SQL backends in a table, as
+// they do with the idempotency keys.
+func newStorage(cfg config.RepositoryConfig) (storage, error) {
+
+	noClose := func() error { return nil }
+
+	switch cfg.Backend {
+	case config.BackendSlice:
+		repo := repository.NewRepository([]internal.Product{})
+		if err := repo.LoadData(cfg.SeedFile); err != nil {
+			return storage{}, err
+		}
+		return storage{products: repo, apiKeys: repository.NewAPIKeyRepositoryMap(), close: noClose}, nil
+	case config.BackendMap:
+		repo := repository.NewRepositoryMap(map[int]internal.Product{})
+		if err := repo.LoadData(cfg.SeedFile); err != nil {
+			return storage{}, err
+		}
+		return storage{products: repo, apiKeys: repository.NewAPIKeyRepositoryMap(), close: noClose}, nil
+	case config.BackendFile:
+		fsync := fsyncPolicy(cfg.File.Fsync)
+		repo := repository.NewRepositoryFile(cfg.File.Path, fsync)
+		apiKeys := repository.NewAPIKeyRepositoryFile(filepath.Join(filepath.Dir(cfg.File.Path), apiKeysFile), fsync)
+		return storage{products: repo, apiKeys: apiKeys, close: repo.Close}, nil
+	case config.BackendWAL:
+		fsync := fsyncPolicy(cfg.WAL.Fsync)
+		repo, err := repository.NewRepositoryWAL(cfg.WAL.Dir, fsync, cfg.WAL.CompactEvery)
+		if err != nil {
+			return storage{}, err
+		}
+		apiKeys := repository.NewAPIKeyRepositoryFile(filepath.Join(cfg.WAL.Dir, apiKeysFile), fsync)
+		return storage{products: repo, apiKeys: apiKeys, close: repo.Close}, nil
+	case config.BackendSQLite:
+		db, err := repository.NewSQLiteConnection(cfg.SQLite.Path)
+		if err != nil {
+			return storage{}, fmt.Errorf("an error occurred while connecting to the database: %w", err)
+		}
+		repo, err := repository.NewProductRepositorySQLite(db)
+		if err != nil {
+			db.Close()
+			return storage{}, err
+		}
+		apiKeys, err := repository.NewAPIKeyRepositorySQLite(db)
+		if err != nil {
+			db.Close()
+			return storage{}, err
+		}
+		idempotency, err := repository.NewIdempotencyStoreSQLite(db)
+		if err != nil {
+			db.Close()
+			return storage{}, err
+		}
+		return storage{products: repo, apiKeys: apiKeys, idempotency: idempotency, close: db.Close}, nil
+	case config.BackendMySQL:
+		db, err := OpenMySQL(cfg)
+		if err != nil {
+			return storage{}, err
+		}
+		// the schema is created by the migrate command, check it is up to date
+		migrator, err := migrate.New(db, migrate.MySQL)
+		if err == nil {
+			err = migrator.Check(context.Background())
+		}
+		if err != nil {
+			db.Close()
+			return storage{}, err
+		}
+		return storage{
+			products:    repository.NewProductRepositorySQL(db),
+			apiKeys:     repository.NewAPIKeyRepositorySQL(db),
+			idempotency: repository.NewIdempotencyStoreSQL(db),
+			close:       db.Close,
+		}, nil
+	case config.BackendPostgres:
+		db, err := repository.NewPostgresConnection(cfg.Postgres.DSN)
+		if err != nil {
+			return storage{}, fmt.Errorf("an error occurred while connecting to the database: %w", err)
+		}
+		configurePool(db, cfg.Pool)
+		repo, err := repository.NewProductRepositoryPostgres(db)
+		if err != nil {
+			db.Close()
+			return storage{}, err
+		}
+		apiKeys, err := repository.NewAPIKeyRepositoryPostgres(db)
+		if err != nil {
+			db.Close()
+			return storage{}, err
+		}
+		idempotency, err := repository.NewIdempotencyStorePostgres(db)
+		if err != nil {
+			db.Close()
+			return storage{}, err
+		}
+		return storage{products: repo, apiKeys: apiKeys, idempotency: idempotency, close: db.Close}, nil
+	}
+
+	return storage{}, fmt.Errorf("unknown repository %q", cfg.Backend)
+}
+
+// apiKeysFile is the name of the API keys file of the file and wal backends
+const apiKeysFile = "api_keys.json"
+
+// OpenMySQL connects to the MySQL database of the configuration and sizes its pool
+func OpenMySQL(cfg config.RepositoryConfig) (*sql.DB, error) {
+	db, err := repository.NewMySQLConnection(mysql.Config{
+		User:   cfg.MySQL.User,
+		Passwd: cfg.MySQL.Password,
+		Addr:   cfg.MySQL.Address,
+		DBName: cfg.MySQL.Database,
+	})
 	if err != nil {
-		return errors.New("an error occurred while starting the server")
+		return nil, fmt.Errorf("an error occurred while connecting to the database: %w", err)
+	}
+	configurePool(db, cfg.Pool)
+	return db, nil
+}
+
+// configurePool sizes the connection pool of db
+func configurePool(db *sql.DB, pool config.PoolConfig) {
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+}
+
+// fsyncPolicy converts the fsync setting of the file and wal backends
+func fsyncPolicy(fsync string) repository.FsyncPolicy {
+	if fsync == config.FsyncNever {
+		return repository.FsyncNever
+	}
+	return repository.FsyncAlways
+}
+
+// readinessTimeout bounds the checks of the readiness probe
+const readinessTimeout = 2 * time.Second
+
+// newHealthHandler checks the repository when it depends on a database or a file
+func newHealthHandler(repo internal.ProductRepository) *handler.HealthHandler {
+	var checks []handler.HealthCheck
+	if checker, ok := repo.(internal.HealthChecker); ok {
+		checks = append(checks, handler.HealthCheck{Name: "repository", Check: checker.HealthCheck})
 	}
-	return nil
+	return handler.NewHealthHandler(readinessTimeout, checks...)
+}
+
+// rateLimit converts a configured limit
+func rateLimit(limit config.RateLimit) ratelimit.Limit {
+	return ratelimit.Limit{Requests: limit.Requests, Period: limit.Period, Burst: limit.Burst}
+}
+
+// Dependencies are what the router is built on
+type Dependencies struct {
+	Products internal.ProductRepository
+	APIKeys  internal.APIKeyService
+	// Tokens verifies the bearer tokens, nil when they are not accepted
+	Tokens internal.TokenVerifier
+	// Policy grants the permissions of each role, nil for rbac.Default()
+	Policy *rbac.Policy
+	// RateLimits are the quotas of the clients, no limits without a Store
+	RateLimits middleware.RateLimits
+	// Idempotency keeps the responses of the POST /products with an Idempotency-Key header, the header is ignored without a Store
+	Idempotency middleware.IdempotencyKeys
+	// Logger writes the access logs, nil for slog.Default()
+	Logger *slog.Logger
+	// Metrics records the requests and serves /metrics, nil for a new metrics.New()
+	Metrics *appMetrics.Metrics
+}
+
+// NewRouter creates the services and the handlers on top of the dependencies
+// and returns the router serving the API
+func NewRouter(deps Dependencies) http.Handler {
+
+	policy := deps.Policy
+	if policy == nil {
+		policy = rbac.Default()
+	}
+
+	// 2. create the service, guarded so every handler gets the same rules, and traced
+	service := tracing.InstrumentService(rbac.NewProductServiceGuard(service.NewProductService(deps.Products), policy))
+	// 3. create the handlers
+	apiKeys := handler.NewAPIKeyHandler(deps.APIKeys)
+	health := newHealthHandler(deps.Products)
+	notFound := handler.NotFound
+	handler := handler.NewProductHandler(service)
+
+	logger := deps.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	metrics := deps.Metrics
+	if metrics == nil {
+		metrics = appMetrics.New()
+	}
+
+	// create a router with chi, every request is traced, gets an id for the logs and is measured
+	router := chi.NewRouter()
+	router.Use(middleware.Tracing, middleware.RequestID, middleware.Metrics(metrics))
+	router.NotFound(notFound)
+
+	// the probes go without authentication, so the orchestrator can call them
+	router.Get("/healthz", health.Liveness)
+	router.Get("/readyz", health.Readiness)
+	router.Method(http.MethodGet, "/metrics", metrics.Handler())
+
+	router.Group(func(router chi.Router) {
+
+		// add the middleware, every route needs an API key or a bearer token. Each
+		// IP takes a token before its credentials are checked, so the requests
+		// with bad credentials are throttled too, and each principal once identified.
+		router.Use(middleware.Logs(logger))
+		if limits := deps.RateLimits; limits.Store != nil {
+			router.Use(middleware.RateLimitIP(limits))
+		}
+		router.Use(middleware.Identify(deps.APIKeys, deps.Tokens))
+		if limits := deps.RateLimits; limits.Store != nil {
+			// the route pattern picks the bucket, so it is resolved before the routing
+			limits.Route = func(r *http.Request) string {
+				rctx := chi.NewRouteContext()
+				if !router.Match(rctx, r.Method, r.URL.Path) {
+					return ""
+				}
+				return rctx.RoutePattern()
+			}
+			router.Use(middleware.RateLimit(limits))
+		}
+		router.Use(middleware.RequireAuthentication)
+
+		// the permission each route requires, the guarded service checks the fields and the unpublished products
+		read := middleware.Require(policy, internal.ScopeProductsRead)
+		create := middleware.Require(policy, internal.ScopeProductsWrite)
+		update := middleware.Require(policy, policy.WritePermissions()...)
+		delete := middleware.Require(policy, internal.ScopeProductsDelete)
+		consumerPrice := middleware.Require(policy, internal.ScopeProductsConsumerPrice)
+		admin := middleware.Require(policy, internal.ScopeAPIKeysAdmin)
+
+		// a retried creation gets the response of the first one instead of creating the product twice
+		idempotent := func(next http.Handler) http.Handler { return next }
+		if deps.Idempotency.Store != nil {
+			idempotent = middleware.Idempotent(deps.Idempotency)
+		}
+
+		// create the routes
+		router.Get("/ping", handler.Ping)
+
+		router.Route("/products", func(r chi.Router) {
+			r.With(read).Get("/", handler.GetAllProducts)
+			r.With(read).Get("/{id}", handler.GetProductByID)
+			r.With(read).Get("/search", handler.SearchProducts)
+			r.With(create, idempotent).Post("/", handler.CreateProduct)
+			r.With(update).Put("/{id}", handler.UpdateProduct)
+			r.With(update).Patch("/{id}", handler.ParcialUpdateProduct)
+			r.With(delete).Delete("/{id}", handler.DeleteProduct)
+
+			r.With(consumerPrice).Get("/consumer_price", handler.CalculateConsumerPrice)
+		})
+
+		router.Route("/admin/apikeys", func(r chi.Router) {
+			r.Use(admin)
+			r.Get("/", apiKeys.GetAllAPIKeys)
+			r.Post("/", apiKeys.CreateAPIKey)
+			r.Delete("/{id}", apiKeys.DeleteAPIKey)
+		})
+	})
+
+	return router
 }
@@ -0,0 +1,808 @@
+package application_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"goweb/app/internal"
+	"goweb/app/internal/application"
+	"goweb/app/internal/config"
+	"goweb/app/internal/logging"
+	"goweb/app/internal/middleware"
+	"goweb/app/internal/ratelimit"
+	"goweb/app/internal/repository"
+	"goweb/app/internal/service"
+	"goweb/app/internal/tracing"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// the whole HTTP stack runs against a real SQL engine, with no outside services
+func TestServerWithSQLite(t *testing.T) {
+
+	// Arrange
+	db, err := repository.NewSQLiteConnection(":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	repo, err := repository.NewProductRepositorySQLite(db)
+	require.NoError(t, err)
+	apiKeys, err := repository.NewAPIKeyRepositorySQLite(db)
+	require.NoError(t, err)
+	keys := service.NewAPIKeyService(apiKeys)
+	_, err = keys.EnsureAPIKey(context.Background(), "admin", adminKey, internal.Scopes)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(application.NewRouter(application.Dependencies{Products: repo, APIKeys: keys}))
+	defer server.Close()
+
+	doWithKey := func(key, method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if key != "" {
+			req.Header.Set("Authorization", "ApiKey "+key)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		resBody, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(resBody)
+	}
+	do := func(method, path, body string) (int, string) {
+		return doWithKey(adminKey, method, path, body)
+	}
+
+	t.Run("Se crea, busca, modifica y elimina un producto", func(t *testing.T) {
+		// Act
+		code, body := do("POST", "/products", `{"name":"Wine - Red Oakridge Merlot","quantity":10,"code_value":"W1","is_published":true,"expiration":"31/12/2021","price":100}`)
+		require.Equal(t, http.StatusCreated, code, body)
+		require.JSONEq(t, `{"id":1,"name":"Wine - Red Oakridge Merlot","quantity":10,"code_value":"W1","is_published":true,"expiration":"31/12/2021","price":100}`, body)
+
+		code, body = do("POST", "/products", `{"name":"Bread - Baguette","quantity":5,"code_value":"B1","is_published":false,"expiration":"01/06/2022","price":20}`)
+		require.Equal(t, http.StatusCreated, code, body)
+
+		code, body = do("GET", "/products?q=merl", "")
+		require.Equal(t, http.StatusOK, code, body)
+		require.JSONEq(t, `{"products":[{"id":1,"name":"Wine - Red Oakridge Merlot","quantity":10,"code_value":"W1","is_published":true,"expiration":"31/12/2021","price":100}],"total":1,"limit":50,"offset":0}`, body)
+
+		code, body = do("GET", "/products/search?filter=expiration>=2022-01-01%20OR%20price>50&sort=-price&limit=1", "")
+		require.Equal(t, http.StatusOK, code, body)
+		require.JSONEq(t, `{"products":[{"id":1,"name":"Wine - Red Oakridge Merlot","quantity":10,"code_value":"W1","is_published":true,"expiration":"31/12/2021","price":100}],"total":2,"limit":1,"offset":0,"next":"/products/search?filter=expiration%3E%3D2022-01-01+OR+price%3E50&limit=1&offset=1&sort=-price"}`, body)
+
+		code, body = do("PATCH", "/products/2", `{"name":"Bread - Focaccia"}`)
+		require.Equal(t, http.StatusOK, code, body)
+
+		code, body = do("GET", "/products?q=focaccia", "")
+		require.Equal(t, http.StatusOK, code, body)
+		require.Contains(t, body, `"total":1`)
+
+		code, _ = do("DELETE", "/products/2", "")
+		require.Equal(t, http.StatusNoContent, code)
+
+		code, _ = do("GET", "/products/2", "")
+		require.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("Las API keys habilitan las rutas segun sus scopes", func(t *testing.T) {
+		// Arrange
+		code, body := do("POST", "/admin/apikeys", `{"name":"catalog reader","scopes":["products:read"]}`)
+		require.Equal(t, http.StatusCreated, code, body)
+		var created struct {
+			ID  int    `json:"id"`
+			Key string `json:"key"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(body), &created))
+
+		// Act
+		readCode, _ := doWithKey(created.Key, "GET", "/products", "")
+		deleteCode, deleteBody := doWithKey(created.Key, "DELETE", "/products/1", "")
+		adminCode, _ := doWithKey(created.Key, "GET", "/admin/apikeys", "")
+		anonymousCode, _ := doWithKey("", "GET", "/products", "")
+		wrongCode, _ := doWithKey("not-a-key", "GET", "/products", "")
+		_, list := do("GET", "/admin/apikeys", "")
+		revokeCode, _ := do("DELETE", "/admin/apikeys/"+strconv.Itoa(created.ID), "")
+		revokedCode, _ := doWithKey(created.Key, "GET", "/products", "")
+
+		// Assert
+		require.Equal(t, http.StatusOK, readCode)
+		require.Equal(t, http.StatusForbidden, deleteCode)
+		require.Contains(t, deleteBody, `"code":"forbidden"`)
+		require.Contains(t, deleteBody, `"detail":"Forbidden: the products:delete permission is required"`)
+		require.Equal(t, http.StatusForbidden, adminCode)
+		require.Equal(t, http.StatusUnauthorized, anonymousCode)
+		require.Equal(t, http.StatusUnauthorized, wrongCode)
+		require.Contains(t, list, `"name":"catalog reader"`)
+		require.NotContains(t, list, created.Key)
+		require.NotContains(t, list, `"hash"`)
+		require.Equal(t, http.StatusNoContent, revokeCode)
+		require.Equal(t, http.StatusUnauthorized, revokedCode)
+	})
+}
+
+// adminKey has every scope in the tests
+const adminKey = "test-admin-key-0123456789"
+
+// the probes answer without the Authorization header and readiness follows the database
+func TestServerProbes(t *testing.T) {
+
+	// Arrange
+	db, err := repository.NewSQLiteConnection(":memory:")
+	require.NoError(t, err)
+	repo, err := repository.NewProductRepositorySQLite(db)
+	require.NoError(t, err)
+	keys := service.NewAPIKeyService(repository.NewAPIKeyRepositoryMap())
+	server := httptest.NewServer(application.NewRouter(application.Dependencies{Products: repo, APIKeys: keys}))
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		res, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(body)
+	}
+
+	// Act
+	livenessCode, _ := get("/healthz")
+	readyCode, readyBody := get("/readyz")
+	db.Close()
+	downCode, downBody := get("/readyz")
+	pingCode, _ := get("/ping")
+
+	// Assert
+	require.Equal(t, http.StatusOK, livenessCode)
+	require.Equal(t, http.StatusOK, readyCode, readyBody)
+	require.Contains(t, readyBody, `"repository":{"status":"ok"`)
+	require.Equal(t, http.StatusServiceUnavailable, downCode, downBody)
+	require.Contains(t, downBody, `"error":"pinging the database: sql: database is closed"`)
+	require.Equal(t, http.StatusUnauthorized, pingCode)
+}
+
+// a request in flight when the server is told to stop still gets its response,
+// and its write is on disk once Serve returns
+func TestServerChi_GracefulShutdown(t *testing.T) {
+
+	// Arrange
+	cfg := config.Default()
+	cfg.Repository.Backend = config.BackendFile
+	cfg.Repository.File.Path = filepath.Join(t.TempDir(), "products.json")
+	cfg.Repository.File.Fsync = config.FsyncNever
+	cfg.Server.ShutdownTimeout = 5 * time.Second
+	cfg.Auth.BootstrapAPIKey = adminKey
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	served := make(chan error, 1)
+	go func() {
+		served <- application.NewServer(cfg).Serve(ctx, listener)
+	}()
+
+	// the body is sent in two parts over a raw connection, so the request is
+	// in flight when the server stops
+	payload := `{"name":"Bread - Baguette","quantity":5,"code_value":"B1","is_published":true,"expiration":"01/06/2022","price":20}`
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "POST /products HTTP/1.1\r\nHost: test\r\nX-API-Key: %s\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", adminKey, len(payload), payload[:20])
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	// Act
+	stop()
+	time.Sleep(100 * time.Millisecond)
+	_, err = conn.Write([]byte(payload[20:]))
+	require.NoError(t, err)
+
+	// Assert
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	require.NoError(t, <-served)
+
+	_, err = net.Dial("tcp", listener.Addr().String())
+	require.Error(t, err)
+
+	products, err := repository.NewRepositoryFile(cfg.Repository.File.Path, repository.FsyncNever).GetAllProducts(context.Background())
+	require.NoError(t, err)
+	require.Len(t, products, 1)
+}
+
+// the bearer tokens are verified against the configured jwks file and their
+// roles decide the routes they can use
+func TestServerChi_BearerTokens(t *testing.T) {
+
+	// Arrange
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"oct","kid":"main","alg":"HS256","k":%q}]}`, base64.RawURLEncoding.EncodeToString(secret))
+	require.NoError(t, os.WriteFile(jwksPath, []byte(jwks), 0o644))
+
+	cfg := config.Default()
+	cfg.Repository.Backend = config.BackendFile
+	cfg.Repository.File.Path = filepath.Join(t.TempDir(), "products.json")
+	cfg.Repository.File.Fsync = config.FsyncNever
+	cfg.Auth.JWT.JWKSFile = jwksPath
+	cfg.Auth.JWT.Issuer = "https://auth.example.com"
+	cfg.Auth.JWT.Audience = "products-api"
+	require.NoError(t, cfg.Validate())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- application.NewServer(cfg).Serve(ctx, listener)
+	}()
+	defer func() {
+		stop()
+		require.NoError(t, <-served)
+	}()
+
+	token := func(roles ...string) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":   "user-1",
+			"iss":   "https://auth.example.com",
+			"aud":   "products-api",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"roles": roles,
+		}).SignedString(secret)
+		require.NoError(t, err)
+		return signed
+	}
+	do := func(token, method, path, body string) int {
+		req, err := http.NewRequest(method, "http://"+listener.Addr().String()+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	product := `{"name":"Bread - Baguette","quantity":5,"code_value":"B1","is_published":true,"expiration":"01/06/2022","price":0}`
+	priced := `{"name":"Bread - Baguette","quantity":5,"code_value":"B2","is_published":true,"expiration":"01/06/2022","price":20}`
+
+	// Act
+	viewerGet := do(token("viewer"), "GET", "/products", "")
+	viewerPost := do(token("viewer"), "POST", "/products", product)
+	editorPost := do(token("editor"), "POST", "/products", product)
+	editorPricedPost := do(token("editor"), "POST", "/products", priced)
+	editorAdmin := do(token("editor"), "GET", "/admin/apikeys", "")
+	adminDelete := do(token("admin"), "DELETE", "/products/1", "")
+	noRoles := do(token(), "GET", "/products", "")
+	forged := do(token("admin")+"x", "GET", "/products", "")
+
+	// Assert
+	require.Equal(t, http.StatusOK, viewerGet)
+	require.Equal(t, http.StatusForbidden, viewerPost)
+	require.Equal(t, http.StatusCreated, editorPost)
+	require.Equal(t, http.StatusForbidden, editorPricedPost)
+	require.Equal(t, http.StatusForbidden, editorAdmin)
+	require.Equal(t, http.StatusNoContent, adminDelete)
+	require.Equal(t, http.StatusForbidden, noRoles)
+	require.Equal(t, http.StatusUnauthorized, forged)
+}
+
+// roleTokens authenticates the bearer token "<role>" as a principal with that role
+type roleTokens struct{}
+
+func (roleTokens) VerifyToken(ctx context.Context, token string) (internal.Principal, error) {
+	return internal.Principal{ID: "jwt:" + token, Roles: []string{token}}, nil
+}
+
+// the default policy holds for every route, whether the price changes with PUT or with PATCH
+func TestServerChi_RBAC(t *testing.T) {
+
+	// Arrange
+	repo := repository.NewRepositoryMap(map[int]internal.Product{})
+	keys := service.NewAPIKeyService(repository.NewAPIKeyRepositoryMap())
+	server := httptest.NewServer(application.NewRouter(application.Dependencies{Products: repo, APIKeys: keys, Tokens: roleTokens{}}))
+	defer server.Close()
+
+	do := func(role, method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+role)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		resBody, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(resBody)
+	}
+	code, body := do("admin", "POST", "/products", `{"name":"Wine - Red Oakridge Merlot","quantity":10,"code_value":"W1","is_published":true,"expiration":"31/12/2021","price":100}`)
+	require.Equal(t, http.StatusCreated, code, body)
+	code, body = do("admin", "POST", "/products", `{"name":"Bread - Baguette","quantity":5,"code_value":"B1","is_published":false,"expiration":"01/06/2022","price":20}`)
+	require.Equal(t, http.StatusCreated, code, body)
+
+	t.Run("Los viewers solo leen productos publicados", func(t *testing.T) {
+		// Act
+		listCode, list := do("viewer", "GET", "/products", "")
+		searchCode, search := do("viewer", "GET", "/products/search?filter=price<50", "")
+		hiddenCode, _ := do("viewer", "GET", "/products/2", "")
+		priceCode, _ := do("viewer", "GET", "/products/consumer_price", "")
+		_, editorList := do("editor", "GET", "/products", "")
+
+		// Assert
+		require.Equal(t, http.StatusOK, listCode)
+		require.Contains(t, list, `"total":1`)
+		require.NotContains(t, list, "Baguette")
+		require.Equal(t, http.StatusOK, searchCode)
+		require.Contains(t, search, `"total":0`)
+		require.Equal(t, http.StatusNotFound, hiddenCode)
+		require.Equal(t, http.StatusForbidden, priceCode)
+		require.Contains(t, editorList, `"total":2`)
+	})
+
+	t.Run("Los editores modifican todo menos el precio", func(t *testing.T) {
+		// Act
+		putCode, putBody := do("editor", "PUT", "/products/1", `{"name":"Wine - Red Oakridge Merlot","quantity":10,"code_value":"W1","is_published":true,"expiration":"31/12/2021","price":1}`)
+		patchCode, patchBody := do("editor", "PATCH", "/products/1", `{"price":1}`)
+		nameCode, _ := do("editor", "PATCH", "/products/1", `{"name":"Wine - Merlot","quantity":12}`)
+
+		// Assert
+		require.Equal(t, http.StatusForbidden, putCode)
+		require.Contains(t, putBody, `"detail":"forbidden: changing price requires the products:write:price permission"`)
+		require.Equal(t, http.StatusForbidden, patchCode)
+		require.Equal(t, putBody[:strings.Index(putBody, `"request_id"`)], patchBody[:strings.Index(patchBody, `"request_id"`)])
+		require.Equal(t, http.StatusOK, nameCode)
+	})
+
+	t.Run("Los pricing managers modifican el precio de cualquier producto", func(t *testing.T) {
+		// Act
+		patchCode, patchBody := do("pricing_manager", "PATCH", "/products/2", `{"price":25}`)
+		putCode, putBody := do("pricing_manager", "PUT", "/products/1", `{"name":"Wine - Merlot","quantity":12,"code_value":"W1","is_published":true,"expiration":"31/12/2021","price":90}`)
+		nameCode, _ := do("pricing_manager", "PATCH", "/products/1", `{"name":"Wine"}`)
+		createCode, _ := do("pricing_manager", "POST", "/products", `{"name":"Cheese","quantity":1,"code_value":"C1","is_published":true,"expiration":"01/06/2022","price":5}`)
+		priceCode, priceBody := do("pricing_manager", "GET", "/products/consumer_price?list=[2]", "")
+
+		// Assert
+		require.Equal(t, http.StatusOK, patchCode, patchBody)
+		require.Contains(t, patchBody, `"price":25`)
+		require.Equal(t, http.StatusOK, putCode, putBody)
+		require.Contains(t, putBody, `"price":90`)
+		require.Equal(t, http.StatusForbidden, nameCode)
+		require.Equal(t, http.StatusForbidden, createCode)
+		require.Equal(t, http.StatusOK, priceCode, priceBody)
+		require.Contains(t, priceBody, "Baguette")
+	})
+}
+
+// the quotas are per client and the routes with a limit of their own do not use the default bucket
+func TestServerChi_RateLimit(t *testing.T) {
+
+	// Arrange
+	repo := repository.NewRepositoryMap(map[int]internal.Product{})
+	keys := service.NewAPIKeyService(repository.NewAPIKeyRepositoryMap())
+	server := httptest.NewServer(application.NewRouter(application.Dependencies{
+		Products: repo,
+		APIKeys:  keys,
+		Tokens:   roleTokens{},
+		RateLimits: middleware.RateLimits{
+			Store:   ratelimit.NewMemoryStore(),
+			Default: ratelimit.Limit{Requests: 100, Period: time.Minute, Burst: 10},
+			Routes: map[string]ratelimit.Limit{
+				"GET /products/consumer_price": {Requests: 1, Period: time.Minute, Burst: 2},
+			},
+		},
+	}))
+	defer server.Close()
+
+	do := func(role, path string) *http.Response {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+role)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	// Act
+	first := do("admin", "/products/consumer_price")
+	second := do("admin", "/products/consumer_price?list=[1]")
+	limited := do("admin", "/products/consumer_price")
+	other := do("pricing_manager", "/products/consumer_price")
+	list := do("admin", "/products")
+
+	// Assert
+	require.Equal(t, http.StatusOK, first.StatusCode)
+	require.Equal(t, "2", first.Header.Get("RateLimit-Limit"))
+	require.Equal(t, "1", first.Header.Get("RateLimit-Remaining"))
+	require.Equal(t, http.StatusOK, second.StatusCode)
+	require.Equal(t, http.StatusTooManyRequests, limited.StatusCode)
+	require.Equal(t, "0", limited.Header.Get("RateLimit-Remaining"))
+	require.Equal(t, "60", limited.Header.Get("Retry-After"))
+	require.Equal(t, "120", limited.Header.Get("RateLimit-Reset"))
+	require.Equal(t, http.StatusOK, other.StatusCode)
+	require.Equal(t, http.StatusOK, list.StatusCode)
+	require.Equal(t, "9", list.Header.Get("RateLimit-Remaining"))
+}
+
+// countingAPIKeys counts the secrets looked up
+type countingAPIKeys struct {
+	internal.APIKeyService
+	lookups atomic.Int32
+}
+
+func (k *countingAPIKeys) Authenticate(ctx context.Context, secret string) (internal.Principal, error) {
+	k.lookups.Add(1)
+	return k.APIKeyService.Authenticate(ctx, secret)
+}
+
+// the requests take a token from the bucket of their IP before the credentials
+// are checked, so the ones throttled never reach the API keys
+func TestServerChi_RateLimitUnauthenticated(t *testing.T) {
+
+	// Arrange
+	repo := repository.NewRepositoryMap(map[int]internal.Product{})
+	keys := &countingAPIKeys{APIKeyService: service.NewAPIKeyService(repository.NewAPIKeyRepositoryMap())}
+	server := httptest.NewServer(application.NewRouter(application.Dependencies{
+		Products: repo,
+		APIKeys:  keys,
+		Tokens:   roleTokens{},
+		RateLimits: middleware.RateLimits{
+			Store:   ratelimit.NewMemoryStore(),
+			IP:      ratelimit.Limit{Requests: 1, Period: time.Minute, Burst: 3},
+			Default: ratelimit.Limit{Requests: 1, Period: time.Minute, Burst: 2},
+		},
+	}))
+	defer server.Close()
+
+	do := func(authorization string) *http.Response {
+		req, err := http.NewRequest("GET", server.URL+"/products", nil)
+		require.NoError(t, err)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	// Act
+	anonymous := do("")
+	guess := do("ApiKey gwk_guessed")
+	authenticated := do("Bearer admin")
+	limited := do("ApiKey gwk_guessed_again")
+	limitedAuthenticated := do("Bearer admin")
+
+	// Assert
+	require.Equal(t, http.StatusUnauthorized, anonymous.StatusCode)
+	require.Equal(t, "2", anonymous.Header.Get("RateLimit-Remaining"))
+	require.Equal(t, http.StatusUnauthorized, guess.StatusCode)
+	require.Equal(t, http.StatusOK, authenticated.StatusCode)
+	require.Equal(t, "1", authenticated.Header.Get("RateLimit-Remaining"))
+	require.Equal(t, http.StatusTooManyRequests, limited.StatusCode)
+	require.Equal(t, "60", limited.Header.Get("Retry-After"))
+	require.Equal(t, http.StatusTooManyRequests, limitedAuthenticated.StatusCode)
+	// only the requests before the IP ran out of tokens looked up their key
+	require.Equal(t, int32(2), keys.lookups.Load())
+}
+
+// a retried creation with the same Idempotency-Key gets the first response
+// instead of creating the product again, whichever store keeps the keys
+func TestServerChi_Idempotency(t *testing.T) {
+
+	// Arrange
+	db, err := repository.NewSQLiteConnection(":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	repo, err := repository.NewProductRepositorySQLite(db)
+	require.NoError(t, err)
+	store, err := repository.NewIdempotencyStoreSQLite(db)
+	require.NoError(t, err)
+	keys := service.NewAPIKeyService(repository.NewAPIKeyRepositoryMap())
+	server := httptest.NewServer(application.NewRouter(application.Dependencies{
+		Products:    repo,
+		APIKeys:     keys,
+		Tokens:      roleTokens{},
+		Idempotency: middleware.IdempotencyKeys{Store: store, TTL: time.Hour, Lease: time.Minute},
+	}))
+	defer server.Close()
+	// every key of this server expires as soon as it is stored
+	expiring := httptest.NewServer(application.NewRouter(application.Dependencies{
+		Products:    repo,
+		APIKeys:     keys,
+		Tokens:      roleTokens{},
+		Idempotency: middleware.IdempotencyKeys{Store: repository.NewIdempotencyStoreMap(), TTL: time.Nanosecond, Lease: time.Minute},
+	}))
+	defer expiring.Close()
+
+	do := func(url, role, key, body string) (*http.Response, string) {
+		req, err := http.NewRequest("POST", url+"/products", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+role)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		data, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(data)
+	}
+	wine := `{"name":"Wine","quantity":10,"code_value":"W1","is_published":true,"expiration":"31/12/2021","price":100}`
+	bread := `{"name":"Bread","quantity":5,"code_value":"B1","is_published":true,"expiration":"01/06/2022","price":20}`
+	cheese := `{"name":"Cheese","quantity":3,"code_value":"C1","is_published":true,"expiration":"01/06/2022","price":30}`
+
+	// Act
+	first, firstBody := do(server.URL, "admin", "create-wine", wine)
+	retry, retryBody := do(server.URL, "admin", "create-wine", wine)
+	reused, reusedBody := do(server.URL, "admin", "create-wine", bread)
+	// an editor cannot set the price
+	otherPrincipal, _ := do(server.URL, "editor", "create-wine", strings.Replace(bread, `"price":20`, `"price":0`, 1))
+	invalid, invalidBody := do(server.URL, "admin", strings.Repeat("k", 256), cheese)
+	expired, expiredBody := do(expiring.URL, "admin", "create-cheese", cheese)
+	afterExpiry, afterExpiryBody := do(expiring.URL, "admin", "create-cheese", `{"name":"Cheese","quantity":3,"code_value":"C2","is_published":true,"expiration":"01/06/2022","price":30}`)
+	products, err := repo.GetAllProducts(context.Background())
+	require.NoError(t, err)
+
+	// Assert
+	require.Equal(t, http.StatusCreated, first.StatusCode, firstBody)
+	require.Empty(t, first.Header.Get("Idempotent-Replayed"))
+	require.Equal(t, http.StatusCreated, retry.StatusCode, retryBody)
+	require.Equal(t, "true", retry.Header.Get("Idempotent-Replayed"))
+	require.Equal(t, firstBody, retryBody)
+	require.Equal(t, first.Header.Get("ETag"), retry.Header.Get("ETag"))
+	require.Equal(t, "application/json", retry.Header.Get("Content-Type"))
+	require.Equal(t, http.StatusUnprocessableEntity, reused.StatusCode)
+	require.Contains(t, reusedBody, `"code":"idempotency_key_reused"`)
+	require.Equal(t, http.StatusCreated, otherPrincipal.StatusCode)
+	require.Equal(t, http.StatusBadRequest, invalid.StatusCode)
+	require.Contains(t, invalidBody, `"code":"invalid_idempotency_key"`)
+	require.Equal(t, http.StatusCreated, expired.StatusCode, expiredBody)
+	require.Equal(t, http.StatusCreated, afterExpiry.StatusCode, afterExpiryBody)
+	require.Empty(t, afterExpiry.Header.Get("Idempotent-Replayed"))
+	require.Len(t, products, 4)
+}
+
+// panickingRepository panics on the first AddProduct
+type panickingRepository struct {
+	internal.ProductRepository
+	once sync.Once
+}
+
+func (r *panickingRepository) AddProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+	r.once.Do(func() { panic("the repository broke") })
+	return r.ProductRepository.AddProduct(ctx, product)
+}
+
+// leaseStore records until when the keys are reserved and completed
+type leaseStore struct {
+	internal.IdempotencyStore
+	mu        sync.Mutex
+	reserved  time.Time
+	completed time.Time
+}
+
+func (s *leaseStore) Reserve(ctx context.Context, record internal.IdempotencyRecord) (internal.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	s.reserved = record.ExpiresAt
+	s.mu.Unlock()
+	return s.IdempotencyStore.Reserve(ctx, record)
+}
+
+func (s *leaseStore) Complete(ctx context.Context, record internal.IdempotencyRecord) error {
+	s.mu.Lock()
+	s.completed = record.ExpiresAt
+	s.mu.Unlock()
+	return s.IdempotencyStore.Complete(ctx, record)
+}
+
+// a key is reserved only for its lease while the request is served, and a
+// handler that panics releases it, so the retry is served instead of a 409
+func TestServerChi_IdempotencyRelease(t *testing.T) {
+
+	// Arrange
+	repo := &panickingRepository{ProductRepository: repository.NewRepositoryMap(map[int]internal.Product{})}
+	store := &leaseStore{IdempotencyStore: repository.NewIdempotencyStoreMap()}
+	keys := service.NewAPIKeyService(repository.NewAPIKeyRepositoryMap())
+	server := httptest.NewUnstartedServer(application.NewRouter(application.Dependencies{
+		Products:    repo,
+		APIKeys:     keys,
+		Tokens:      roleTokens{},
+		Idempotency: middleware.IdempotencyKeys{Store: store, TTL: time.Hour, Lease: time.Minute},
+	}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.Start()
+	defer server.Close()
+	// without reusing the connections the client does not resend the request that broke it
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	post := func() (*http.Response, error) {
+		req, err := http.NewRequest("POST", server.URL+"/products", strings.NewReader(`{"name":"Wine","quantity":10,"code_value":"W1","is_published":true,"expiration":"31/12/2021","price":100}`))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer admin")
+		req.Header.Set("Idempotency-Key", "create-wine")
+		return client.Do(req)
+	}
+
+	// Act
+	start := time.Now()
+	_, panicked := post()
+	retry, err := post()
+	require.NoError(t, err)
+	retry.Body.Close()
+
+	// Assert
+	require.Error(t, panicked)
+	require.Equal(t, http.StatusCreated, retry.StatusCode)
+	require.Empty(t, retry.Header.Get("Idempotent-Replayed"))
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	require.WithinDuration(t, start.Add(time.Minute), store.reserved, 5*time.Second)
+	require.WithinDuration(t, start.Add(time.Hour), store.completed, 5*time.Second)
+}
+
+// each request gets an id, the one of the client if it sends a valid one, and
+// the access log carries it with the status and the size of the response
+func TestServerChi_AccessLogs(t *testing.T) {
+
+	// Arrange
+	var logs bytes.Buffer
+	logger, err := logging.New(&logs, config.LogConfig{Level: "info", Format: config.LogFormatJSON})
+	require.NoError(t, err)
+	repo := repository.NewRepositoryMap(map[int]internal.Product{})
+	keys := service.NewAPIKeyService(repository.NewAPIKeyRepositoryMap())
+	server := httptest.NewServer(application.NewRouter(application.Dependencies{Products: repo, APIKeys: keys, Tokens: roleTokens{}, Logger: logger}))
+	defer server.Close()
+
+	get := func(path, requestID string) *http.Response {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer viewer")
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	// Act
+	given := get("/products/7", "req-123")
+	generated := get("/ping", "bad id")
+
+	// Assert
+	require.Equal(t, "req-123", given.Header.Get("X-Request-ID"))
+	require.Len(t, generated.Header.Get("X-Request-ID"), 32)
+	var entries []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(line, &entry))
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 2)
+	require.Equal(t, "request", entries[0]["msg"])
+	require.Equal(t, "req-123", entries[0]["request_id"])
+	require.Equal(t, "/products/7", entries[0]["path"])
+	require.EqualValues(t, http.StatusNotFound, entries[0]["status"])
+	require.EqualValues(t, len(`{"type":"/problems/product_not_found","title":"Not Found","status":404,"detail":"No products found","instance":"/products/7","code":"product_not_found","request_id":"req-123"}`+"\n"), entries[0]["response_bytes"])
+	require.Equal(t, generated.Header.Get("X-Request-ID"), entries[1]["request_id"])
+	require.EqualValues(t, len("pong"), entries[1]["response_bytes"])
+}
+
+// the requests are counted by route pattern, so every id falls in the same series
+func TestServerChi_Metrics(t *testing.T) {
+
+	// Arrange
+	repo := repository.NewRepositoryMap(map[int]internal.Product{})
+	keys := service.NewAPIKeyService(repository.NewAPIKeyRepositoryMap())
+	server := httptest.NewServer(application.NewRouter(application.Dependencies{Products: repo, APIKeys: keys, Tokens: roleTokens{}}))
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer viewer")
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(body)
+	}
+
+	// Act
+	get("/products/1")
+	get("/products/2")
+	get("/products")
+	get("/no/such/route")
+	code, body := get("/metrics")
+
+	// Assert
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `http_requests_total{method="GET",route="/products/{id}",status="404"} 2`)
+	require.Contains(t, body, `http_requests_total{method="GET",route="/products",status="200"} 1`)
+	require.Contains(t, body, `http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	require.Contains(t, body, `http_request_duration_seconds_count{method="GET",route="/products/{id}",status="404"} 2`)
+}
+
+// a PATCH continues the trace of the client down to the SQL statements
+func TestServerChi_Tracing(t *testing.T) {
+
+	// Arrange
+	spans := tracetest.NewInMemoryExporter()
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	}()
+
+	db, err := repository.NewSQLiteConnection(":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	repo, err := repository.NewProductRepositorySQLite(db)
+	require.NoError(t, err)
+	_, err = repo.AddProduct(context.Background(), internal.Product{Name: "Wine - Red Oakridge Merlot", Quantity: 10, CodeValue: "W1", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 100})
+	require.NoError(t, err)
+	keys := service.NewAPIKeyService(repository.NewAPIKeyRepositoryMap())
+	products := tracing.InstrumentRepository(repo, config.BackendSQLite)
+	server := httptest.NewServer(application.NewRouter(application.Dependencies{Products: products, APIKeys: keys, Tokens: roleTokens{}}))
+	defer server.Close()
+	spans.Reset()
+
+	req, err := http.NewRequest("PATCH", server.URL+"/products/1", strings.NewReader(`{"quantity":20}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer editor")
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	// Act
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	// Assert
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	byName := map[string]tracetest.SpanStub{}
+	for _, span := range spans.GetSpans() {
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String(), span.Name)
+		if _, ok := byName[span.Name]; !ok {
+			byName[span.Name] = span
+		}
+	}
+	route := byName["PATCH /products/{id}"]
+	require.Equal(t, "00f067aa0ba902b7", route.Parent.SpanID().String())
+	require.Contains(t, route.Attributes, attribute.Int("http.response.status_code", http.StatusOK))
+	update := byName["ProductService.UpdateProduct"]
+	require.Equal(t, route.SpanContext.SpanID(), update.Parent.SpanID())
+	repoUpdate := byName["ProductRepository.UpdateProduct"]
+	require.Equal(t, update.SpanContext.SpanID(), repoUpdate.Parent.SpanID())
+	statement := byName["sql UPDATE"]
+	require.Equal(t, repoUpdate.SpanContext.SpanID(), statement.Parent.SpanID())
+	require.Contains(t, statement.Attributes, attribute.String("db.system", "sqlite"))
+	require.Contains(t, statement.Attributes, attribute.String("db.statement", "UPDATE products SET name = ?, quantity = ?, code_value = ?, is_published = ?, expiration = ?, price = ?, version = version + 1 WHERE id = ? AND version = ?"))
+	require.Contains(t, byName, "ProductRepository.GetProductByID")
+	require.Contains(t, byName, "sql SELECT")
+}
@@ -0,0 +1,607 @@
+// Package config loads the settings of the server. The defaults are overridden,
+// in order, by a YAML file, by environment variables and by command-line flags.
+// The file is given with -config or PRODUCTS_CONFIG, see app/config.example.yaml.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the repository backends
+const (
+	BackendSlice    = "slice"
+	BackendMap      = "map"
+	BackendFile     = "file"
+	BackendWAL      = "wal"
+	BackendSQLite   = "sqlite"
+	BackendMySQL    = "mysql"
+	BackendPostgres = "postgres"
+)
+
+var backends = []string{BackendSlice, BackendMap, BackendFile, BackendWAL, BackendSQLite, BackendMySQL, BackendPostgres}
+
+// the fsync policies of the file and wal backends
+const (
+	FsyncAlways = "always"
+	FsyncNever  = "never"
+)
+
+// the formats of the logs
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// the exporters of the traces
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterOTLP   = "otlp"
+)
+
+// the stores of the idempotency keys
+const (
+	IdempotencyStoreMemory = "memory"
+	IdempotencyStoreSQL    = "sql"
+)
+
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	Repository RepositoryConfig `yaml:"repository"`
+	Auth       AuthConfig       `yaml:"auth"`
+	RateLimit  RateLimitConfig  `yaml:"rate_limit"`
+	// Idempotency keeps the responses to the POST /products with an Idempotency-Key header
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Log         LogConfig         `yaml:"log"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+}
+
+type ServerConfig struct {
+	Address string `yaml:"address"`
+	// ReadHeaderTimeout and ReadTimeout bound the time to read the headers and
+	// the whole request, WriteTimeout the time to write the response
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	// IdleTimeout closes the keep-alive connections without requests
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes int           `yaml:"max_header_bytes"`
+	// ShutdownTimeout is how long the requests in flight may take to finish
+	// once a SIGINT or SIGTERM is received
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type RepositoryConfig struct {
+	// Backend is one of slice, map, file, wal, sqlite, mysql or postgres
+	Backend string `yaml:"backend"`
+	// SeedFile is the JSON catalog loaded by the slice and map backends
+	SeedFile string         `yaml:"seed_file"`
+	File     FileConfig     `yaml:"file"`
+	WAL      WALConfig      `yaml:"wal"`
+	SQLite   SQLiteConfig   `yaml:"sqlite"`
+	MySQL    MySQLConfig    `yaml:"mysql"`
+	Postgres PostgresConfig `yaml:"postgres"`
+	// Pool sizes the connection pool of the mysql and postgres backends
+	Pool PoolConfig `yaml:"pool"`
+}
+
+type AuthConfig struct {
+	// BootstrapAPIKey, when set, is stored on startup as an API key with every
+	// scope, so the first keys can be created through the admin endpoints
+	BootstrapAPIKey string    `yaml:"bootstrap_api_key"`
+	JWT             JWTConfig `yaml:"jwt"`
+	// RBACPolicyFile is the YAML policy with the permissions of each role,
+	// the embedded default policy is used when it is empty
+	RBACPolicyFile string `yaml:"rbac_policy_file"`
+}
+
+// JWTConfig enables the bearer tokens when JWKSFile is set
+type JWTConfig struct {
+	// JWKSFile holds the keys that sign the tokens
+	JWKSFile string `yaml:"jwks_file"`
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// RolesClaim is the claim with the roles, e.g. "roles" or "realm_access.roles"
+	RolesClaim string `yaml:"roles_claim"`
+	// Leeway is the clock skew tolerated when checking exp and nbf
+	Leeway time.Duration `yaml:"leeway"`
+}
+
+// RateLimitConfig is the quota of each client, identified by its API key or
+// token. Every route shares the Default bucket of the client but the ones in
+// Routes, which get a bucket of their own.
+type RateLimitConfig struct {
+	// IP is the quota of each IP, taken before the credentials are checked.
+	// The clients behind the same IP share it.
+	IP      RateLimit `yaml:"ip"`
+	Default RateLimit `yaml:"default"`
+	// Routes are keyed by "METHOD /pattern", or by "/pattern" for every method,
+	// e.g. "GET /products/consumer_price"
+	Routes map[string]RateLimit `yaml:"routes"`
+}
+
+// RateLimit refills a bucket of Burst requests with Requests every Period,
+// 0 requests disables the limit
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+	Burst    int           `yaml:"burst"`
+}
+
+// IdempotencyConfig is where the responses to the requests with an
+// Idempotency-Key header are kept and for how long
+type IdempotencyConfig struct {
+	// Store is memory, for each instance of the server, or sql, in the database
+	// of the sqlite, mysql or postgres backends
+	Store string `yaml:"store"`
+	// TTL is how long a key answers with its response, then it can be used again
+	TTL time.Duration `yaml:"ttl"`
+	// Lease is how long a key stays reserved while its first request is
+	// served, in case the server dies before it answers
+	Lease time.Duration `yaml:"lease"`
+}
+
+type LogConfig struct {
+	// Level is the minimum level logged: debug, info, warn or error
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// TracingConfig exports the spans of the requests, of the service and of the
+// repository. The traceparent header of the clients is honored either way.
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the URL of the OTLP/HTTP collector, e.g. http://localhost:4318
+	Endpoint string `yaml:"endpoint"`
+	// SampleRatio is the share of the new traces recorded, the sampling
+	// decision of the client is kept when it sends one
+	SampleRatio float64 `yaml:"sample_ratio"`
+	ServiceName string  `yaml:"service_name"`
+}
+
+type FileConfig struct {
+	Path  string `yaml:"path"`
+	Fsync string `yaml:"fsync"`
+}
+
+type WALConfig struct {
+	Dir          string `yaml:"dir"`
+	Fsync        string `yaml:"fsync"`
+	CompactEvery int    `yaml:"compact_every"`
+}
+
+type SQLiteConfig struct {
+	Path string `yaml:"path"`
+}
+
+type MySQLConfig struct {
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Address  string `yaml:"address"`
+	Database string `yaml:"database"`
+}
+
+type PostgresConfig struct {
+	DSN string `yaml:"dsn"`
+}
+
+type PoolConfig struct {
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+// Default returns the settings used when nothing overrides them
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Address:           ":8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			ShutdownTimeout:   15 * time.Second,
+		},
+		Repository: RepositoryConfig{
+			Backend:  BackendMySQL,
+			SeedFile: "app/data/products.json",
+			File:     FileConfig{Path: "app/data/file_storage/products.json", Fsync: FsyncAlways},
+			WAL:      WALConfig{Dir: "app/data/wal", Fsync: FsyncAlways, CompactEvery: 1000},
+			SQLite:   SQLiteConfig{Path: "app/data/products.db"},
+			MySQL:    MySQLConfig{User: "root", Address: "localhost:3306", Database: "my_db"},
+			Pool:     PoolConfig{MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetime: 5 * time.Minute},
+		},
+		Auth: AuthConfig{
+			JWT: JWTConfig{RolesClaim: "roles", Leeway: 30 * time.Second},
+		},
+		Log: LogConfig{Level: "info", Format: LogFormatJSON},
+		Tracing: TracingConfig{
+			Exporter:    TracingExporterNone,
+			Endpoint:    "http://localhost:4318",
+			SampleRatio: 1,
+			ServiceName: "products-api",
+		},
+		RateLimit: RateLimitConfig{
+			IP:      RateLimit{Requests: 1200, Period: time.Minute, Burst: 240},
+			Default: RateLimit{Requests: 300, Period: time.Minute, Burst: 60},
+			Routes: map[string]RateLimit{
+				"GET /products/consumer_price": {Requests: 10, Period: time.Minute, Burst: 5},
+			},
+		},
+		Idempotency: IdempotencyConfig{Store: IdempotencyStoreMemory, TTL: 24 * time.Hour, Lease: time.Minute},
+	}
+}
+
+// minAPIKeyLength keeps the bootstrap key from being guessed
+const minAPIKeyLength = 16
+
+// setting is a value that can be set with an environment variable and a flag
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"address", "PRODUCTS_ADDRESS", "address the server listens on", setString(func(c *Config) *string { return &c.Server.Address })},
+	{"read-header-timeout", "PRODUCTS_READ_HEADER_TIMEOUT", "maximum time to read the request headers", setDuration(func(c *Config) *time.Duration { return &c.Server.ReadHeaderTimeout })},
+	{"read-timeout", "PRODUCTS_READ_TIMEOUT", "maximum time to read a whole request", setDuration(func(c *Config) *time.Duration { return &c.Server.ReadTimeout })},
+	{"write-timeout", "PRODUCTS_WRITE_TIMEOUT", "maximum time to write a response", setDuration(func(c *Config) *time.Duration { return &c.Server.WriteTimeout })},
+	{"idle-timeout", "PRODUCTS_IDLE_TIMEOUT", "maximum time a keep-alive connection waits for the next request", setDuration(func(c *Config) *time.Duration { return &c.Server.IdleTimeout })},
+	{"max-header-bytes", "PRODUCTS_MAX_HEADER_BYTES", "maximum size of the request headers", setInt(func(c *Config) *int { return &c.Server.MaxHeaderBytes })},
+	{"shutdown-timeout", "PRODUCTS_SHUTDOWN_TIMEOUT", "maximum time to drain the requests on shutdown", setDuration(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+	{"repository", "PRODUCTS_REPOSITORY", "repository backend: " + strings.Join(backends, ", "), setString(func(c *Config) *string { return &c.Repository.Backend })},
+	{"seed-file", "PRODUCTS_SEED_FILE", "JSON catalog loaded by the slice and map backends", setString(func(c *Config) *string { return &c.Repository.SeedFile })},
+	{"file-path", "PRODUCTS_FILE_PATH", "products file of the file backend", setString(func(c *Config) *string { return &c.Repository.File.Path })},
+	{"file-fsync", "PRODUCTS_FILE_FSYNC", "fsync policy of the file backend: always or never", setString(func(c *Config) *string { return &c.Repository.File.Fsync })},
+	{"wal-dir", "PRODUCTS_WAL_DIR", "directory of the wal backend", setString(func(c *Config) *string { return &c.Repository.WAL.Dir })},
+	{"wal-fsync", "PRODUCTS_WAL_FSYNC", "fsync policy of the wal backend: always or never", setString(func(c *Config) *string { return &c.Repository.WAL.Fsync })},
+	{"wal-compact-every", "PRODUCTS_WAL_COMPACT_EVERY", "log entries between compactions of the wal backend", setInt(func(c *Config) *int { return &c.Repository.WAL.CompactEvery })},
+	{"sqlite-path", "SQLITE_PATH", "database file of the sqlite backend", setString(func(c *Config) *string { return &c.Repository.SQLite.Path })},
+	{"mysql-user", "MYSQL_USER", "user of the mysql backend", setString(func(c *Config) *string { return &c.Repository.MySQL.User })},
+	{"mysql-password", "MYSQL_ROOT_PASSWORD", "password of the mysql backend", setString(func(c *Config) *string { return &c.Repository.MySQL.Password })},
+	{"mysql-address", "MYSQL_ADDRESS", "host:port of the mysql backend", setString(func(c *Config) *string { return &c.Repository.MySQL.Address })},
+	{"mysql-database", "MYSQL_DATABASE", "database of the mysql backend", setString(func(c *Config) *string { return &c.Repository.MySQL.Database })},
+	{"postgres-dsn", "POSTGRES_DSN", "connection string of the postgres backend", setString(func(c *Config) *string { return &c.Repository.Postgres.DSN })},
+	{"bootstrap-api-key", "PRODUCTS_BOOTSTRAP_API_KEY", "API key with every scope stored on startup", setString(func(c *Config) *string { return &c.Auth.BootstrapAPIKey })},
+	{"jwt-jwks-file", "PRODUCTS_JWT_JWKS_FILE", "JWKS file with the keys of the bearer tokens, enables them", setString(func(c *Config) *string { return &c.Auth.JWT.JWKSFile })},
+	{"jwt-issuer", "PRODUCTS_JWT_ISSUER", "required iss claim of the bearer tokens", setString(func(c *Config) *string { return &c.Auth.JWT.Issuer })},
+	{"jwt-audience", "PRODUCTS_JWT_AUDIENCE", "required aud claim of the bearer tokens", setString(func(c *Config) *string { return &c.Auth.JWT.Audience })},
+	{"jwt-roles-claim", "PRODUCTS_JWT_ROLES_CLAIM", "claim with the roles of the bearer tokens", setString(func(c *Config) *string { return &c.Auth.JWT.RolesClaim })},
+	{"jwt-leeway", "PRODUCTS_JWT_LEEWAY", "clock skew tolerated on exp and nbf", setDuration(func(c *Config) *time.Duration { return &c.Auth.JWT.Leeway })},
+	{"rbac-policy-file", "PRODUCTS_RBAC_POLICY_FILE", "YAML policy with the permissions of each role", setString(func(c *Config) *string { return &c.Auth.RBACPolicyFile })},
+	{"rate-limit-ip-requests", "PRODUCTS_RATE_LIMIT_IP_REQUESTS", "requests each IP gets back every period, before its credentials are checked, 0 disables the limit", setInt(func(c *Config) *int { return &c.RateLimit.IP.Requests })},
+	{"rate-limit-ip-period", "PRODUCTS_RATE_LIMIT_IP_PERIOD", "period of the rate limit of each IP, e.g. 1m", setDuration(func(c *Config) *time.Duration { return &c.RateLimit.IP.Period })},
+	{"rate-limit-ip-burst", "PRODUCTS_RATE_LIMIT_IP_BURST", "requests an IP can make at once", setInt(func(c *Config) *int { return &c.RateLimit.IP.Burst })},
+	{"rate-limit-requests", "PRODUCTS_RATE_LIMIT_REQUESTS", "requests each client gets back every period, 0 disables the limit", setInt(func(c *Config) *int { return &c.RateLimit.Default.Requests })},
+	{"rate-limit-period", "PRODUCTS_RATE_LIMIT_PERIOD", "period of the rate limit, e.g. 1m", setDuration(func(c *Config) *time.Duration { return &c.RateLimit.Default.Period })},
+	{"rate-limit-burst", "PRODUCTS_RATE_LIMIT_BURST", "requests a client can make at once", setInt(func(c *Config) *int { return &c.RateLimit.Default.Burst })},
+	{"idempotency-store", "PRODUCTS_IDEMPOTENCY_STORE", "store of the idempotency keys: memory or sql", setString(func(c *Config) *string { return &c.Idempotency.Store })},
+	{"idempotency-ttl", "PRODUCTS_IDEMPOTENCY_TTL", "how long an idempotency key replays its response, e.g. 24h", setDuration(func(c *Config) *time.Duration { return &c.Idempotency.TTL })},
+	{"idempotency-lease", "PRODUCTS_IDEMPOTENCY_LEASE", "how long an idempotency key stays reserved while its request is served, e.g. 1m", setDuration(func(c *Config) *time.Duration { return &c.Idempotency.Lease })},
+	{"log-level", "PRODUCTS_LOG_LEVEL", "minimum level logged: debug, info, warn or error", setString(func(c *Config) *string { return &c.Log.Level })},
+	{"log-format", "PRODUCTS_LOG_FORMAT", "format of the logs: json or text", setString(func(c *Config) *string { return &c.Log.Format })},
+	{"tracing-exporter", "PRODUCTS_TRACING_EXPORTER", "exporter of the traces: none, stdout or otlp", setString(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"tracing-endpoint", "PRODUCTS_TRACING_ENDPOINT", "URL of the OTLP/HTTP collector", setString(func(c *Config) *string { return &c.Tracing.Endpoint })},
+	{"tracing-sample-ratio", "PRODUCTS_TRACING_SAMPLE_RATIO", "share of the new traces recorded, from 0 to 1", setFloat(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+	{"tracing-service-name", "PRODUCTS_TRACING_SERVICE_NAME", "service.name of the spans", setString(func(c *Config) *string { return &c.Tracing.ServiceName })},
+	{"db-max-open-conns", "PRODUCTS_DB_MAX_OPEN_CONNS", "maximum open connections to the database", setInt(func(c *Config) *int { return &c.Repository.Pool.MaxOpenConns })},
+	{"db-max-idle-conns", "PRODUCTS_DB_MAX_IDLE_CONNS", "maximum idle connections to the database", setInt(func(c *Config) *int { return &c.Repository.Pool.MaxIdleConns })},
+	{"db-conn-max-lifetime", "PRODUCTS_DB_CONN_MAX_LIFETIME", "maximum lifetime of a database connection, e.g. 5m", setDuration(func(c *Config) *time.Duration { return &c.Repository.Pool.ConnMaxLifetime })},
+}
+
+func setString(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func setInt(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func setFloat(field func(c *Config) *float64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", value)
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+// Load builds the configuration from the command-line arguments (without the
+// program name) and the environment, and validates it
+func Load(args []string, getenv func(string) string) (Config, error) {
+
+	// the flags are parsed first to find the config file, and applied last
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configPath := flags.String("config", getenv("PRODUCTS_CONFIG"), "YAML configuration file (env PRODUCTS_CONFIG)")
+	values := make(map[string]*string)
+	for _, s := range settings {
+		values[s.flag] = flags.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, fmt.Errorf("parsing the flags: %w", err)
+	}
+
+	cfg := Default()
+
+	// file
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return Config{}, err
+		}
+	}
+
+	// environment
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(&cfg, value); err != nil {
+				return Config{}, fmt.Errorf("environment variable %s: %w", s.env, err)
+			}
+		}
+	}
+
+	// flags
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(&cfg, *values[s.flag]); err != nil {
+					flagErr = fmt.Errorf("flag -%s: %w", s.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// Usage writes the flags and their environment variables
+func Usage(w io.Writer) {
+	fmt.Fprintln(w, "Flags (each one can also be set with the environment variable in parentheses):")
+	fmt.Fprintln(w, "  -config string\n    \tYAML configuration file (env PRODUCTS_CONFIG)")
+	for _, s := range settings {
+		fmt.Fprintf(w, "  -%s string\n    \t%s (env %s)\n", s.flag, s.usage, s.env)
+	}
+}
+
+// loadFile overrides the configuration with the values present in the YAML file
+func (c *Config) loadFile(path string) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading the config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Validate checks the configuration, reporting every invalid setting at once
+func (c Config) Validate() error {
+
+	var errs []error
+	invalid := func(setting, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{setting}, args...)...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Address); err != nil {
+		invalid("server.address", "%q is not a valid host:port", c.Server.Address)
+	}
+	timeouts := []struct {
+		setting string
+		value   time.Duration
+	}{
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value <= 0 {
+			invalid(timeout.setting, "must be greater than 0")
+		}
+	}
+	if c.Server.MaxHeaderBytes < 1024 {
+		invalid("server.max_header_bytes", "must be at least 1024")
+	}
+
+	r := c.Repository
+	switch r.Backend {
+	case BackendSlice, BackendMap:
+		if r.SeedFile == "" {
+			invalid("repository.seed_file", "is required by the %s backend", r.Backend)
+		}
+	case BackendFile:
+		if r.File.Path == "" {
+			invalid("repository.file.path", "is required by the file backend")
+		}
+		if r.File.Fsync != FsyncAlways && r.File.Fsync != FsyncNever {
+			invalid("repository.file.fsync", "must be %q or %q, not %q", FsyncAlways, FsyncNever, r.File.Fsync)
+		}
+	case BackendWAL:
+		if r.WAL.Dir == "" {
+			invalid("repository.wal.dir", "is required by the wal backend")
+		}
+		if r.WAL.Fsync != FsyncAlways && r.WAL.Fsync != FsyncNever {
+			invalid("repository.wal.fsync", "must be %q or %q, not %q", FsyncAlways, FsyncNever, r.WAL.Fsync)
+		}
+		if r.WAL.CompactEvery < 1 {
+			invalid("repository.wal.compact_every", "must be at least 1")
+		}
+	case BackendSQLite:
+		if r.SQLite.Path == "" {
+			invalid("repository.sqlite.path", "is required by the sqlite backend")
+		}
+	case BackendMySQL:
+		if r.MySQL.User == "" {
+			invalid("repository.mysql.user", "is required by the mysql backend")
+		}
+		if _, _, err := net.SplitHostPort(r.MySQL.Address); err != nil {
+			invalid("repository.mysql.address", "%q is not a valid host:port", r.MySQL.Address)
+		}
+		if r.MySQL.Database == "" {
+			invalid("repository.mysql.database", "is required by the mysql backend")
+		}
+	case BackendPostgres:
+		if r.Postgres.DSN == "" {
+			invalid("repository.postgres.dsn", "is required by the postgres backend")
+		}
+	default:
+		invalid("repository.backend", "unknown backend %q, use one of %s", r.Backend, strings.Join(backends, ", "))
+	}
+
+	if r.Backend == BackendMySQL || r.Backend == BackendPostgres {
+		if r.Pool.MaxOpenConns < 1 {
+			invalid("repository.pool.max_open_conns", "must be at least 1")
+		}
+		if r.Pool.MaxIdleConns < 0 || r.Pool.MaxIdleConns > r.Pool.MaxOpenConns {
+			invalid("repository.pool.max_idle_conns", "must be between 0 and max_open_conns (%d)", r.Pool.MaxOpenConns)
+		}
+		if r.Pool.ConnMaxLifetime < 0 {
+			invalid("repository.pool.conn_max_lifetime", "cannot be negative")
+		}
+	}
+
+	if key := c.Auth.BootstrapAPIKey; key != "" && len(key) < minAPIKeyLength {
+		invalid("auth.bootstrap_api_key", "must be at least %d characters long", minAPIKeyLength)
+	}
+	if jwt := c.Auth.JWT; jwt.JWKSFile != "" {
+		if jwt.Issuer == "" {
+			invalid("auth.jwt.issuer", "is required when auth.jwt.jwks_file is set")
+		}
+		if jwt.Audience == "" {
+			invalid("auth.jwt.audience", "is required when auth.jwt.jwks_file is set")
+		}
+		if jwt.RolesClaim == "" {
+			invalid("auth.jwt.roles_claim", "is required when auth.jwt.jwks_file is set")
+		}
+		if jwt.Leeway < 0 {
+			invalid("auth.jwt.leeway", "cannot be negative")
+		}
+	}
+
+	checkLimit := func(setting string, limit RateLimit) {
+		switch {
+		case limit.Requests < 0:
+			invalid(setting+".requests", "cannot be negative")
+		case limit.Requests > 0 && limit.Period <= 0:
+			invalid(setting+".period", "must be greater than 0")
+		case limit.Requests > 0 && limit.Burst < 1:
+			invalid(setting+".burst", "must be at least 1")
+		}
+	}
+	checkLimit("rate_limit.ip", c.RateLimit.IP)
+	checkLimit("rate_limit.default", c.RateLimit.Default)
+	routes := make([]string, 0, len(c.RateLimit.Routes))
+	for route := range c.RateLimit.Routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		setting := fmt.Sprintf("rate_limit.routes[%q]", route)
+		if pattern := route[strings.Index(route, " ")+1:]; !strings.HasPrefix(pattern, "/") {
+			invalid(setting, `must be "METHOD /pattern" or "/pattern"`)
+		}
+		checkLimit(setting, c.RateLimit.Routes[route])
+	}
+
+	switch c.Idempotency.Store {
+	case IdempotencyStoreMemory:
+	case IdempotencyStoreSQL:
+		if b := c.Repository.Backend; b != BackendSQLite && b != BackendMySQL && b != BackendPostgres {
+			invalid("idempotency.store", "%q needs the %s, %s or %s backend, not %q", IdempotencyStoreSQL, BackendSQLite, BackendMySQL, BackendPostgres, b)
+		}
+	default:
+		invalid("idempotency.store", "must be %q or %q, not %q", IdempotencyStoreMemory, IdempotencyStoreSQL, c.Idempotency.Store)
+	}
+	if c.Idempotency.TTL <= 0 {
+		invalid("idempotency.ttl", "must be greater than 0")
+	}
+	if c.Idempotency.Lease <= 0 {
+		invalid("idempotency.lease", "must be greater than 0")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		invalid("log.level", "must be debug, info, warn or error, not %q", c.Log.Level)
+	}
+	if c.Log.Format != LogFormatJSON && c.Log.Format != LogFormatText {
+		invalid("log.format", "must be %q or %q, not %q", LogFormatJSON, LogFormatText, c.Log.Format)
+	}
+
+	t := c.Tracing
+	switch t.Exporter {
+	case TracingExporterNone, TracingExporterStdout:
+	case TracingExporterOTLP:
+		if u, err := url.Parse(t.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("tracing.endpoint", "%q is not an http or https URL", t.Endpoint)
+		}
+	default:
+		invalid("tracing.exporter", "must be %q, %q or %q, not %q", TracingExporterNone, TracingExporterStdout, TracingExporterOTLP, t.Exporter)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		invalid("tracing.sample_ratio", "must be between 0 and 1")
+	}
+	if t.ServiceName == "" {
+		invalid("tracing.service_name", "is required")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
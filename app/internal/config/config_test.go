@@ -0,0 +1,211 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func env(values map[string]string) func(string) string {
+	return func(key string) string { return values[key] }
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("sin archivo, variables ni flags usa los valores por defecto", func(t *testing.T) {
+		// Act
+		cfg, err := Load(nil, env(nil))
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, Default(), cfg)
+	})
+
+	t.Run("las flags pisan las variables de entorno y estas al archivo", func(t *testing.T) {
+		// Arrange
+		path := writeConfig(t, `
+server:
+  address: ":9000"
+repository:
+  backend: sqlite
+  sqlite:
+    path: from-file.db
+  pool:
+    max_open_conns: 20
+    conn_max_lifetime: 1m
+`)
+		variables := env(map[string]string{
+			"PRODUCTS_CONFIG":  path,
+			"SQLITE_PATH":      "from-env.db",
+			"PRODUCTS_ADDRESS": ":9100",
+		})
+
+		// Act
+		cfg, err := Load([]string{"-address", ":9200"}, variables)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, ":9200", cfg.Server.Address)
+		require.Equal(t, BackendSQLite, cfg.Repository.Backend)
+		require.Equal(t, "from-env.db", cfg.Repository.SQLite.Path)
+		require.Equal(t, 20, cfg.Repository.Pool.MaxOpenConns)
+		require.Equal(t, 5, cfg.Repository.Pool.MaxIdleConns)
+		require.Equal(t, time.Minute, cfg.Repository.Pool.ConnMaxLifetime)
+	})
+
+	t.Run("la flag -config pisa a PRODUCTS_CONFIG", func(t *testing.T) {
+		// Arrange
+		path := writeConfig(t, "repository:\n  backend: map\n")
+		variables := env(map[string]string{"PRODUCTS_CONFIG": "missing.yaml"})
+
+		// Act
+		cfg, err := Load([]string{"-config", path}, variables)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, BackendMap, cfg.Repository.Backend)
+	})
+
+	t.Run("una clave desconocida en el archivo es un error", func(t *testing.T) {
+		// Arrange
+		path := writeConfig(t, "repository:\n  backnd: map\n")
+
+		// Act
+		_, err := Load([]string{"-config", path}, env(nil))
+
+		// Assert
+		require.ErrorContains(t, err, "backnd")
+	})
+
+	t.Run("un numero invalido indica la variable", func(t *testing.T) {
+		// Act
+		_, err := Load(nil, env(map[string]string{"PRODUCTS_DB_MAX_OPEN_CONNS": "ten"}))
+
+		// Assert
+		require.EqualError(t, err, `environment variable PRODUCTS_DB_MAX_OPEN_CONNS: "ten" is not a number`)
+	})
+
+	t.Run("una flag desconocida es un error", func(t *testing.T) {
+		// Act
+		_, err := Load([]string{"-port", "80"}, env(nil))
+
+		// Assert
+		require.ErrorContains(t, err, "-port")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("informa todos los errores juntos", func(t *testing.T) {
+		// Arrange
+		cfg := Default()
+		cfg.Server.Address = "8080"
+		cfg.Server.WriteTimeout = 0
+		cfg.Repository.Backend = BackendWAL
+		cfg.Repository.WAL.Fsync = "sometimes"
+		cfg.Repository.WAL.CompactEvery = 0
+		cfg.Auth.BootstrapAPIKey = "1234"
+		cfg.Auth.JWT.JWKSFile = "jwks.json"
+		cfg.Auth.JWT.Issuer = "https://auth.example.com"
+
+		// Act
+		err := cfg.Validate()
+
+		// Assert
+		require.EqualError(t, err, "invalid configuration:\n"+
+			"server.address: \"8080\" is not a valid host:port\n"+
+			"server.write_timeout: must be greater than 0\n"+
+			"repository.wal.fsync: must be \"always\" or \"never\", not \"sometimes\"\n"+
+			"repository.wal.compact_every: must be at least 1\n"+
+			"auth.bootstrap_api_key: must be at least 16 characters long\n"+
+			"auth.jwt.audience: is required when auth.jwt.jwks_file is set")
+	})
+
+	t.Run("backend desconocido", func(t *testing.T) {
+		// Arrange
+		cfg := Default()
+		cfg.Repository.Backend = "redis"
+
+		// Act
+		err := cfg.Validate()
+
+		// Assert
+		require.ErrorContains(t, err, `repository.backend: unknown backend "redis", use one of slice, map, file, wal, sqlite, mysql, postgres`)
+	})
+
+	t.Run("postgres requiere dsn y un pool valido", func(t *testing.T) {
+		// Arrange
+		cfg := Default()
+		cfg.Repository.Backend = BackendPostgres
+		cfg.Repository.Pool.MaxIdleConns = 50
+
+		// Act
+		err := cfg.Validate()
+
+		// Assert
+		require.EqualError(t, err, "invalid configuration:\n"+
+			"repository.postgres.dsn: is required by the postgres backend\n"+
+			"repository.pool.max_idle_conns: must be between 0 and max_open_conns (10)")
+	})
+
+	t.Run("limites de requests invalidos", func(t *testing.T) {
+		// Arrange
+		cfg := Default()
+		cfg.RateLimit.IP.Period = 0
+		cfg.RateLimit.Default.Burst = 0
+		cfg.RateLimit.Routes["GET products"] = RateLimit{Requests: -1}
+		cfg.RateLimit.Routes["/products/search"] = RateLimit{Requests: 5}
+
+		// Act
+		err := cfg.Validate()
+
+		// Assert
+		require.EqualError(t, err, "invalid configuration:\n"+
+			"rate_limit.ip.period: must be greater than 0\n"+
+			"rate_limit.default.burst: must be at least 1\n"+
+			"rate_limit.routes[\"/products/search\"].period: must be greater than 0\n"+
+			"rate_limit.routes[\"GET products\"]: must be \"METHOD /pattern\" or \"/pattern\"\n"+
+			"rate_limit.routes[\"GET products\"].requests: cannot be negative")
+	})
+
+	t.Run("tracing con exporter otlp requiere una URL", func(t *testing.T) {
+		// Arrange
+		cfg := Default()
+		cfg.Tracing.Exporter = TracingExporterOTLP
+		cfg.Tracing.Endpoint = "localhost:4318"
+		cfg.Tracing.SampleRatio = 1.5
+
+		// Act
+		err := cfg.Validate()
+
+		// Assert
+		require.EqualError(t, err, "invalid configuration:\n"+
+			"tracing.endpoint: \"localhost:4318\" is not an http or https URL\n"+
+			"tracing.sample_ratio: must be between 0 and 1")
+	})
+
+	t.Run("las idempotency keys en sql requieren un backend sql", func(t *testing.T) {
+		// Arrange
+		cfg := Default()
+		cfg.Repository.Backend = BackendMap
+		cfg.Idempotency.Store = IdempotencyStoreSQL
+		cfg.Idempotency.TTL = 0
+		cfg.Idempotency.Lease = -time.Second
+
+		// Act
+		err := cfg.Validate()
+
+		// Assert
+		require.EqualError(t, err, "invalid configuration:\n"+
+			"idempotency.store: \"sql\" needs the sqlite, mysql or postgres backend, not \"map\"\n"+
+			"idempotency.ttl: must be greater than 0\n"+
+			"idempotency.lease: must be greater than 0")
+	})
+}
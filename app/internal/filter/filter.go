@@ -0,0 +1,225 @@
+// Package filter parses the product filter expressions accepted by
+// GET /products/search, for example:
+//
+//	price>100 AND is_published=true AND name~"cheese"
+//
+// Comparisons are written as field operator value and can be combined with
+// AND, OR, NOT and parentheses. The operators are =, !=, >, >=, <, <= and ~
+// (contains, ignoring case, only for strings). Values are numbers, true/false,
+// double-quoted strings or dates written as dd/mm/yyyy or yyyy-mm-dd.
+package filter
+
+import (
+	"fmt"
+	"goweb/app/internal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyntaxError reports the token of the expression that could not be parsed
+type SyntaxError struct {
+	// Pos is the byte offset of the offending token
+	Pos     int
+	Token   string
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d near %q", e.Message, e.Pos, e.Token)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return internal.ErrInvalidQuery
+}
+
+// Parse parses an expression into an internal.FilterExpr. Errors are always *SyntaxError.
+func Parse(input string) (internal.FilterExpr, error) {
+
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	if p.peek().Kind == tokenEOF {
+		return nil, &SyntaxError{Pos: 0, Message: "empty expression"}
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.Kind != tokenEOF {
+		return nil, p.unexpected(tok, "AND, OR or end of expression")
+	}
+
+	return expr, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) unexpected(tok token, expected string) *SyntaxError {
+	return &SyntaxError{Pos: tok.Pos, Token: tok.Text, Message: fmt.Sprintf("expected %s but found %s", expected, tok.Kind)}
+}
+
+// or := and ("OR" and)*
+func (p *parser) parseOr() (internal.FilterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().Kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = internal.FilterOr{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// and := unary ("AND" unary)*
+func (p *parser) parseAnd() (internal.FilterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().Kind == tokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = internal.FilterAnd{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// unary := "NOT" unary | "(" or ")" | comparison
+func (p *parser) parseUnary() (internal.FilterExpr, error) {
+	switch tok := p.peek(); tok.Kind {
+	case tokenNot:
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return internal.FilterNot{Expr: expr}, nil
+	case tokenLParen:
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.Kind != tokenRParen {
+			return nil, p.unexpected(closing, "\")\"")
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+// comparison := field operator value
+func (p *parser) parseComparison() (internal.FilterExpr, error) {
+
+	fieldTok := p.next()
+	if fieldTok.Kind != tokenIdent {
+		return nil, p.unexpected(fieldTok, "field")
+	}
+	field := internal.ProductField(strings.ToLower(fieldTok.Value))
+	if !field.IsValid() {
+		return nil, &SyntaxError{Pos: fieldTok.Pos, Token: fieldTok.Text, Message: "unknown field"}
+	}
+
+	opTok := p.next()
+	if opTok.Kind != tokenOp {
+		return nil, p.unexpected(opTok, "operator")
+	}
+	op := internal.FilterOp(opTok.Value)
+	if op == internal.FilterOpContains && field != internal.ProductFieldName && field != internal.ProductFieldCodeValue {
+		return nil, &SyntaxError{Pos: opTok.Pos, Token: opTok.Text, Message: fmt.Sprintf("operator ~ cannot be used with %s", field)}
+	}
+
+	valueTok := p.next()
+	value, err := parseValue(field, valueTok)
+	if err != nil {
+		return nil, err
+	}
+	if field == internal.ProductFieldIsPublished && op != internal.FilterOpEq && op != internal.FilterOpNe {
+		return nil, &SyntaxError{Pos: opTok.Pos, Token: opTok.Text, Message: "is_published can only be compared with = or !="}
+	}
+
+	return internal.ProductFilter{Field: field, Op: op, Value: value}, nil
+}
+
+// parseValue converts the literal to the type of the field
+func parseValue(field internal.ProductField, tok token) (any, error) {
+
+	invalid := &SyntaxError{Pos: tok.Pos, Token: tok.Text, Message: fmt.Sprintf("invalid value for %s", field)}
+	if tok.Kind != tokenNumber && tok.Kind != tokenString && tok.Kind != tokenIdent {
+		return nil, invalid
+	}
+
+	switch field {
+	case internal.ProductFieldID, internal.ProductFieldQuantity:
+		if tok.Kind != tokenNumber {
+			return nil, invalid
+		}
+		v, err := strconv.Atoi(tok.Value)
+		if err != nil {
+			return nil, invalid
+		}
+		return v, nil
+	case internal.ProductFieldPrice:
+		if tok.Kind != tokenNumber {
+			return nil, invalid
+		}
+		v, err := strconv.ParseFloat(tok.Value, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		return v, nil
+	case internal.ProductFieldIsPublished:
+		if tok.Kind != tokenIdent {
+			return nil, invalid
+		}
+		v, err := strconv.ParseBool(strings.ToLower(tok.Value))
+		if err != nil {
+			return nil, invalid
+		}
+		return v, nil
+	case internal.ProductFieldExpiration:
+		if tok.Kind == tokenIdent {
+			return nil, invalid
+		}
+		for _, layout := range []string{"02/01/2006", "2006-01-02"} {
+			if v, err := time.Parse(layout, tok.Value); err == nil {
+				return v, nil
+			}
+		}
+		return nil, invalid
+	}
+
+	// name and code_value
+	if tok.Kind != tokenString {
+		return nil, &SyntaxError{Pos: tok.Pos, Token: tok.Text, Message: fmt.Sprintf("%s must be compared with a double-quoted string", field)}
+	}
+	return tok.Value, nil
+}
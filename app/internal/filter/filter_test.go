@@ -0,0 +1,58 @@
+package filter_test
+
+import (
+	"goweb/app/internal"
+	"goweb/app/internal/filter"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("AND tiene mas precedencia que OR y los valores toman el tipo del campo", func(t *testing.T) {
+		// Act
+		expr, err := filter.Parse(`price>100 OR is_published=true AND NOT (name~"cheese" OR expiration<=31/12/2021)`)
+
+		// Assert
+		expected := internal.FilterOr{
+			Left: internal.ProductFilter{Field: internal.ProductFieldPrice, Op: internal.FilterOpGt, Value: 100.0},
+			Right: internal.FilterAnd{
+				Left: internal.ProductFilter{Field: internal.ProductFieldIsPublished, Op: internal.FilterOpEq, Value: true},
+				Right: internal.FilterNot{Expr: internal.FilterOr{
+					Left:  internal.ProductFilter{Field: internal.ProductFieldName, Op: internal.FilterOpContains, Value: "cheese"},
+					Right: internal.ProductFilter{Field: internal.ProductFieldExpiration, Op: internal.FilterOpLte, Value: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC)},
+				}},
+			},
+		}
+		require.NoError(t, err)
+		require.Equal(t, expected, expr)
+	})
+
+	t.Run("Los errores apuntan al token erroneo", func(t *testing.T) {
+		cases := []struct {
+			input string
+			pos   int
+			token string
+		}{
+			{input: `price>`, pos: 6, token: ""},
+			{input: `quantity>"ten"`, pos: 9, token: `"ten"`},
+			{input: `price>1 AND (name="a"`, pos: 21, token: ""},
+			{input: `price>1 name="a"`, pos: 8, token: "name"},
+			{input: `price~"1"`, pos: 5, token: "~"},
+			{input: `name="cheese`, pos: 5, token: `"cheese`},
+			{input: `price>1 & quantity<2`, pos: 8, token: "&"},
+		}
+		for _, c := range cases {
+			// Act
+			_, err := filter.Parse(c.input)
+
+			// Assert
+			var syntaxErr *filter.SyntaxError
+			require.ErrorAs(t, err, &syntaxErr, c.input)
+			require.ErrorIs(t, err, internal.ErrInvalidQuery)
+			require.Equal(t, c.pos, syntaxErr.Pos, c.input)
+			require.Equal(t, c.token, syntaxErr.Token, c.input)
+		}
+	})
+}
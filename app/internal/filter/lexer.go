@@ -0,0 +1,137 @@
+package filter
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of expression"
+	case tokenIdent:
+		return "field"
+	case tokenNumber:
+		return "number"
+	case tokenString:
+		return "string"
+	case tokenOp:
+		return "operator"
+	case tokenLParen:
+		return "\"(\""
+	case tokenRParen:
+		return "\")\""
+	case tokenAnd:
+		return "AND"
+	case tokenOr:
+		return "OR"
+	case tokenNot:
+		return "NOT"
+	}
+	return "token"
+}
+
+// token is a lexeme of the expression. Pos is the byte offset where it starts.
+type token struct {
+	Kind  tokenKind
+	Text  string
+	Value string
+	Pos   int
+}
+
+// lex splits the input into tokens
+func lex(input string) ([]token, error) {
+
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{Kind: tokenLParen, Text: "(", Pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{Kind: tokenRParen, Text: ")", Pos: i})
+			i++
+		case strings.ContainsRune("=!<>~", rune(c)):
+			start := i
+			i++
+			if i < len(input) && input[i] == '=' && c != '=' && c != '~' {
+				i++
+			}
+			op := input[start:i]
+			if op == "!" {
+				return nil, &SyntaxError{Pos: start, Token: op, Message: "expected \"!=\""}
+			}
+			tokens = append(tokens, token{Kind: tokenOp, Text: op, Value: op, Pos: start})
+		case c == '"':
+			start := i
+			var value strings.Builder
+			i++
+			closed := false
+			for i < len(input) {
+				if input[i] == '\\' && i+1 < len(input) {
+					value.WriteByte(input[i+1])
+					i += 2
+					continue
+				}
+				if input[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				value.WriteByte(input[i])
+				i++
+			}
+			if !closed {
+				return nil, &SyntaxError{Pos: start, Token: input[start:], Message: "unterminated string"}
+			}
+			tokens = append(tokens, token{Kind: tokenString, Text: input[start:i], Value: value.String(), Pos: start})
+		case c == '-' || c == '.' || unicode.IsDigit(rune(c)):
+			start := i
+			i++
+			for i < len(input) && (input[i] == '.' || input[i] == '/' || input[i] == '-' || unicode.IsDigit(rune(input[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{Kind: tokenNumber, Text: input[start:i], Value: input[start:i], Pos: start})
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(input) && (input[i] == '_' || unicode.IsLetter(rune(input[i])) || unicode.IsDigit(rune(input[i]))) {
+				i++
+			}
+			word := input[start:i]
+			kind := tokenIdent
+			switch strings.ToUpper(word) {
+			case "AND":
+				kind = tokenAnd
+			case "OR":
+				kind = tokenOr
+			case "NOT":
+				kind = tokenNot
+			}
+			tokens = append(tokens, token{Kind: kind, Text: word, Value: word, Pos: start})
+		default:
+			return nil, &SyntaxError{Pos: i, Token: string(c), Message: "unexpected character"}
+		}
+	}
+	tokens = append(tokens, token{Kind: tokenEOF, Pos: len(input)})
+
+	return tokens, nil
+}
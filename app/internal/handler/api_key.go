@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"goweb/app/internal"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootcamp-go/web/response"
+	"github.com/go-chi/chi/v5"
+)
+
+// APIKeyHandler serves the admin endpoints managing the API keys
+type APIKeyHandler struct {
+	service internal.APIKeyService
+}
+
+func NewAPIKeyHandler(service internal.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		service: service,
+	}
+}
+
+func (h *APIKeyHandler) GetAllAPIKeys(w http.ResponseWriter, r *http.Request) {
+
+	keys, err := h.service.GetAllAPIKeys(r.Context())
+	if err != nil {
+		writeError(w, r, "listing the api keys", err)
+		return
+	}
+
+	body := make([]ResponseBodyAPIKey, 0, len(keys))
+	for _, key := range keys {
+		body = append(body, parseAPIKeyToBody(key))
+	}
+	response.JSON(w, http.StatusOK, body)
+}
+
+// CreateAPIKey returns the secret of the new key, it cannot be retrieved later
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	bytesJson, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, "reading the api key", errInvalidBody)
+		return
+	}
+	var body RequestBodyAPIKey
+	if err := decodeBody(bytesJson, &body); err != nil {
+		writeError(w, r, "parsing the api key", err)
+		return
+	}
+
+	var expiresAt time.Time
+	if body.ExpiresAt != nil {
+		expiresAt = *body.ExpiresAt
+	}
+
+	key, secret, err := h.service.CreateAPIKey(r.Context(), body.Name, body.Scopes, expiresAt)
+	if err != nil {
+		writeError(w, r, "creating the api key", err)
+		return
+	}
+
+	resBody := parseAPIKeyToBody(key)
+	resBody.Key = secret
+	response.JSON(w, http.StatusCreated, resBody)
+}
+
+func (h *APIKeyHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	// convert the id to int
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, "parsing the id", errInvalidID)
+		return
+	}
+
+	err = h.service.DeleteAPIKey(r.Context(), id)
+	if err != nil {
+		writeError(w, r, "deleting the api key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
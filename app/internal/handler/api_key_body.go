@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"goweb/app/internal"
+	"time"
+)
+
+type RequestBodyAPIKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is optional, in RFC 3339 format
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// ResponseBodyAPIKey never carries the hash, Key is the secret and is only
+// returned when the key is created
+type ResponseBodyAPIKey struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	Key       string     `json:"key,omitempty"`
+}
+
+func parseAPIKeyToBody(key internal.APIKey) ResponseBodyAPIKey {
+	body := ResponseBodyAPIKey{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+	}
+	if !key.ExpiresAt.IsZero() {
+		expiresAt := key.ExpiresAt
+		body.ExpiresAt = &expiresAt
+	}
+	return body
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bootcamp-go/web/response"
+)
+
+// HealthCheck is a dependency checked by the readiness probe
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthHandler serves the liveness and readiness probes. They are meant to
+// be mounted without authentication.
+type HealthHandler struct {
+	checks []HealthCheck
+	// timeout bounds every check
+	timeout time.Duration
+}
+
+func NewHealthHandler(timeout time.Duration, checks ...HealthCheck) *HealthHandler {
+	return &HealthHandler{
+		checks:  checks,
+		timeout: timeout,
+	}
+}
+
+// ResponseHealth is the body of the probes, Checks is only set by the readiness probe
+type ResponseHealth struct {
+	Status string                         `json:"status"`
+	Checks map[string]ResponseHealthCheck `json:"checks,omitempty"`
+}
+
+type ResponseHealthCheck struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+const (
+	healthStatusOK   = "ok"
+	healthStatusFail = "fail"
+)
+
+// Liveness reports that the process is up, without checking its dependencies
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+
+	response.JSON(w, http.StatusOK, ResponseHealth{Status: healthStatusOK})
+
+}
+
+// Readiness runs the checks concurrently and answers 503 if any of them fails
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	body := ResponseHealth{
+		Status: healthStatusOK,
+		Checks: make(map[string]ResponseHealthCheck, len(h.checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range h.checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+
+			// a check that ignores the context still cannot hold the probe past the timeout
+			start := time.Now()
+			done := make(chan error, 1)
+			go func() {
+				done <- check.Check(ctx)
+			}()
+			var err error
+			select {
+			case err = <-done:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			result := ResponseHealthCheck{
+				Status:    healthStatusOK,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = healthStatusFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			body.Checks[check.Name] = result
+			if err != nil {
+				body.Status = healthStatusFail
+			}
+		}(check)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if body.Status != healthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	response.JSON(w, status, body)
+
+}
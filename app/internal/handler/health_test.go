@@ -0,0 +1,88 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"goweb/app/internal/handler"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+
+	ok := handler.HealthCheck{Name: "repository", Check: func(ctx context.Context) error { return nil }}
+
+	readiness := func(h *handler.HealthHandler) (int, handler.ResponseHealth) {
+		req := httptest.NewRequest("GET", "/readyz", nil)
+		res := httptest.NewRecorder()
+		h.Readiness(res, req)
+		var body handler.ResponseHealth
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+		return res.Code, body
+	}
+
+	t.Run("Liveness responde ok sin revisar las dependencias", func(t *testing.T) {
+		// Arrange
+		failing := handler.HealthCheck{Name: "repository", Check: func(ctx context.Context) error { return errors.New("down") }}
+		h := handler.NewHealthHandler(time.Second, failing)
+		req := httptest.NewRequest("GET", "/healthz", nil)
+		res := httptest.NewRecorder()
+
+		// Act
+		h.Liveness(res, req)
+
+		// Assert
+		require.Equal(t, http.StatusOK, res.Code)
+		require.JSONEq(t, `{"status":"ok"}`, res.Body.String())
+	})
+
+	t.Run("Readiness informa cada dependencia", func(t *testing.T) {
+		// Arrange
+		h := handler.NewHealthHandler(time.Second, ok)
+
+		// Act
+		code, body := readiness(h)
+
+		// Assert
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "ok", body.Status)
+		require.Equal(t, "ok", body.Checks["repository"].Status)
+		require.Empty(t, body.Checks["repository"].Error)
+	})
+
+	t.Run("Una dependencia caida responde 503", func(t *testing.T) {
+		// Arrange
+		failing := handler.HealthCheck{Name: "cache", Check: func(ctx context.Context) error { return errors.New("connection refused") }}
+		h := handler.NewHealthHandler(time.Second, ok, failing)
+
+		// Act
+		code, body := readiness(h)
+
+		// Assert
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, "fail", body.Status)
+		require.Equal(t, "ok", body.Checks["repository"].Status)
+		require.Equal(t, handler.ResponseHealthCheck{Status: "fail", LatencyMs: body.Checks["cache"].LatencyMs, Error: "connection refused"}, body.Checks["cache"])
+	})
+
+	t.Run("Una dependencia que no responde se corta en el timeout", func(t *testing.T) {
+		// Arrange
+		block := make(chan struct{})
+		defer close(block)
+		stuck := handler.HealthCheck{Name: "repository", Check: func(ctx context.Context) error { <-block; return nil }}
+		h := handler.NewHealthHandler(50*time.Millisecond, stuck)
+
+		// Act
+		code, body := readiness(h)
+
+		// Assert
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, context.DeadlineExceeded.Error(), body.Checks["repository"].Error)
+		require.GreaterOrEqual(t, body.Checks["repository"].LatencyMs, 50.0)
+	})
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"goweb/app/internal"
+	"goweb/app/internal/patch"
+	"log/slog"
+	"net/http"
+)
+
+// ContentTypeProblem is the media type of the errors, see RFC 7807
+const ContentTypeProblem = "application/problem+json"
+
+// problemTypeBase prefixes the code of a problem to build its type URI
+const problemTypeBase = "/problems/"
+
+// the codes of the problems, stable so the clients can rely on them rather than on the titles
+const (
+	CodeValidationFailed   = "validation_failed"
+	CodeInvalidBody        = "invalid_body"
+	CodeInvalidID          = "invalid_id"
+	CodeInvalidQuery       = "invalid_query"
+	CodeInvalidFilter      = "invalid_filter"
+	CodeInvalidPatch       = "invalid_patch"
+	CodeUnprocessablePatch = "unprocessable_patch"
+	CodePatchTestFailed    = "patch_test_failed"
+	CodeUnsupportedMedia   = "unsupported_media_type"
+	CodeProductNotFound    = "product_not_found"
+	CodeDuplicateCodeValue = "duplicate_code_value"
+	CodePreconditionFailed = "precondition_failed"
+	CodeAPIKeyNotFound     = "api_key_not_found"
+	CodeUnauthorized       = "unauthorized"
+	CodeAPIKeyExpired      = "api_key_expired"
+	CodeForbidden          = "forbidden"
+	CodeRateLimited        = "rate_limited"
+	CodeInvalidIdempotency = "invalid_idempotency_key"
+	CodeIdempotencyReused  = "idempotency_key_reused"
+	CodeIdempotencyInUse   = "idempotency_key_in_use"
+	CodeNotFound           = "not_found"
+	CodeUnavailable        = "unavailable"
+	CodeInternal           = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details object. Code, RequestID and Errors
+// are extensions, Position and Token point at the token of a filter
+// expression that could not be parsed and Operation and Path at the operation
+// of a JSON Patch that could not be applied.
+type Problem struct {
+	Type      string         `json:"type"`
+	Title     string         `json:"title"`
+	Status    int            `json:"status"`
+	Detail    string         `json:"detail,omitempty"`
+	Instance  string         `json:"instance,omitempty"`
+	Code      string         `json:"code"`
+	RequestID string         `json:"request_id,omitempty"`
+	Errors    []ProblemField `json:"errors,omitempty"`
+	Position  *int           `json:"position,omitempty"`
+	Token     string         `json:"token,omitempty"`
+	Operation *int           `json:"operation,omitempty"`
+	Path      string         `json:"path,omitempty"`
+}
+
+// ProblemField is the error of a field of the request
+type ProblemField struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewProblem returns the problem with the code, titled after the status
+func NewProblem(status int, code, detail string) Problem {
+	return Problem{
+		Type:   problemTypeBase + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// WriteProblem writes the problem for the request, with its path as the
+// instance and its id
+func WriteProblem(w http.ResponseWriter, r *http.Request, problem Problem) {
+
+	problem.Instance = r.URL.Path
+	if id, ok := internal.RequestIDFromContext(r.Context()); ok {
+		problem.RequestID = id
+	}
+
+	w.Header().Set("Content-Type", ContentTypeProblem)
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// NotFound answers the requests matching no route
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, r, NewProblem(http.StatusNotFound, CodeNotFound, "No route matches the path"))
+}
+
+// writeError writes the problem of the error returned by operation, logging the server errors
+func writeError(w http.ResponseWriter, r *http.Request, operation string, err error) {
+
+	problem := ProblemFor(err)
+	if problem.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), operation, "error", err)
+	}
+	WriteProblem(w, r, problem)
+}
+
+// the errors of the requests the handlers cannot parse
+var (
+	errInvalidID   = errors.New("the id must be a number")
+	errInvalidBody = errors.New("the body must be a JSON object")
+)
+
+// domainProblems are the problems of the sentinel errors of internal
+var domainProblems = []struct {
+	err     error
+	status  int
+	code    string
+	field   string
+	reason  string
+	message string
+}{
+	{internal.ErrProductNotFound, http.StatusNotFound, CodeProductNotFound, "", "", "No products found"},
+	{internal.ErrProductExists, http.StatusConflict, CodeDuplicateCodeValue, "code_value", internal.FieldDuplicate, "belongs to another product"},
+	{internal.ErrCodeValueBelongsToOther, http.StatusConflict, CodeDuplicateCodeValue, "code_value", internal.FieldDuplicate, "belongs to another product"},
+	{internal.ErrProductVersionMismatch, http.StatusPreconditionFailed, CodePreconditionFailed, "", "", "The product changed since the version of If-Match"},
+	{internal.ErrInvalidExpirationFormat, http.StatusBadRequest, CodeValidationFailed, "expiration", internal.FieldInvalidFormat, "must be a date in the DD/MM/YYYY format"},
+	{internal.ErrProductEmpty, http.StatusBadRequest, CodeValidationFailed, "", "", "The product is empty"},
+	{internal.ErrAPIKeyNotFound, http.StatusNotFound, CodeAPIKeyNotFound, "", "", "Api key not found"},
+	{internal.ErrAPIKeyNameEmpty, http.StatusBadRequest, CodeValidationFailed, "name", internal.FieldRequired, "is required"},
+	{internal.ErrAPIKeyInvalidScope, http.StatusBadRequest, CodeValidationFailed, "scopes", internal.FieldInvalidValue, "must be known scopes"},
+	{internal.ErrAPIKeyExpired, http.StatusBadRequest, CodeValidationFailed, "expires_at", internal.FieldInvalidValue, "must be in the future"},
+}
+
+// ProblemFor maps an error returned by the services, or by the parsing of the
+// request, to its problem. The
+// field errors become the errors of the problem, the errors the client cannot
+// fix are internal errors and their details are not disclosed.
+func ProblemFor(err error) Problem {
+
+	var validation *internal.ValidationError
+	if errors.As(err, &validation) {
+		problem := NewProblem(http.StatusBadRequest, CodeValidationFailed, "The request has invalid fields")
+		for _, field := range validation.Fields {
+			problem.Errors = append(problem.Errors, ProblemField{Field: field.Field, Code: field.Code, Message: field.Message})
+		}
+		return problem
+	}
+
+	for _, known := range domainProblems {
+		if !errors.Is(err, known.err) {
+			continue
+		}
+		if known.field == "" {
+			return NewProblem(known.status, known.code, known.message)
+		}
+		// the services wrap the sentinel to tell what is wrong, e.g. which scope is unknown
+		detail := known.field + " " + known.message
+		if err != known.err {
+			detail = err.Error()
+		}
+		problem := NewProblem(known.status, known.code, detail)
+		problem.Errors = []ProblemField{{Field: known.field, Code: known.reason, Message: known.message}}
+		return problem
+	}
+
+	switch {
+	case errors.Is(err, errInvalidID):
+		return NewProblem(http.StatusBadRequest, CodeInvalidID, "The id must be a number")
+	case errors.Is(err, errInvalidBody):
+		return NewProblem(http.StatusBadRequest, CodeInvalidBody, "The body must be a JSON object")
+	case errors.Is(err, internal.ErrForbidden):
+		return NewProblem(http.StatusForbidden, CodeForbidden, err.Error())
+	case errors.Is(err, internal.ErrInvalidPatch):
+		return patchProblem(http.StatusBadRequest, CodeInvalidPatch, err)
+	case errors.Is(err, internal.ErrPatchConflict):
+		return patchProblem(http.StatusUnprocessableEntity, CodeUnprocessablePatch, err)
+	case errors.Is(err, internal.ErrPatchTestFailed):
+		return patchProblem(http.StatusConflict, CodePatchTestFailed, err)
+	case errors.Is(err, internal.ErrInvalidQuery):
+		return NewProblem(http.StatusBadRequest, CodeInvalidQuery, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		// the storage did not answer in time, the request can be retried
+		return NewProblem(http.StatusServiceUnavailable, CodeUnavailable, "The storage did not answer in time")
+	}
+
+	return NewProblem(http.StatusInternalServerError, CodeInternal, "Internal server error")
+}
+
+// patchProblem is the problem of a patch document, pointing at the failing
+// operation of a JSON Patch
+func patchProblem(status int, code string, err error) Problem {
+
+	problem := NewProblem(status, code, err.Error())
+	var patchErr *patch.Error
+	if errors.As(err, &patchErr) {
+		problem.Detail = patchErr.Message
+		problem.Operation, problem.Path = &patchErr.Index, patchErr.Path
+	}
+	return problem
+}
@@ -1,9 +1,12 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"goweb/app/internal"
+	"goweb/app/internal/filter"
 	"io"
 	"net/http"
 	"strconv"
@@ -31,13 +34,16 @@ func (p *ProductHandler) Ping(w http.ResponseWriter, r *http.Request) {
 
 func (p *ProductHandler) GetAllProducts(w http.ResponseWriter, r *http.Request) {
 
-	products := p.service.GetAllProducts()
+	// get the pagination, sorting and filters from the query params
+	query, err := parseProductQuery(r.URL.Query())
+	if err != nil {
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeInvalidQuery, err.Error()))
+		return
+	}
 
-	if len(products) == 0 {
-		response.JSON(w, http.StatusNotFound, ErrorResponse{
-			Message: "No products found",
-			Status:  http.StatusNotFound,
-		})
+	page, err := p.service.SearchProducts(r.Context(), query)
+	if err != nil {
+		writeError(w, r, "listing the products", err)
 		return
 	}
 
@@ -45,9 +51,13 @@ func (p *ProductHandler) GetAllProducts(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 
 	// parse each product to ResponseBodyProduct
-	productsAsResponse := parseProductsToBody(products)
-
-	json.NewEncoder(w).Encode(productsAsResponse)
+	json.NewEncoder(w).Encode(ResponseProductPage{
+		Products: parseProductsToBody(page.Products),
+		Total:    page.Total,
+		Limit:    query.Limit,
+		Offset:   query.Offset,
+		Next:     nextPageURL(r.URL, query, page.Total),
+	})
 
 }
 
@@ -59,20 +69,20 @@ func (p *ProductHandler) GetProductByID(w http.ResponseWriter, r *http.Request)
 	// convert the id to int
 	idInt, err := strconv.Atoi(id)
 	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid ID",
-			Status:  http.StatusBadRequest,
-		})
+		writeError(w, r, "parsing the id", errInvalidID)
 		return
 	}
 
-	product, err := p.service.GetProductByID(idInt)
+	product, err := p.service.GetProductByID(r.Context(), idInt)
+	if err != nil {
+		writeError(w, r, "getting the product", err)
+		return
+	}
 
-	if errors.Is(err, internal.ErrProductNotFound) {
-		response.JSON(w, http.StatusNotFound, ErrorResponse{
-			Message: "No products found",
-			Status:  http.StatusNotFound,
-		})
+	// a client holding the current version does not need it again
+	w.Header().Set("ETag", productETag(product))
+	if notModified(r, product) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
@@ -85,128 +95,90 @@ func (p *ProductHandler) GetProductByID(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(productAsResponse)
 }
 
-func (p *ProductHandler) GetProductsByPriceGreaterThan(w http.ResponseWriter, r *http.Request) {
+func (p *ProductHandler) SearchProducts(w http.ResponseWriter, r *http.Request) {
 
-	// get the price from the query param
-	priceGt := r.URL.Query().Get("priceGt")
-
-	// convert the price to float
-	priceGtFloat, err := strconv.ParseFloat(priceGt, 64)
+	// get the pagination and sorting from the query params
+	query, err := parseProductQuery(r.URL.Query())
 	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid price",
-			Status:  http.StatusBadRequest,
-		})
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeInvalidQuery, err.Error()))
 		return
 	}
 
-	// get the products by price
-	products := p.service.GetProductsByPriceGreaterThan(priceGtFloat)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	// get the filter expression, the old priceGt param is kept as a shorthand for price>X
+	expression := r.URL.Query().Get("filter")
+	if priceGt := r.URL.Query().Get("priceGt"); expression == "" && priceGt != "" {
+		expression = "price>" + priceGt
+	}
+	if expression != "" {
+		query.Where, err = filter.Parse(expression)
+		if err != nil {
+			var syntaxErr *filter.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				problem := NewProblem(http.StatusBadRequest, CodeInvalidFilter, "Invalid filter: "+syntaxErr.Message)
+				problem.Position, problem.Token = &syntaxErr.Pos, syntaxErr.Token
+				WriteProblem(w, r, problem)
+			} else {
+				writeError(w, r, "parsing the filter", err)
+			}
+			return
+		}
+	}
 
-	if len(products) == 0 {
-		w.Write([]byte(`[]`))
+	// get the products matching the filter
+	page, err := p.service.SearchProducts(r.Context(), query)
+	if err != nil {
+		writeError(w, r, "searching the products", err)
 		return
 	}
 
-	// parse each product to ResponseBodyProduct
-	productsAsResponse := parseProductsToBody(products)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 
-	json.NewEncoder(w).Encode(productsAsResponse)
+	// parse each product to ResponseBodyProduct
+	json.NewEncoder(w).Encode(ResponseProductPage{
+		Products: parseProductsToBody(page.Products),
+		Total:    page.Total,
+		Limit:    query.Limit,
+		Offset:   query.Offset,
+		Next:     nextPageURL(r.URL, query, page.Total),
+	})
 
 }
 
 func (p *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 
-	// --------check if json sent by client has all the required fields--------
-	// 1. get the body as bytes
+	// get the body as bytes
 	bytesJson, err := io.ReadAll(r.Body)
 	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid product",
-			Status:  http.StatusBadRequest,
-		})
-		return
-	}
-	// 2. parse the bytes to a map (simil json)
-	var bodyJson map[string]any
-	err = json.Unmarshal(bytesJson, &bodyJson)
-	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid product",
-			Status:  http.StatusBadRequest,
-		})
-		return
-	}
-	// 3. check if the map has all the required fields
-	err = checkRequiredFields(bodyJson, "name", "quantity", "code_value", "is_published", "expiration", "price")
-	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "There are missing fields",
-			Status:  http.StatusBadRequest,
-		})
+		writeError(w, r, "reading the product", errInvalidBody)
 		return
 	}
-	// -------------------------------------------------------------------------
 
-	// get the product from the request body
+	// get the product from the request body, it must have every required field and follow the rules
 	var product RequestBodyProduct
-
-	err = json.Unmarshal(bytesJson, &product)
-	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid product",
-			Status:  http.StatusBadRequest,
-		})
+	if err := decodeProduct(bytesJson, &product); err != nil {
+		writeError(w, r, "parsing the product", err)
 		return
 	}
 
 	// parse RequestBody to product model
 	newProduct, err := parseBodyToProduct(0, product)
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrInvalidExpirationFormat):
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Invalid expiration format",
-				Status:  http.StatusBadRequest,
-			})
-		default:
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Invalid product",
-				Status:  http.StatusBadRequest,
-			})
-		}
+		writeError(w, r, "parsing the product", err)
 		return
 	}
 
 	// create the product
-	newProduct, err = p.service.CreateProduct(newProduct)
+	newProduct, err = p.service.CreateProduct(r.Context(), newProduct)
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrProductExists):
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Product already exists",
-				Status:  http.StatusBadRequest,
-			})
-		case errors.Is(err, internal.ErrInvalidExpirationFormat):
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Invalid expiration format",
-				Status:  http.StatusBadRequest,
-			})
-		default:
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Invalid product",
-				Status:  http.StatusBadRequest,
-			})
-		}
+		writeError(w, r, "creating the product", err)
 		return
 	}
 
 	// parse product to ResponseBodyProduct
 	productAsResponse := parseProductToBody(newProduct)
 
+	w.Header().Set("ETag", productETag(newProduct))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(productAsResponse)
@@ -219,209 +191,177 @@ func (p *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	// convert the id to int
 	idProd, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid ID",
-			Status:  http.StatusBadRequest,
-		})
+		writeError(w, r, "parsing the id", errInvalidID)
 		return
 	}
 
-	// --------check if json sent by client has all the required fields--------
+	// get the body as bytes
 	bytesJson, err := io.ReadAll(r.Body)
 	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid product",
-			Status:  http.StatusBadRequest,
-		})
-		return
-	}
-	var mapJson map[string]any
-	if err := json.Unmarshal(bytesJson, &mapJson); err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid product",
-			Status:  http.StatusBadRequest,
-		})
-		return
-	}
-	err = checkRequiredFields(mapJson, "name", "quantity", "code_value", "is_published", "expiration", "price")
-	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "There are missing fields",
-			Status:  http.StatusBadRequest,
-		})
+		writeError(w, r, "reading the product", errInvalidBody)
 		return
 	}
 
-	// get the product from the request body
+	// get the product from the request body, it must have every required field and follow the rules
 	var product RequestBodyProduct
-	if err := json.Unmarshal(bytesJson, &product); err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid product",
-			Status:  http.StatusBadRequest,
-		})
+	if err := decodeProduct(bytesJson, &product); err != nil {
+		writeError(w, r, "parsing the product", err)
 		return
 	}
 
 	// parse RequestBody to product model
 	productModel, err := parseBodyToProduct(idProd, product)
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrInvalidExpirationFormat):
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Invalid expiration format",
-				Status:  http.StatusBadRequest,
-			})
-		default:
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Invalid product",
-				Status:  http.StatusBadRequest,
-			})
-		}
+		writeError(w, r, "parsing the product", err)
+		return
+	}
+
+	// the product is only replaced if it still has the version of If-Match
+	productModel.Version, err = expectedVersion(r, func() (internal.Product, error) {
+		return p.service.GetProductByID(r.Context(), idProd)
+	})
+	if err != nil {
+		writeError(w, r, "updating the product", err)
 		return
 	}
 
 	// call service
-	productModel, err = p.service.UpdateProduct(productModel)
+	productModel, err = p.service.UpdateProduct(r.Context(), productModel)
 	if err != nil {
-		response.Text(w, http.StatusBadRequest, err.Error())
+		writeError(w, r, "updating the product", err)
 		return
 	}
 
 	// parse productModel to ResponseBodyProduct
 	prodRes := parseProductToBody(productModel)
 
+	w.Header().Set("ETag", productETag(productModel))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(prodRes)
 
 }
 
-// for PATCH method
+// for PATCH method, the body is a JSON Merge Patch or, with its media type, a JSON Patch
 func (p *ProductHandler) ParcialUpdateProduct(w http.ResponseWriter, r *http.Request) {
 
 	// convert the id to int
 	idProd, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid ID",
-			Status:  http.StatusBadRequest,
-		})
+		writeError(w, r, "parsing the id", errInvalidID)
 		return
 	}
 
-	// get the product by id
-	product, err := p.service.GetProductByID(idProd)
-	if errors.Is(err, internal.ErrProductNotFound) {
-		response.JSON(w, http.StatusNotFound, ErrorResponse{
-			Message: "No products found",
-			Status:  http.StatusNotFound,
-		})
+	// get the format of the patch document
+	applyPatch, ok := patchFor(r.Header.Get("Content-Type"))
+	if !ok {
+		w.Header().Set("Accept-Patch", AcceptPatch)
+		WriteProblem(w, r, NewProblem(http.StatusUnsupportedMediaType, CodeUnsupportedMedia, "The body must be a patch document: "+AcceptPatch))
 		return
 	}
 
-	// get the product from the request body, setting the default values from the existent product
-	var productBody RequestBodyProduct = RequestBodyProduct{
-		Name:        product.Name,
-		Quantity:    product.Quantity,
-		CodeValue:   product.CodeValue,
-		IsPublished: product.IsPublished,
-		Expiration:  product.Expiration.Format("02/01/2006"),
-		Price:       product.Price,
-	}
-	if err := json.NewDecoder(r.Body).Decode(&productBody); err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid product",
-			Status:  http.StatusBadRequest,
-		})
+	// the patch is only applied to the version of If-Match
+	version, err := expectedVersion(r, func() (internal.Product, error) {
+		return p.service.GetProductByID(r.Context(), idProd)
+	})
+	if err != nil {
+		writeError(w, r, "updating the product", err)
 		return
 	}
 
-	// parse RequestBody to product model
-	productModel, err := parseBodyToProduct(idProd, productBody)
+	bytesJson, err := io.ReadAll(r.Body)
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrInvalidExpirationFormat):
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Invalid expiration format",
-				Status:  http.StatusBadRequest,
-			})
-		default:
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Invalid product",
-				Status:  http.StatusBadRequest,
-			})
-		}
+		writeError(w, r, "reading the patch", errInvalidBody)
 		return
 	}
 
-	// call service
-	productModel, err = p.service.UpdateProduct(productModel)
+	// without If-Match a concurrent change is not the concern of the client, the
+	// patch is applied again to the new version
+	productModel, err := p.patchProduct(r.Context(), idProd, version, applyPatch, bytesJson)
+	for attempt := 1; version == 0 && errors.Is(err, internal.ErrProductVersionMismatch) && attempt < maxPatchAttempts; attempt++ {
+		productModel, err = p.patchProduct(r.Context(), idProd, version, applyPatch, bytesJson)
+	}
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrCodeValueBelongsToOther):
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Code value belongs to other product",
-				Status:  http.StatusBadRequest,
-			})
-		case errors.Is(err, internal.ErrInvalidExpirationFormat):
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Invalid expiration format",
-				Status:  http.StatusBadRequest,
-			})
-		case errors.Is(err, internal.ErrProductEmpty):
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Product is empty",
-				Status:  http.StatusBadRequest,
-			})
-		default:
-			response.JSON(w, http.StatusBadRequest, ErrorResponse{
-				Message: "Invalid product",
-				Status:  http.StatusBadRequest,
-			})
-		}
+		writeError(w, r, "patching the product", err)
 		return
 	}
 
 	// parse productModel to ResponseBodyProduct
 	prodRes := parseProductToBody(productModel)
 
+	w.Header().Set("ETag", productETag(productModel))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(prodRes)
 
 }
 
-func (p *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+// maxPatchAttempts is how many times a patch without If-Match is applied
+// before giving up on a product that keeps changing
+const maxPatchAttempts = 3
 
-	// -----------------------------------------------------
-	// check auth header for deleting a product
-	authHeader := r.Header.Get("Authorization")
-	if authHeader != "1234" {
-		response.JSON(w, http.StatusUnauthorized, ErrorResponse{
-			Message: "Unauthorized",
-			Status:  http.StatusUnauthorized,
-		})
-		return
+// patchProduct applies the patch document to the stored product and updates
+// it, as long as nobody changed it in between. With a version the stored
+// product must have it.
+func (p *ProductHandler) patchProduct(ctx context.Context, id, version int, applyPatch func(document, patch []byte) ([]byte, error), patchDocument []byte) (internal.Product, error) {
+
+	// get the product by id
+	product, err := p.service.GetProductByID(ctx, id)
+	if err != nil {
+		return internal.Product{}, err
 	}
-	// -----------------------------------------------------
+	if version != 0 && version != product.Version {
+		return internal.Product{}, internal.ErrProductVersionMismatch
+	}
+
+	// apply the patch document to the product as the requests write it
+	document, err := json.Marshal(parseProductToRequestBody(product))
+	if err != nil {
+		return internal.Product{}, fmt.Errorf("encoding the product: %w", err)
+	}
+	patched, err := applyPatch(document, patchDocument)
+	if err != nil {
+		return internal.Product{}, err
+	}
+
+	// the patched product follows the same rules as a new one
+	var productBody RequestBodyProduct
+	if err := decodePatchedProduct(patched, &productBody); err != nil {
+		return internal.Product{}, err
+	}
+
+	// parse RequestBody to product model, the update expects the version the patch was applied to
+	productModel, err := parseBodyToProduct(id, productBody)
+	if err != nil {
+		return internal.Product{}, err
+	}
+	productModel.Version = product.Version
+
+	return p.service.UpdateProduct(ctx, productModel)
+}
+
+func (p *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 
 	// convert the id to int
 	idProd, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "Invalid ID",
-			Status:  http.StatusBadRequest,
-		})
+		writeError(w, r, "parsing the id", errInvalidID)
+		return
+	}
+
+	// the product is only deleted if it still has the version of If-Match
+	version, err := expectedVersion(r, func() (internal.Product, error) {
+		return p.service.GetProductByID(r.Context(), idProd)
+	})
+	if err != nil {
+		writeError(w, r, "deleting the product", err)
 		return
 	}
 
 	// delete prod
-	err = p.service.DeleteProduct(idProd)
+	err = p.service.DeleteProduct(r.Context(), idProd, version)
 	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "There was a problem deleting the product",
-			Status:  http.StatusBadRequest,
-		})
+		writeError(w, r, "deleting the product", err)
 		return
 	}
 
@@ -442,10 +382,7 @@ func (p *ProductHandler) CalculateConsumerPrice(w http.ResponseWriter, r *http.R
 		for _, str := range sliceStr {
 			integer, err := strconv.Atoi(str)
 			if err != nil {
-				response.JSON(w, http.StatusBadRequest, ErrorResponse{
-					Message: "Invalid list",
-					Status:  http.StatusBadRequest,
-				})
+				WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeInvalidQuery, "The list must hold product ids"))
 				return
 			}
 			sliceInt = append(sliceInt, integer)
@@ -453,12 +390,9 @@ func (p *ProductHandler) CalculateConsumerPrice(w http.ResponseWriter, r *http.R
 	}
 
 	// call service
-	products, price, err := p.service.CalculateConsumerPrice(sliceInt...) // if no params are passed, sliceInt is empty, i.e. CalculateConsumerPrice()
+	products, price, err := p.service.CalculateConsumerPrice(r.Context(), sliceInt...) // if no params are passed, sliceInt is empty, i.e. CalculateConsumerPrice()
 	if err != nil {
-		response.JSON(w, http.StatusBadRequest, ErrorResponse{
-			Message: "There was a problem calculating the consumer price",
-			Status:  http.StatusBadRequest,
-		})
+		writeError(w, r, "calculating the consumer price", err)
 		return
 	}
 
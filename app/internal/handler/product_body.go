@@ -1,18 +1,27 @@
 package handler
 
 import (
+	"encoding/json"
 	"errors"
 	"goweb/app/internal"
+	"goweb/app/internal/patch"
+	"goweb/app/internal/validation"
+	"mime"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 )
 
+// RequestBodyProduct declares the rules of the products of POST, PUT and
+// PATCH, see the validation package
 type RequestBodyProduct struct {
-	Name        string  `json:"name"`
-	Quantity    int     `json:"quantity"`
-	CodeValue   string  `json:"code_value"`
-	IsPublished bool    `json:"is_published"`
-	Expiration  string  `json:"expiration"`
-	Price       float64 `json:"price"`
+	Name        string  `json:"name" validate:"required,notblank,max=100"`
+	Quantity    int     `json:"quantity" validate:"required,min=0"`
+	CodeValue   string  `json:"code_value" validate:"required,min=1,max=32,pattern=^[A-Za-z0-9_-]+$"`
+	IsPublished bool    `json:"is_published" validate:"required"`
+	Expiration  string  `json:"expiration" validate:"required,date,after=01/01/2000"`
+	Price       float64 `json:"price" validate:"required,min=0"`
 }
 
 type ResponseBodyProduct struct {
@@ -38,8 +47,22 @@ func parseProductToBody(product internal.Product) ResponseBodyProduct {
 	}
 }
 
+// parseProductToRequestBody returns the product as it is written in the
+// requests, the document a PATCH applies to
+func parseProductToRequestBody(product internal.Product) RequestBodyProduct {
+
+	return RequestBodyProduct{
+		Name:        product.Name,
+		Quantity:    product.Quantity,
+		CodeValue:   product.CodeValue,
+		IsPublished: product.IsPublished,
+		Expiration:  product.Expiration.Format(validation.DateLayout),
+		Price:       product.Price,
+	}
+}
+
 func parseProductsToBody(products []internal.Product) []ResponseBodyProduct {
-	var productsAsResponse []ResponseBodyProduct
+	productsAsResponse := make([]ResponseBodyProduct, 0, len(products))
 	for _, product := range products {
 		productsAsResponse = append(productsAsResponse, parseProductToBody(product))
 	}
@@ -48,7 +71,7 @@ func parseProductsToBody(products []internal.Product) []ResponseBodyProduct {
 
 func parseBodyToProduct(id int, body RequestBodyProduct) (internal.Product, error) {
 	// if time cant parse it, then it is invalid
-	parsedTime, err := time.Parse("02/01/2006", body.Expiration)
+	parsedTime, err := time.Parse(validation.DateLayout, body.Expiration)
 	if err != nil {
 		return internal.Product{}, internal.ErrInvalidExpirationFormat
 	}
@@ -63,22 +86,152 @@ func parseBodyToProduct(id int, body RequestBodyProduct) (internal.Product, erro
 	}, nil
 }
 
-func checkRequiredFields(body map[string]any, requiredFields ...string) error {
-	for _, field := range requiredFields {
-		_, ok := body[field]
-		if !ok {
-			return errors.New("Missing field " + field)
+// decodeProduct decodes the JSON body into product and checks the result
+// against the rules of RequestBodyProduct, reporting every invalid field at
+// once. The body must hold the required fields.
+func decodeProduct(data []byte, product *RequestBodyProduct) error {
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		return errInvalidBody
+	}
+
+	// a value of the wrong type is reported along with the other fields
+	var violations []internal.FieldError
+	if err := decodeBody(data, product); err != nil {
+		var typeErr *internal.ValidationError
+		if !errors.As(err, &typeErr) {
+			return err
+		}
+		violations = typeErr.Fields
+	}
+
+	var ruleErr *internal.ValidationError
+	if errors.As(validation.Struct(*product, fields), &ruleErr) {
+		for _, violation := range ruleErr.Fields {
+			if len(violations) == 0 || violations[0].Field != violation.Field {
+				violations = append(violations, violation)
+			}
 		}
 	}
+
+	if len(violations) > 0 {
+		return &internal.ValidationError{Fields: violations}
+	}
 	return nil
 }
 
+// AcceptPatch lists the media types of the patch documents PATCH accepts
+const AcceptPatch = patch.MediaTypeMergePatch + ", " + patch.MediaTypeJSONPatch
+
+// patchFor returns the function applying the patch documents of the media
+// type. A plain JSON body is read as a merge patch, the format PATCH took
+// before it accepted patch documents.
+func patchFor(contentType string) (func(document, patch []byte) ([]byte, error), bool) {
+
+	if contentType == "" {
+		return patch.Merge, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	switch mediaType {
+	case patch.MediaTypeMergePatch, "application/json":
+		return patch.Merge, true
+	case patch.MediaTypeJSONPatch:
+		return patch.Apply, true
+	}
+	return nil, false
+}
+
+// decodePatchedProduct decodes the product a patch returned like
+// decodeProduct does a new one, also rejecting the fields RequestBodyProduct
+// does not have, which a patch can add
+func decodePatchedProduct(data []byte, product *RequestBodyProduct) error {
+
+	err := decodeProduct(data, product)
+	var fields map[string]any
+	if json.Unmarshal(data, &fields) != nil {
+		return err
+	}
+	unknown := unknownFields(fields, reflect.TypeOf(*product))
+	if len(unknown) == 0 {
+		return err
+	}
+
+	var validationErr *internal.ValidationError
+	if err == nil {
+		validationErr = &internal.ValidationError{}
+	} else if !errors.As(err, &validationErr) {
+		return err
+	}
+	validationErr.Fields = append(validationErr.Fields, unknown...)
+	return validationErr
+}
+
+// unknownFields returns an error for each member of fields that is not a json field of t, sorted by name
+func unknownFields(fields map[string]any, t reflect.Type) []internal.FieldError {
+
+	known := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		known[name] = true
+	}
+
+	var unknown []internal.FieldError
+	for name := range fields {
+		if !known[name] {
+			unknown = append(unknown, internal.FieldError{Field: name, Code: internal.FieldUnknown, Message: "is not a field of the product"})
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Field < unknown[j].Field })
+	return unknown
+}
+
+// decodeBody unmarshals the JSON body into v. A value of the wrong type is
+// reported as an error of its field, anything else as errInvalidBody.
+func decodeBody(data []byte, v any) error {
+
+	err := json.Unmarshal(data, v)
+	if err == nil {
+		return nil
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return &internal.ValidationError{Fields: []internal.FieldError{
+			{Field: typeErr.Field, Code: internal.FieldInvalidType, Message: "must be a " + jsonType(typeErr.Type.Kind())},
+		}}
+	}
+	return errInvalidBody
+}
+
+// jsonType names the JSON type of a Go kind
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	default:
+		return "number"
+	}
+}
+
 type ResponseConsumerPrice struct {
 	Products   []ResponseBodyProduct `json:"products"`
 	TotalPrice float64               `json:"total_price"`
 }
 
-type ErrorResponse struct {
-	Message string `json:"message"`
-	Status  int    `json:"status"`
+type ResponseProductPage struct {
+	Products []ResponseBodyProduct `json:"products"`
+	Total    int                   `json:"total"`
+	Limit    int                   `json:"limit"`
+	Offset   int                   `json:"offset"`
+	Next     string                `json:"next,omitempty"`
 }
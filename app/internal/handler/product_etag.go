@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"goweb/app/internal"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// productETag is the entity tag of the version of the product, e.g. "3"
+func productETag(product internal.Product) string {
+	return `"` + strconv.Itoa(product.Version) + `"`
+}
+
+// tagVersion returns the version of a strong entity tag of productETag
+func tagVersion(tag string) (int, bool) {
+
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	return version, err == nil && version > 0
+}
+
+// entityTags splits the list of entity tags of an If-Match or If-None-Match header
+func entityTags(header string) []string {
+
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// notModified reports whether the If-None-Match header of the request matches
+// the product, so a GET can answer 304. The tags are compared weakly, as RFC
+// 9110 asks, a W/ tag matches the same version.
+func notModified(r *http.Request, product internal.Product) bool {
+
+	etag := productETag(product)
+	for _, tag := range entityTags(r.Header.Get("If-None-Match")) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// expectedVersion returns the version of the product the If-Match header of
+// the request expects, 0 when there is no header or it is "*". The tags are
+// compared strongly, so a weak or unknown tag never matches and the request
+// fails with internal.ErrProductVersionMismatch. With several tags the one of
+// the current version is the expected one, current reads it.
+func expectedVersion(r *http.Request, current func() (internal.Product, error)) (int, error) {
+
+	var versions []int
+	for _, tag := range entityTags(r.Header.Get("If-Match")) {
+		if tag == "*" {
+			return 0, nil
+		}
+		if version, ok := tagVersion(tag); ok {
+			versions = append(versions, version)
+		}
+	}
+
+	switch {
+	case r.Header.Get("If-Match") == "":
+		return 0, nil
+	case len(versions) == 0:
+		return 0, internal.ErrProductVersionMismatch
+	case len(versions) == 1:
+		return versions[0], nil
+	}
+
+	product, err := current()
+	if err != nil {
+		return 0, err
+	}
+	for _, version := range versions {
+		if version == product.Version {
+			return version, nil
+		}
+	}
+	return 0, internal.ErrProductVersionMismatch
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"goweb/app/internal"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parseProductQuery builds an internal.ProductQuery from the query params of GET /products:
+//
+//	q=merlot                          full-text search over the names, by relevance
+//	limit, offset                     pagination (limit defaults to 50, max 500)
+//	sort=price,-expiration            ordering, a leading "-" means descending
+//	is_published=true                 published filter
+//	quantity_min, quantity_max        inclusive quantity range
+//	expiration_from, expiration_to    inclusive expiration range (dd/mm/yyyy)
+func parseProductQuery(values url.Values) (internal.ProductQuery, error) {
+
+	query := internal.ProductQuery{Limit: defaultPageLimit, Text: values.Get("q")}
+
+	// pagination
+	if limit := values.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil || limitInt < 1 || limitInt > maxPageLimit {
+			return internal.ProductQuery{}, fmt.Errorf("limit must be a number between 1 and %d", maxPageLimit)
+		}
+		query.Limit = limitInt
+	}
+	if offset := values.Get("offset"); offset != "" {
+		offsetInt, err := strconv.Atoi(offset)
+		if err != nil || offsetInt < 0 {
+			return internal.ProductQuery{}, errors.New("offset must be a positive number")
+		}
+		query.Offset = offsetInt
+	}
+
+	// sorting
+	if sort := values.Get("sort"); sort != "" {
+		for _, field := range strings.Split(sort, ",") {
+			productSort := internal.ProductSort{Field: internal.ProductField(strings.TrimPrefix(field, "-"))}
+			productSort.Desc = strings.HasPrefix(field, "-")
+			if !productSort.Field.IsValid() {
+				return internal.ProductQuery{}, fmt.Errorf("cannot sort by %q", field)
+			}
+			query.Sort = append(query.Sort, productSort)
+		}
+	}
+
+	// filters
+	if isPublished := values.Get("is_published"); isPublished != "" {
+		isPublishedBool, err := strconv.ParseBool(isPublished)
+		if err != nil {
+			return internal.ProductQuery{}, errors.New("is_published must be true or false")
+		}
+		query.Filters = append(query.Filters, internal.ProductFilter{Field: internal.ProductFieldIsPublished, Op: internal.FilterOpEq, Value: isPublishedBool})
+	}
+
+	quantityParams := []struct {
+		param string
+		op    internal.FilterOp
+	}{
+		{"quantity_min", internal.FilterOpGte},
+		{"quantity_max", internal.FilterOpLte},
+	}
+	for _, q := range quantityParams {
+		value := values.Get(q.param)
+		if value == "" {
+			continue
+		}
+		quantity, err := strconv.Atoi(value)
+		if err != nil {
+			return internal.ProductQuery{}, fmt.Errorf("%s must be a number", q.param)
+		}
+		query.Filters = append(query.Filters, internal.ProductFilter{Field: internal.ProductFieldQuantity, Op: q.op, Value: quantity})
+	}
+
+	expirationParams := []struct {
+		param string
+		op    internal.FilterOp
+	}{
+		{"expiration_from", internal.FilterOpGte},
+		{"expiration_to", internal.FilterOpLte},
+	}
+	for _, e := range expirationParams {
+		value := values.Get(e.param)
+		if value == "" {
+			continue
+		}
+		expiration, err := time.Parse("02/01/2006", value)
+		if err != nil {
+			return internal.ProductQuery{}, fmt.Errorf("%s must have the format dd/mm/yyyy", e.param)
+		}
+		query.Filters = append(query.Filters, internal.ProductFilter{Field: internal.ProductFieldExpiration, Op: e.op, Value: expiration})
+	}
+
+	return query, nil
+}
+
+// nextPageURL returns the link to the page after the current one, or "" when it is the last page
+func nextPageURL(u *url.URL, query internal.ProductQuery, total int) string {
+
+	if query.Offset+query.Limit >= total {
+		return ""
+	}
+
+	values := u.Query()
+	values.Set("limit", strconv.Itoa(query.Limit))
+	values.Set("offset", strconv.Itoa(query.Offset+query.Limit))
+
+	return u.Path + "?" + values.Encode()
+}
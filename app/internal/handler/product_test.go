@@ -2,12 +2,14 @@ package handler_test
 
 import (
 	"context"
+	"errors"
 	"goweb/app/internal"
 	"goweb/app/internal/handler"
 	"goweb/app/internal/repository"
 	"goweb/app/internal/service"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -52,10 +54,10 @@ func TestGetAllProducts(t *testing.T) {
 
 		// Assert
 		expectedCode := http.StatusOK
-		expectedBody := `[
+		expectedBody := `{"products":[
 							{"id":1,"name":"Producto 1","quantity":10,"code_value":"123456","is_published":true,"expiration":"31/12/2021","price":100},
 							{"id":2,"name":"Producto 2","quantity":20,"code_value":"654321","is_published":false,"expiration":"31/12/2021","price":200}
-						]`
+						],"total":2,"limit":50,"offset":0}`
 		expectedHeader := http.Header{
 			"Content-Type": []string{"application/json"},
 		}
@@ -64,6 +66,173 @@ func TestGetAllProducts(t *testing.T) {
 		require.Equal(t, expectedHeader, res.Header())
 	})
 
+	t.Run("Si el repositorio falla se devuelve un error 500 y no un 404.", func(t *testing.T) {
+		// Arrange
+		repo := &failingRepository{err: errors.New("connection refused")}
+		service := service.NewProductService(repo)
+		handler := handler.NewProductHandler(service)
+
+		req := httptest.NewRequest("GET", "/products", nil)
+		res := httptest.NewRecorder()
+
+		// Act
+		handler.GetAllProducts(res, req)
+
+		// Assert
+		expectedCode := http.StatusInternalServerError
+		expectedBody := `{"type":"/problems/internal_error","title":"Internal Server Error","status":500,"detail":"Internal server error","instance":"/products","code":"internal_error"}`
+		require.Equal(t, expectedCode, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+	})
+
+}
+
+func TestGetAllProductsPagination(t *testing.T) {
+	// Arrange
+	data := map[int]internal.Product{
+		1: {ID: 1, Name: "Producto 1", Quantity: 10, CodeValue: "A1", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 300},
+		2: {ID: 2, Name: "Producto 2", Quantity: 20, CodeValue: "A2", IsPublished: false, Expiration: time.Date(2022, 6, 30, 0, 0, 0, 0, time.UTC), Price: 100},
+		3: {ID: 3, Name: "Producto 3", Quantity: 30, CodeValue: "A3", IsPublished: true, Expiration: time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC), Price: 100},
+		4: {ID: 4, Name: "Producto 4", Quantity: 40, CodeValue: "A4", IsPublished: true, Expiration: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Price: 200},
+	}
+
+	t.Run("Se pagina el catalogo y se devuelve el link a la siguiente pagina.", func(t *testing.T) {
+		// Arrange
+		repo := repository.NewRepositoryMap(data)
+		service := service.NewProductService(repo)
+		handler := handler.NewProductHandler(service)
+
+		req := httptest.NewRequest("GET", "/products?limit=2&offset=1", nil)
+		res := httptest.NewRecorder()
+
+		// Act
+		handler.GetAllProducts(res, req)
+
+		// Assert
+		expectedCode := http.StatusOK
+		expectedBody := `{"products":[
+							{"id":2,"name":"Producto 2","quantity":20,"code_value":"A2","is_published":false,"expiration":"30/06/2022","price":100},
+							{"id":3,"name":"Producto 3","quantity":30,"code_value":"A3","is_published":true,"expiration":"15/01/2023","price":100}
+						],"total":4,"limit":2,"offset":1,"next":"/products?limit=2&offset=3"}`
+		require.Equal(t, expectedCode, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+	})
+
+	t.Run("Se filtra y ordena por varios campos.", func(t *testing.T) {
+		// Arrange
+		repo := repository.NewRepositoryMap(data)
+		service := service.NewProductService(repo)
+		handler := handler.NewProductHandler(service)
+
+		req := httptest.NewRequest("GET", "/products?is_published=true&quantity_min=20&sort=price,-expiration", nil)
+		res := httptest.NewRecorder()
+
+		// Act
+		handler.GetAllProducts(res, req)
+
+		// Assert
+		expectedCode := http.StatusOK
+		expectedBody := `{"products":[
+							{"id":3,"name":"Producto 3","quantity":30,"code_value":"A3","is_published":true,"expiration":"15/01/2023","price":100},
+							{"id":4,"name":"Producto 4","quantity":40,"code_value":"A4","is_published":true,"expiration":"01/03/2024","price":200}
+						],"total":2,"limit":50,"offset":0}`
+		require.Equal(t, expectedCode, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+	})
+
+	t.Run("Un catalogo vacio devuelve una pagina vacia y no un 404.", func(t *testing.T) {
+		// Arrange
+		repo := repository.NewRepositoryMap(map[int]internal.Product{})
+		service := service.NewProductService(repo)
+		handler := handler.NewProductHandler(service)
+
+		req := httptest.NewRequest("GET", "/products", nil)
+		res := httptest.NewRecorder()
+
+		// Act
+		handler.GetAllProducts(res, req)
+
+		// Assert
+		expectedCode := http.StatusOK
+		expectedBody := `{"products":[],"total":0,"limit":50,"offset":0}`
+		require.Equal(t, expectedCode, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+	})
+
+	t.Run("Se buscan productos por nombre ordenados por relevancia.", func(t *testing.T) {
+		// Arrange
+		data := map[int]internal.Product{
+			1: {ID: 1, Name: "Wine - Merlotte Blend", Quantity: 10, CodeValue: "W1", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 100},
+			2: {ID: 2, Name: "Bread - Baguette", Quantity: 10, CodeValue: "B1", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 100},
+			3: {ID: 3, Name: "Wine - Red Oakridge Merlot", Quantity: 10, CodeValue: "W2", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 100},
+		}
+		repo := repository.NewRepositoryMap(data)
+		service := service.NewProductService(repo)
+		handler := handler.NewProductHandler(service)
+
+		req := httptest.NewRequest("GET", "/products?q=M%C3%A9rlot", nil)
+		res := httptest.NewRecorder()
+
+		// Act
+		handler.GetAllProducts(res, req)
+
+		// Assert
+		expectedCode := http.StatusOK
+		expectedBody := `{"products":[
+							{"id":3,"name":"Wine - Red Oakridge Merlot","quantity":10,"code_value":"W2","is_published":true,"expiration":"31/12/2021","price":100},
+							{"id":1,"name":"Wine - Merlotte Blend","quantity":10,"code_value":"W1","is_published":true,"expiration":"31/12/2021","price":100}
+						],"total":2,"limit":50,"offset":0}`
+		require.Equal(t, expectedCode, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+	})
+
+	t.Run("Un campo de ordenamiento desconocido es un error del cliente.", func(t *testing.T) {
+		// Arrange
+		repo := repository.NewRepositoryMap(data)
+		service := service.NewProductService(repo)
+		handler := handler.NewProductHandler(service)
+
+		req := httptest.NewRequest("GET", "/products?sort=color", nil)
+		res := httptest.NewRecorder()
+
+		// Act
+		handler.GetAllProducts(res, req)
+
+		// Assert
+		expectedCode := http.StatusBadRequest
+		expectedBody := `{"type":"/problems/invalid_query","title":"Bad Request","status":400,"detail":"cannot sort by \"color\"","instance":"/products","code":"invalid_query"}`
+		require.Equal(t, expectedCode, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+	})
+}
+
+// failingRepository is a repository whose storage is always unavailable
+type failingRepository struct {
+	err error
+}
+
+func (f *failingRepository) GetAllProducts(ctx context.Context) ([]internal.Product, error) {
+	return nil, f.err
+}
+
+func (f *failingRepository) SearchProducts(ctx context.Context, query internal.ProductQuery) (internal.ProductPage, error) {
+	return internal.ProductPage{}, f.err
+}
+
+func (f *failingRepository) GetProductByID(ctx context.Context, id int) (internal.Product, error) {
+	return internal.Product{}, f.err
+}
+
+func (f *failingRepository) AddProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+	return internal.Product{}, f.err
+}
+
+func (f *failingRepository) UpdateProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+	return internal.Product{}, f.err
+}
+
+func (f *failingRepository) DeleteProduct(ctx context.Context, id, version int) error {
+	return f.err
 }
 
 func TestGetProductByID(t *testing.T) {
@@ -110,6 +279,7 @@ func TestGetProductByID(t *testing.T) {
 		expectedBody := `{"id":1,"name":"Producto 1","quantity":10,"code_value":"123456","is_published":true,"expiration":"31/12/2021","price":100}`
 		expectedHeader := http.Header{
 			"Content-Type": []string{"application/json"},
+			"Etag":         []string{`"1"`},
 		}
 		require.Equal(t, expectedCode, res.Code)
 		require.JSONEq(t, expectedBody, res.Body.String())
@@ -136,9 +306,9 @@ func TestGetProductByID(t *testing.T) {
 
 		// Assert
 		expectedCode := http.StatusBadRequest
-		expectedBody := `{"message":"Invalid ID", "status": 400}`
+		expectedBody := `{"type":"/problems/invalid_id","title":"Bad Request","status":400,"detail":"The id must be a number","instance":"/products/NOTNUMBER","code":"invalid_id"}`
 		expectedHeader := http.Header{
-			"Content-Type": []string{"application/json"},
+			"Content-Type": []string{"application/problem+json"},
 		}
 		require.Equal(t, expectedCode, res.Code)
 		require.JSONEq(t, expectedBody, res.Body.String())
@@ -185,9 +355,9 @@ func TestGetProductByID(t *testing.T) {
 
 		// Assert
 		expectedCode := http.StatusNotFound
-		expectedBody := `{"message":"No products found", "status": 404}`
+		expectedBody := `{"type":"/problems/product_not_found","title":"Not Found","status":404,"detail":"No products found","instance":"/products/3","code":"product_not_found"}`
 		expectedHeader := http.Header{
-			"Content-Type": []string{"application/json"},
+			"Content-Type": []string{"application/problem+json"},
 		}
 		require.Equal(t, expectedCode, res.Code)
 		require.JSONEq(t, expectedBody, res.Body.String())
@@ -207,7 +377,6 @@ func TestCreateProduct(t *testing.T) {
 
 		res := httptest.NewRecorder()
 		req := httptest.NewRequest("POST", "/products", body)
-		req.Header.Add("Authorization", "1234")
 
 		// Act
 		handler.CreateProduct(res, req)
@@ -217,54 +386,318 @@ func TestCreateProduct(t *testing.T) {
 		expectedBody := `{"id":1,"name":"Producto 1","quantity":10,"code_value":"123456","is_published":true,"expiration":"31/12/2021","price":100}`
 		expectedHeader := http.Header{
 			"Content-Type": []string{"application/json"},
+			"Etag":         []string{`"1"`},
 		}
 		require.Equal(t, expectedCode, res.Code)
 		require.JSONEq(t, expectedBody, res.Body.String())
 		require.Equal(t, expectedHeader, res.Header())
 	})
-}
 
-func TestDeleteProduct(t *testing.T) {
-	t.Run("Se elimina el producto con dicho id, y no es necesario retornar nada.", func(t *testing.T) {
+	t.Run("Un code_value repetido es un conflicto 409 con el campo y el request id.", func(t *testing.T) {
 		// Arrange
 		data := map[int]internal.Product{
-			1: {
-				ID:          1,
-				Name:        "Producto 1",
-				Quantity:    10,
-				CodeValue:   "123456",
-				IsPublished: true,
-				Expiration:  time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC),
-				Price:       100,
-			},
+			1: {ID: 1, Name: "Producto 1", Quantity: 10, CodeValue: "123456", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 100},
 		}
 		repo := repository.NewRepositoryMap(data)
 		service := service.NewProductService(repo)
 		handler := handler.NewProductHandler(service)
 
+		body := strings.NewReader(`{"name":"Producto 2","quantity":5,"code_value":"123456","is_published":true,"expiration":"31/12/2021","price":50}`)
 		res := httptest.NewRecorder()
-		req := httptest.NewRequest("DELETE", "/products/1", nil)
-		req.Header.Add("Authorization", "1234")
+		req := httptest.NewRequest("POST", "/products", body)
+		req = req.WithContext(internal.ContextWithRequestID(req.Context(), "req-1"))
 
-		// add id path param to the request
+		// Act
+		handler.CreateProduct(res, req)
+
+		// Assert
+		expectedCode := http.StatusConflict
+		expectedBody := `{"type":"/problems/duplicate_code_value","title":"Conflict","status":409,"detail":"code_value belongs to another product",
+							"instance":"/products","code":"duplicate_code_value","request_id":"req-1",
+							"errors":[{"field":"code_value","code":"duplicate","message":"belongs to another product"}]}`
+		expectedHeader := http.Header{
+			"Content-Type": []string{"application/problem+json"},
+		}
+		require.Equal(t, expectedCode, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+		require.Equal(t, expectedHeader, res.Header())
+	})
+
+	t.Run("Se informan todos los campos faltantes o con tipo erroneo.", func(t *testing.T) {
+		// Arrange
+		repo := repository.NewRepositoryMap(map[int]internal.Product{})
+		service := service.NewProductService(repo)
+		handler := handler.NewProductHandler(service)
+
+		missingRes := httptest.NewRecorder()
+		missingReq := httptest.NewRequest("POST", "/products", strings.NewReader(`{"name":"Producto 1","quantity":10,"is_published":true}`))
+		typeRes := httptest.NewRecorder()
+		typeReq := httptest.NewRequest("POST", "/products", strings.NewReader(`{"name":"Producto 1","quantity":"ten","code_value":"1","is_published":true,"expiration":"31/12/2021","price":100}`))
+
+		// Act
+		handler.CreateProduct(missingRes, missingReq)
+		handler.CreateProduct(typeRes, typeReq)
+
+		// Assert
+		require.Equal(t, http.StatusBadRequest, missingRes.Code)
+		require.JSONEq(t, `{"type":"/problems/validation_failed","title":"Bad Request","status":400,"detail":"The request has invalid fields",
+							"instance":"/products","code":"validation_failed","errors":[
+								{"field":"code_value","code":"required","message":"is required"},
+								{"field":"expiration","code":"required","message":"is required"},
+								{"field":"price","code":"required","message":"is required"}
+							]}`, missingRes.Body.String())
+		require.Equal(t, http.StatusBadRequest, typeRes.Code)
+		require.Contains(t, typeRes.Body.String(), `"errors":[{"field":"quantity","code":"invalid_type","message":"must be a number"}]`)
+	})
+}
+
+func TestProductValidation(t *testing.T) {
+	data := map[int]internal.Product{
+		1: {ID: 1, Name: "Producto 1", Quantity: 10, CodeValue: "123456", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 100},
+	}
+
+	t.Run("Un POST informa todos los campos que rompen las reglas a la vez.", func(t *testing.T) {
+		// Arrange
+		repo := repository.NewRepositoryMap(map[int]internal.Product{})
+		service := service.NewProductService(repo)
+		handler := handler.NewProductHandler(service)
+
+		body := strings.NewReader(`{"name":" ","quantity":-1,"code_value":"12 34","is_published":true,"expiration":"31/12/1900","price":-5}`)
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/products", body)
+
+		// Act
+		handler.CreateProduct(res, req)
+
+		// Assert
+		expectedCode := http.StatusBadRequest
+		expectedBody := `{"type":"/problems/validation_failed","title":"Bad Request","status":400,"detail":"The request has invalid fields",
+							"instance":"/products","code":"validation_failed","errors":[
+								{"field":"name","code":"invalid_value","message":"must not be blank"},
+								{"field":"quantity","code":"out_of_range","message":"must be at least 0"},
+								{"field":"code_value","code":"invalid_format","message":"must match ^[A-Za-z0-9_-]+$"},
+								{"field":"expiration","code":"out_of_range","message":"cannot be before 01/01/2000"},
+								{"field":"price","code":"out_of_range","message":"must be at least 0"}
+							]}`
+		require.Equal(t, expectedCode, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+		products, err := repo.GetAllProducts(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, products)
+	})
+
+	t.Run("Un PATCH aplica las mismas reglas al producto resultante.", func(t *testing.T) {
+		// Arrange
+		repo := repository.NewRepositoryMap(data)
+		service := service.NewProductService(repo)
+		handler := handler.NewProductHandler(service)
+
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("PATCH", "/products/1", strings.NewReader(`{"price":-1,"quantity":"many"}`))
 		chiCtx := chi.NewRouteContext()
 		chiCtx.URLParams.Add("id", "1")
-		context := context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx)
-		req = req.WithContext(context)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
 
 		// Act
-		handler.DeleteProduct(res, req)
+		handler.ParcialUpdateProduct(res, req)
 
 		// Assert
-		expectedCode := http.StatusNoContent
-		expectedBody := ""
-		expectedHeader := http.Header{}
-		require.Equal(t, expectedCode, res.Code)
-		require.Equal(t, expectedBody, res.Body.String())
-		require.Equal(t, expectedHeader, res.Header())
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		require.Contains(t, res.Body.String(), `"errors":[{"field":"quantity","code":"invalid_type","message":"must be a number"},`+
+			`{"field":"price","code":"out_of_range","message":"must be at least 0"}]`)
+		stored, err := repo.GetProductByID(context.Background(), 1)
+		require.NoError(t, err)
+		require.Equal(t, 100.0, stored.Price)
 	})
+}
+
+func TestParcialUpdateProduct(t *testing.T) {
+	// patch sends the patch document of the media type to the product 1
+	patch := func(contentType, body string) (*httptest.ResponseRecorder, internal.ProductRepository) {
+		repo := repository.NewRepositoryMap(map[int]internal.Product{
+			1: {ID: 1, Name: "Producto 1", Quantity: 10, CodeValue: "123456", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 100},
+		})
+		handler := handler.NewProductHandler(service.NewProductService(repo))
 
-	t.Run("Se intenta eliminar un producto pero no esta autorizado", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("PATCH", "/products/1", strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("id", "1")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		handler.ParcialUpdateProduct(res, req)
+		return res, repo
+	}
+
+	t.Run("Un JSON Merge Patch cambia solo los campos que trae.", func(t *testing.T) {
+		// Act
+		res, _ := patch("application/merge-patch+json", `{"price":120.5,"is_published":false}`)
+
+		// Assert
+		expectedBody := `{"id":1,"name":"Producto 1","quantity":10,"code_value":"123456","is_published":false,"expiration":"31/12/2021","price":120.5}`
+		require.Equal(t, http.StatusOK, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+	})
+
+	t.Run("Un null de un Merge Patch quita el campo, que es obligatorio.", func(t *testing.T) {
+		// Act
+		res, _ := patch("application/merge-patch+json", `{"name":null,"stock":5}`)
+
+		// Assert
+		expectedBody := `{"type":"/problems/validation_failed","title":"Bad Request","status":400,"detail":"The request has invalid fields",
+							"instance":"/products/1","code":"validation_failed","errors":[
+								{"field":"name","code":"required","message":"is required"},
+								{"field":"stock","code":"unknown","message":"is not a field of the product"}
+							]}`
+		require.Equal(t, http.StatusBadRequest, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+	})
+
+	t.Run("Un JSON Patch aplica sus operaciones si pasan los test.", func(t *testing.T) {
+		// Act
+		res, _ := patch("application/json-patch+json", `[
+			{"op":"test","path":"/price","value":100},
+			{"op":"replace","path":"/price","value":90},
+			{"op":"copy","from":"/code_value","path":"/name"}
+		]`)
+
+		// Assert
+		expectedBody := `{"id":1,"name":"123456","quantity":10,"code_value":"123456","is_published":true,"expiration":"31/12/2021","price":90}`
+		require.Equal(t, http.StatusOK, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+	})
+
+	t.Run("Un test fallido no cambia el producto.", func(t *testing.T) {
+		// Act
+		res, repo := patch("application/json-patch+json", `[{"op":"replace","path":"/quantity","value":0},{"op":"test","path":"/price","value":99}]`)
+
+		// Assert
+		expectedBody := `{"type":"/problems/patch_test_failed","title":"Conflict","status":409,"detail":"expected 99 but found 100",
+							"instance":"/products/1","code":"patch_test_failed","operation":1,"path":"/price"}`
+		require.Equal(t, http.StatusConflict, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+		stored, err := repo.GetProductByID(context.Background(), 1)
+		require.NoError(t, err)
+		require.Equal(t, 10, stored.Quantity)
+	})
+
+	t.Run("Una operacion que no se puede aplicar devuelve 422 y una invalida 400.", func(t *testing.T) {
+		// Act
+		missing, _ := patch("application/json-patch+json", `[{"op":"remove","path":"/stock"}]`)
+		unknown, _ := patch("application/json-patch+json", `[{"op":"increment","path":"/quantity"}]`)
+
+		// Assert
+		require.Equal(t, http.StatusUnprocessableEntity, missing.Code)
+		require.Contains(t, missing.Body.String(), `"code":"unprocessable_patch"`)
+		require.Equal(t, http.StatusBadRequest, unknown.Code)
+		require.Contains(t, unknown.Body.String(), `"code":"invalid_patch"`)
+	})
+
+	t.Run("Otro tipo de contenido devuelve 415 con los tipos aceptados.", func(t *testing.T) {
+		// Act
+		res, _ := patch("text/plain", `price=1`)
+
+		// Assert
+		require.Equal(t, http.StatusUnsupportedMediaType, res.Code)
+		require.Equal(t, "application/merge-patch+json, application/json-patch+json", res.Header().Get("Accept-Patch"))
+	})
+}
+
+func TestProductConditionalRequests(t *testing.T) {
+	// do sends the request to the product 1, stored at its second version
+	do := func(method, body string, header http.Header) (*httptest.ResponseRecorder, internal.ProductRepository) {
+		repo := repository.NewRepositoryMap(map[int]internal.Product{
+			1: {ID: 1, Name: "Producto 1", Quantity: 10, CodeValue: "123456", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 100, Version: 2},
+		})
+		productHandler := handler.NewProductHandler(service.NewProductService(repo))
+		handlers := map[string]http.HandlerFunc{
+			"GET":    productHandler.GetProductByID,
+			"PUT":    productHandler.UpdateProduct,
+			"PATCH":  productHandler.ParcialUpdateProduct,
+			"DELETE": productHandler.DeleteProduct,
+		}
+
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/products/1", strings.NewReader(body))
+		req.Header = header
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("id", "1")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		handlers[method](res, req)
+		return res, repo
+	}
+	product := `{"name":"Producto 1","quantity":5,"code_value":"123456","is_published":true,"expiration":"31/12/2021","price":100}`
+
+	t.Run("Un GET devuelve el ETag de la version y 304 si el cliente ya la tiene.", func(t *testing.T) {
+		// Act
+		res, _ := do("GET", "", http.Header{})
+		cached, _ := do("GET", "", http.Header{"If-None-Match": {`"1", W/"2"`}})
+		changed, _ := do("GET", "", http.Header{"If-None-Match": {`"1"`}})
+
+		// Assert
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, `"2"`, res.Header().Get("ETag"))
+		require.Equal(t, http.StatusNotModified, cached.Code)
+		require.Equal(t, `"2"`, cached.Header().Get("ETag"))
+		require.Empty(t, cached.Body.String())
+		require.Equal(t, http.StatusOK, changed.Code)
+	})
+
+	t.Run("Un PUT con el ETag actual cambia la version.", func(t *testing.T) {
+		// Act
+		res, _ := do("PUT", product, http.Header{"If-Match": {`"2"`}})
+
+		// Assert
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, `"3"`, res.Header().Get("ETag"))
+	})
+
+	t.Run("Un PUT con un ETag viejo o debil devuelve 412 y no cambia el producto.", func(t *testing.T) {
+		for _, etag := range []string{`"1"`, `W/"2"`, `"1", "3"`} {
+			// Act
+			res, repo := do("PUT", product, http.Header{"If-Match": {etag}})
+
+			// Assert
+			expectedBody := `{"type":"/problems/precondition_failed","title":"Precondition Failed","status":412,
+								"detail":"The product changed since the version of If-Match","instance":"/products/1","code":"precondition_failed"}`
+			require.Equal(t, http.StatusPreconditionFailed, res.Code, etag)
+			require.JSONEq(t, expectedBody, res.Body.String(), etag)
+			stored, err := repo.GetProductByID(context.Background(), 1)
+			require.NoError(t, err)
+			require.Equal(t, 10, stored.Quantity, etag)
+		}
+	})
+
+	t.Run("Un PATCH respeta If-Match antes de aplicar el patch.", func(t *testing.T) {
+		// Act
+		stale, _ := do("PATCH", `{"quantity":5}`, http.Header{"If-Match": {`"1"`}})
+		current, _ := do("PATCH", `{"quantity":5}`, http.Header{"If-Match": {`"1", "2"`}})
+
+		// Assert
+		require.Equal(t, http.StatusPreconditionFailed, stale.Code)
+		require.Equal(t, http.StatusOK, current.Code)
+		require.Equal(t, `"3"`, current.Header().Get("ETag"))
+	})
+
+	t.Run("Un DELETE solo borra la version de If-Match.", func(t *testing.T) {
+		// Act
+		stale, staleRepo := do("DELETE", "", http.Header{"If-Match": {`"1"`}})
+		current, _ := do("DELETE", "", http.Header{"If-Match": {`"2"`}})
+
+		// Assert
+		require.Equal(t, http.StatusPreconditionFailed, stale.Code)
+		_, err := staleRepo.GetProductByID(context.Background(), 1)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, current.Code)
+	})
+}
+
+func TestDeleteProduct(t *testing.T) {
+	t.Run("Se elimina el producto con dicho id, y no es necesario retornar nada.", func(t *testing.T) {
 		// Arrange
 		data := map[int]internal.Product{
 			1: {
@@ -294,19 +727,17 @@ func TestDeleteProduct(t *testing.T) {
 		handler.DeleteProduct(res, req)
 
 		// Assert
-		expectedCode := http.StatusUnauthorized
-		expectedBody := `{"message":"Unauthorized", "status": 401}`
-		expectedHeader := http.Header{
-			"Content-Type": []string{"application/json"},
-		}
+		expectedCode := http.StatusNoContent
+		expectedBody := ""
+		expectedHeader := http.Header{}
 		require.Equal(t, expectedCode, res.Code)
-		require.JSONEq(t, expectedBody, res.Body.String())
+		require.Equal(t, expectedBody, res.Body.String())
 		require.Equal(t, expectedHeader, res.Header())
 	})
 }
 
 // test using query params
-func TestGetProductsByPriceGreaterThan(t *testing.T) {
+func TestSearchProducts(t *testing.T) {
 	t.Run("Se solicitan los productos cuyo precio sea mayor a 100", func(t *testing.T) {
 		// Arrange
 		data := map[int]internal.Product{
@@ -334,14 +765,14 @@ func TestGetProductsByPriceGreaterThan(t *testing.T) {
 		handler := handler.NewProductHandler(service)
 
 		res := httptest.NewRecorder()
-		req := httptest.NewRequest("DELETE", "/products?priceGt=100", nil)
+		req := httptest.NewRequest("GET", "/products/search?priceGt=100", nil)
 
 		// Act
-		handler.GetProductsByPriceGreaterThan(res, req)
+		handler.SearchProducts(res, req)
 
 		// Assert
 		expectedCode := http.StatusOK
-		expectedBody := `[{"id":2,"name":"Producto 1","quantity":10,"code_value":"123456","is_published":true,"expiration":"31/12/2021","price":110}]`
+		expectedBody := `{"products":[{"id":2,"name":"Producto 1","quantity":10,"code_value":"123456","is_published":true,"expiration":"31/12/2021","price":110}],"total":1,"limit":50,"offset":0}`
 		expectedHeader := http.Header{
 			"Content-Type": []string{"application/json"},
 		}
@@ -349,4 +780,48 @@ func TestGetProductsByPriceGreaterThan(t *testing.T) {
 		require.JSONEq(t, expectedBody, res.Body.String())
 		require.Equal(t, expectedHeader, res.Header())
 	})
+
+	t.Run("Se buscan productos con una expresion de filtro", func(t *testing.T) {
+		// Arrange
+		data := map[int]internal.Product{
+			1: {ID: 1, Name: "Cheese - Brie", Quantity: 10, CodeValue: "C1", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 150},
+			2: {ID: 2, Name: "Cheese - Cheddar", Quantity: 10, CodeValue: "C2", IsPublished: false, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 150},
+			3: {ID: 3, Name: "Bread - Baguette", Quantity: 10, CodeValue: "B1", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 150},
+			4: {ID: 4, Name: "Cheese - Feta", Quantity: 10, CodeValue: "C3", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 50},
+		}
+		repo := repository.NewRepositoryMap(data)
+		service := service.NewProductService(repo)
+		handler := handler.NewProductHandler(service)
+
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/products/search?filter="+url.QueryEscape(`price>100 AND is_published=true AND name~"cheese"`), nil)
+
+		// Act
+		handler.SearchProducts(res, req)
+
+		// Assert
+		expectedCode := http.StatusOK
+		expectedBody := `{"products":[{"id":1,"name":"Cheese - Brie","quantity":10,"code_value":"C1","is_published":true,"expiration":"31/12/2021","price":150}],"total":1,"limit":50,"offset":0}`
+		require.Equal(t, expectedCode, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+	})
+
+	t.Run("Una expresion invalida devuelve la posicion del token erroneo", func(t *testing.T) {
+		// Arrange
+		repo := repository.NewRepositoryMap(map[int]internal.Product{})
+		service := service.NewProductService(repo)
+		handler := handler.NewProductHandler(service)
+
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/products/search?filter="+url.QueryEscape(`price>100 AND color="red"`), nil)
+
+		// Act
+		handler.SearchProducts(res, req)
+
+		// Assert
+		expectedCode := http.StatusBadRequest
+		expectedBody := `{"type":"/problems/invalid_filter","title":"Bad Request","status":400,"detail":"Invalid filter: unknown field","instance":"/products/search","code":"invalid_filter","position":14,"token":"color"}`
+		require.Equal(t, expectedCode, res.Code)
+		require.JSONEq(t, expectedBody, res.Body.String())
+	})
 }
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyRecord is the response to a request sent with an Idempotency-Key
+// header, so a retry of the request gets the same response instead of
+// repeating it
+type IdempotencyRecord struct {
+	// Principal is the ID of the principal of the request, each one has its own keys
+	Principal string
+	// Key is the value of the Idempotency-Key header
+	Key string
+	// RequestHash is the SHA-256 of the request, a key cannot be reused for another request
+	RequestHash string
+	// Done is false while the first request is being served
+	Done   bool
+	Status int
+	// Header holds the headers of the response that are replayed, e.g. Content-Type
+	Header map[string]string
+	Body   []byte
+	// ExpiresAt is when the key can be used for another request
+	ExpiresAt time.Time
+}
+
+// Expired reports whether the record is expired at now
+func (r IdempotencyRecord) Expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
+
+// IdempotencyStore keeps the records of the idempotency keys, an expired
+// record is the same as no record at all
+type IdempotencyStore interface {
+	// Reserve stores the record, not done yet, unless the principal already has
+	// a record for the key. Then reserved is false and the stored one is returned.
+	Reserve(ctx context.Context, record IdempotencyRecord) (stored IdempotencyRecord, reserved bool, err error)
+	// Complete stores the response of a reserved record
+	Complete(ctx context.Context, record IdempotencyRecord) error
+	// Release deletes a reserved record, so the request can be sent again
+	Release(ctx context.Context, principal, key string) error
+}
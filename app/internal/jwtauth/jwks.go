@@ -0,0 +1,136 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Key is a verification key of the set. Alg is the only algorithm the key
+// accepts, so an RSA public key can never be used as an HMAC secret.
+type Key struct {
+	ID  string
+	Alg string
+	// Key is a []byte for HS256, an *rsa.PublicKey for RS256 and an *ecdsa.PublicKey for ES256
+	Key any
+}
+
+// jwk is a JSON Web Key as defined by RFC 7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// oct
+	K string `json:"k"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS reads the keys of a JWKS file, {"keys": [...]}. It supports oct
+// keys for HS256, RSA keys for RS256 and P-256 EC keys for ES256.
+func LoadJWKS(path string) ([]Key, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading the jwks file: %w", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("decoding the jwks file %s: %w", path, err)
+	}
+	if len(set.Keys) == 0 {
+		return nil, fmt.Errorf("the jwks file %s has no keys", path)
+	}
+
+	keys := make([]Key, 0, len(set.Keys))
+	for i, raw := range set.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(raw)
+		if err != nil {
+			return nil, fmt.Errorf("jwks file %s, key %d (kid %q): %w", path, i, raw.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func parseJWK(raw jwk) (Key, error) {
+
+	decode := func(field, value string) ([]byte, error) {
+		if value == "" {
+			return nil, fmt.Errorf("missing %q", field)
+		}
+		b, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not base64url: %w", field, err)
+		}
+		return b, nil
+	}
+
+	var key Key
+	switch raw.Kty {
+	case "oct":
+		secret, err := decode("k", raw.K)
+		if err != nil {
+			return Key{}, err
+		}
+		key = Key{Alg: "HS256", Key: secret}
+	case "RSA":
+		n, err := decode("n", raw.N)
+		if err != nil {
+			return Key{}, err
+		}
+		e, err := decode("e", raw.E)
+		if err != nil {
+			return Key{}, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return Key{}, fmt.Errorf("invalid RSA exponent")
+		}
+		key = Key{Alg: "RS256", Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}}
+	case "EC":
+		if raw.Crv != "P-256" {
+			return Key{}, fmt.Errorf("unsupported curve %q, only P-256 is supported", raw.Crv)
+		}
+		x, err := decode("x", raw.X)
+		if err != nil {
+			return Key{}, err
+		}
+		y, err := decode("y", raw.Y)
+		if err != nil {
+			return Key{}, err
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return Key{}, fmt.Errorf("the point is not on the P-256 curve")
+		}
+		key = Key{Alg: "ES256", Key: public}
+	default:
+		return Key{}, fmt.Errorf("unsupported key type %q", raw.Kty)
+	}
+
+	if raw.Alg != "" && raw.Alg != key.Alg {
+		return Key{}, fmt.Errorf("a %s key cannot be used with %s", raw.Kty, raw.Alg)
+	}
+	key.ID = raw.Kid
+
+	return key, nil
+}
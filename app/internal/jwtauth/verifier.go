@@ -0,0 +1,132 @@
+// Package jwtauth verifies the JWT bearer tokens issued by the other services
+// against the keys of a local JWKS file.
+package jwtauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goweb/app/internal"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type Config struct {
+	Keys []Key
+	// Issuer and Audience must match the iss and aud claims
+	Issuer   string
+	Audience string
+	// RolesClaim is the claim with the roles, a dotted path such as
+	// "realm_access.roles" reaches into nested objects
+	RolesClaim string
+	// Leeway tolerates that much clock skew when checking exp and nbf
+	Leeway time.Duration
+}
+
+// implements internal.TokenVerifier
+type Verifier struct {
+	config Config
+	parser *jwt.Parser
+}
+
+func NewVerifier(config Config) *Verifier {
+	return &Verifier{
+		config: config,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}),
+			jwt.WithIssuer(config.Issuer),
+			jwt.WithAudience(config.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(config.Leeway),
+		),
+	}
+}
+
+// VerifyToken checks the signature, exp, nbf, iss and aud of the token. The
+// principal is identified by the sub claim and gets the roles of RolesClaim.
+func (v *Verifier) VerifyToken(ctx context.Context, token string) (internal.Principal, error) {
+
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, v.key)
+	if err != nil {
+		return internal.Principal{}, fmt.Errorf("%w: %w", internal.ErrTokenInvalid, err)
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return internal.Principal{}, fmt.Errorf("%w: missing sub claim", internal.ErrTokenInvalid)
+	}
+	roles, err := v.roles(claims)
+	if err != nil {
+		return internal.Principal{}, fmt.Errorf("%w: %w", internal.ErrTokenInvalid, err)
+	}
+
+	name := subject
+	if n, ok := claims["name"].(string); ok && n != "" {
+		name = n
+	}
+
+	return internal.Principal{
+		ID:    "jwt:" + subject,
+		Name:  name,
+		Roles: roles,
+	}, nil
+}
+
+// key finds the key of the token by its kid, or the only key for its
+// algorithm when the token has no kid
+func (v *Verifier) key(token *jwt.Token) (any, error) {
+
+	alg := token.Method.Alg()
+	kid, _ := token.Header["kid"].(string)
+
+	var found *Key
+	for i, key := range v.config.Keys {
+		if key.Alg != alg || (kid != "" && key.ID != kid) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("several %s keys match, the token needs a kid", alg)
+		}
+		found = &v.config.Keys[i]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no %s key with kid %q", alg, kid)
+	}
+
+	return found.Key, nil
+}
+
+// roles reads RolesClaim, either an array of strings or a space separated string
+func (v *Verifier) roles(claims jwt.MapClaims) ([]string, error) {
+
+	var value any = map[string]any(claims)
+	for _, part := range strings.Split(v.config.RolesClaim, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, nil
+		}
+		value = object[part]
+	}
+
+	switch roles := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.Fields(roles), nil
+	case []any:
+		result := make([]string, 0, len(roles))
+		for _, role := range roles {
+			s, ok := role.(string)
+			if !ok {
+				return nil, errors.New("the roles claim must hold strings")
+			}
+			result = append(result, s)
+		}
+		return result, nil
+	}
+
+	return nil, errors.New("the roles claim must be an array or a string")
+}
@@ -0,0 +1,183 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"goweb/app/internal"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeys are the signing keys behind the JWKS written by writeJWKS
+type testKeys struct {
+	secret []byte
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return testKeys{secret: []byte("0123456789abcdef0123456789abcdef"), rsa: rsaKey, ec: ecKey}
+}
+
+func writeJWKS(t *testing.T, keys []map[string]string) string {
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	return path
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (k testKeys) jwks() []map[string]string {
+	return []map[string]string{
+		{"kty": "oct", "kid": "hs", "alg": "HS256", "k": b64(k.secret)},
+		{"kty": "RSA", "kid": "rs", "alg": "RS256", "n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": b64(k.ec.X.FillBytes(make([]byte, 32))), "y": b64(k.ec.Y.FillBytes(make([]byte, 32)))},
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestVerifier(t *testing.T) {
+
+	keys := newTestKeys(t)
+	jwks, err := LoadJWKS(writeJWKS(t, keys.jwks()))
+	require.NoError(t, err)
+	verifier := NewVerifier(Config{
+		Keys:       jwks,
+		Issuer:     "https://auth.example.com",
+		Audience:   "products-api",
+		RolesClaim: "roles",
+		Leeway:     time.Second,
+	})
+
+	now := time.Now()
+	claims := func(changes jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub":   "user-1",
+			"name":  "Ana",
+			"iss":   "https://auth.example.com",
+			"aud":   "products-api",
+			"exp":   now.Add(time.Hour).Unix(),
+			"roles": []string{"viewer"},
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	t.Run("Se aceptan los tokens firmados con HS256, RS256 y ES256", func(t *testing.T) {
+		tokens := map[string]string{
+			"HS256": sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims(nil)),
+			"RS256": sign(t, jwt.SigningMethodRS256, "rs", keys.rsa, claims(nil)),
+			"ES256": sign(t, jwt.SigningMethodES256, "es", keys.ec, claims(nil)),
+			// without kid the only key of the algorithm is used
+			"sin kid": sign(t, jwt.SigningMethodES256, "", keys.ec, claims(nil)),
+		}
+		for name, token := range tokens {
+			// Act
+			principal, err := verifier.VerifyToken(context.Background(), token)
+
+			// Assert
+			require.NoError(t, err, name)
+			require.Equal(t, internal.Principal{ID: "jwt:user-1", Name: "Ana", Roles: []string{"viewer"}}, principal, name)
+		}
+	})
+
+	t.Run("Se rechazan los tokens invalidos", func(t *testing.T) {
+		otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		tokens := map[string]string{
+			"vencido":        sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()})),
+			"sin exp":        sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims(jwt.MapClaims{"exp": nil})),
+			"nbf futuro":     sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims(jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()})),
+			"otra audiencia": sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims(jwt.MapClaims{"aud": "billing-api"})),
+			"otro emisor":    sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims(jwt.MapClaims{"iss": "https://evil.example.com"})),
+			"sin sub":        sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims(jwt.MapClaims{"sub": nil})),
+			"kid ajeno":      sign(t, jwt.SigningMethodHS256, "unknown", keys.secret, claims(nil)),
+			"otra firma":     sign(t, jwt.SigningMethodRS256, "rs", otherRSA, claims(nil)),
+			// the RSA public key used as an HMAC secret must not verify
+			"algoritmo cambiado": sign(t, jwt.SigningMethodHS256, "rs", keys.rsa.PublicKey.N.Bytes(), claims(nil)),
+			"sin firma":          sign(t, jwt.SigningMethodNone, "hs", jwt.UnsafeAllowNoneSignatureType, claims(nil)),
+			"roles invalidos":    sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims(jwt.MapClaims{"roles": 3})),
+		}
+		for name, token := range tokens {
+			// Act
+			_, err := verifier.VerifyToken(context.Background(), token)
+
+			// Assert
+			require.ErrorIs(t, err, internal.ErrTokenInvalid, name)
+		}
+	})
+
+	t.Run("Los roles se leen de un claim anidado o de un string", func(t *testing.T) {
+		// Arrange
+		nested := NewVerifier(Config{Keys: jwks, Issuer: "https://auth.example.com", Audience: "products-api", RolesClaim: "realm_access.roles"})
+		token := sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims(jwt.MapClaims{"realm_access": map[string]any{"roles": "editor admin"}}))
+
+		// Act
+		principal, err := nested.VerifyToken(context.Background(), token)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []string{"editor", "admin"}, principal.Roles)
+	})
+}
+
+func TestLoadJWKS(t *testing.T) {
+	t.Run("Se rechaza un tipo de clave no soportado", func(t *testing.T) {
+		// Arrange
+		path := writeJWKS(t, []map[string]string{{"kty": "OKP", "kid": "ed"}})
+
+		// Act
+		_, err := LoadJWKS(path)
+
+		// Assert
+		require.ErrorContains(t, err, `unsupported key type "OKP"`)
+	})
+
+	t.Run("Se rechaza un algoritmo que no corresponde a la clave", func(t *testing.T) {
+		// Arrange
+		keys := newTestKeys(t).jwks()
+		keys[1]["alg"] = "HS256"
+		path := writeJWKS(t, keys)
+
+		// Act
+		_, err := LoadJWKS(path)
+
+		// Assert
+		require.ErrorContains(t, err, "a RSA key cannot be used with HS256")
+	})
+}
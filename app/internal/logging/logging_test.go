@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"goweb/app/internal"
+	"goweb/app/internal/config"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("Los logs llevan el request_id del contexto", func(t *testing.T) {
+		// Arrange
+		var buf bytes.Buffer
+		logger, err := New(&buf, config.LogConfig{Level: "info", Format: config.LogFormatJSON})
+		require.NoError(t, err)
+		ctx := internal.ContextWithRequestID(context.Background(), "abc")
+
+		// Act
+		logger.DebugContext(ctx, "hidden")
+		logger.With("component", "test").InfoContext(ctx, "shown", "n", 1)
+		logger.Info("no request")
+
+		// Assert
+		lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+		require.Len(t, lines, 2)
+		require.Contains(t, string(lines[0]), `"msg":"shown","component":"test","n":1,"request_id":"abc"`)
+		require.NotContains(t, string(lines[1]), "request_id")
+	})
+
+	t.Run("Los logs llevan el trace_id y el span_id del span en curso", func(t *testing.T) {
+		// Arrange
+		var buf bytes.Buffer
+		logger, err := New(&buf, config.LogConfig{Level: "info", Format: config.LogFormatText})
+		require.NoError(t, err)
+		traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+		spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+
+		// Act
+		logger.InfoContext(ctx, "traced")
+
+		// Assert
+		require.Contains(t, buf.String(), "trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id=00f067aa0ba902b7")
+	})
+
+	t.Run("Se rechazan niveles y formatos desconocidos", func(t *testing.T) {
+		// Act
+		_, errLevel := New(&bytes.Buffer{}, config.LogConfig{Level: "verbose", Format: config.LogFormatText})
+		_, errFormat := New(&bytes.Buffer{}, config.LogConfig{Level: "warn", Format: "xml"})
+
+		// Assert
+		require.ErrorContains(t, errLevel, `log level "verbose"`)
+		require.EqualError(t, errFormat, `unknown log format "xml", use json or text`)
+	})
+}
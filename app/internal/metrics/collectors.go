@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"goweb/app/internal"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolStater is implemented by the repositories on a sql.DB, like ProductRepositorySQL
+type PoolStater interface {
+	Stats() sql.DBStats
+}
+
+// NewPoolCollector reports the connection pool of db, labeled with backend
+func NewPoolCollector(db PoolStater, backend string) prometheus.Collector {
+
+	labels := prometheus.Labels{"backend": backend}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("db_pool_"+name, help, nil, labels)
+	}
+
+	return &poolCollector{
+		db:                db,
+		maxOpen:           desc("max_open_connections", "Maximum number of open connections to the database."),
+		open:              desc("open_connections", "Established connections, in use and idle."),
+		inUse:             desc("in_use_connections", "Connections in use."),
+		idle:              desc("idle_connections", "Idle connections."),
+		waitCount:         desc("wait_count_total", "Connections waited for."),
+		waitDuration:      desc("wait_duration_seconds_total", "Time blocked waiting for a connection."),
+		maxIdleClosed:     desc("max_idle_closed_total", "Connections closed due to max_idle_conns."),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "Connections closed due to conn_max_lifetime."),
+	}
+}
+
+type poolCollector struct {
+	db                               PoolStater
+	maxOpen, open, inUse, idle       *prometheus.Desc
+	waitCount, waitDuration          *prometheus.Desc
+	maxIdleClosed, maxLifetimeClosed *prometheus.Desc
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
+
+// catalogTimeout bounds the read of the catalog on each scrape
+const catalogTimeout = 5 * time.Second
+
+// NewCatalogCollector reports the state of the catalog of repo, read on each
+// scrape: the products, the published ones, the units in stock and the
+// products past their expiration
+func NewCatalogCollector(repo internal.ProductRepository) prometheus.Collector {
+	return &catalogCollector{
+		repo:      repo,
+		now:       time.Now,
+		products:  prometheus.NewDesc("products_total", "Products in the catalog.", nil, nil),
+		published: prometheus.NewDesc("products_published", "Products with is_published=true.", nil, nil),
+		stock:     prometheus.NewDesc("products_stock_units", "Sum of the quantity of every product.", nil, nil),
+		expired:   prometheus.NewDesc("products_expired", "Products past their expiration.", nil, nil),
+	}
+}
+
+type catalogCollector struct {
+	repo                                internal.ProductRepository
+	now                                 func() time.Time
+	products, published, stock, expired *prometheus.Desc
+}
+
+func (c *catalogCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *catalogCollector) Collect(ch chan<- prometheus.Metric) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), catalogTimeout)
+	defer cancel()
+
+	products, err := c.repo.GetAllProducts(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.products, err)
+		return
+	}
+
+	now := c.now()
+	var published, stock, expired int
+	for _, product := range products {
+		if product.IsPublished {
+			published++
+		}
+		stock += product.Quantity
+		if product.Expiration.Before(now) {
+			expired++
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.products, prometheus.GaugeValue, float64(len(products)))
+	ch <- prometheus.MustNewConstMetric(c.published, prometheus.GaugeValue, float64(published))
+	ch <- prometheus.MustNewConstMetric(c.stock, prometheus.GaugeValue, float64(stock))
+	ch <- prometheus.MustNewConstMetric(c.expired, prometheus.GaugeValue, float64(expired))
+}
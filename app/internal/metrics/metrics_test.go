@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"goweb/app/internal"
+	"goweb/app/internal/repository"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// scrape returns the metrics served by m in the text format
+func scrape(t *testing.T, m *Metrics) string {
+	res := httptest.NewRecorder()
+	m.Handler().ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("Se exportan el estado del catalogo y del pool", func(t *testing.T) {
+		// Arrange
+		m := New()
+		db, err := repository.NewSQLiteConnection(":memory:")
+		require.NoError(t, err)
+		defer db.Close()
+		repo, err := repository.NewProductRepositorySQLite(db)
+		require.NoError(t, err)
+		for _, product := range []internal.Product{
+			{Name: "Wine", Quantity: 10, CodeValue: "W1", IsPublished: true, Expiration: time.Now().AddDate(0, 0, -1), Price: 100},
+			{Name: "Bread", Quantity: 5, CodeValue: "B1", IsPublished: false, Expiration: time.Now().AddDate(1, 0, 0), Price: 20},
+		} {
+			_, err := repo.AddProduct(context.Background(), product)
+			require.NoError(t, err)
+		}
+		require.NoError(t, m.Register(NewCatalogCollector(repo), NewPoolCollector(repo, "sqlite")))
+
+		// Act
+		body := scrape(t, m)
+
+		// Assert
+		require.Contains(t, body, "products_total 2\n")
+		require.Contains(t, body, "products_published 1\n")
+		require.Contains(t, body, "products_stock_units 15\n")
+		require.Contains(t, body, "products_expired 1\n")
+		require.Contains(t, body, `db_pool_max_open_connections{backend="sqlite"} 1`)
+		require.Contains(t, body, `db_pool_open_connections{backend="sqlite"} 1`)
+	})
+
+	t.Run("Se mide cada operacion del repositorio", func(t *testing.T) {
+		// Arrange
+		m := New()
+		repo := m.InstrumentRepository(repository.NewRepositoryMap(map[int]internal.Product{}), "map")
+
+		// Act
+		_, err := repo.GetProductByID(context.Background(), 1)
+		_, _ = repo.SearchProducts(context.Background(), internal.ProductQuery{})
+
+		// Assert
+		require.True(t, errors.Is(err, internal.ErrProductNotFound))
+		body := scrape(t, m)
+		require.Contains(t, body, `repository_operation_duration_seconds_count{backend="map",operation="get_by_id",result="error"} 1`)
+		require.Contains(t, body, `repository_operation_duration_seconds_count{backend="map",operation="search",result="ok"} 1`)
+	})
+
+	t.Run("El repositorio medido sigue informando su salud", func(t *testing.T) {
+		// Arrange
+		m := New()
+		file := repository.NewRepositoryFile(t.TempDir()+"/products.json", repository.FsyncNever)
+
+		// Act
+		withHealth := m.InstrumentRepository(file, "file")
+		withoutHealth := m.InstrumentRepository(repository.NewRepositoryMap(map[int]internal.Product{}), "map")
+
+		// Assert
+		checker, ok := withHealth.(internal.HealthChecker)
+		require.True(t, ok)
+		require.NoError(t, checker.HealthCheck(context.Background()))
+		_, ok = withoutHealth.(internal.HealthChecker)
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"context"
+	"goweb/app/internal"
+	"time"
+)
+
+// InstrumentRepository wraps repo, recording the latency of each operation
+// labeled with backend. The wrapper implements internal.HealthChecker only
+// when repo does, so the readiness probe keeps checking the same backends.
+func (m *Metrics) InstrumentRepository(repo internal.ProductRepository, backend string) internal.ProductRepository {
+
+	instrumented := &instrumentedRepository{repo: repo, backend: backend, metrics: m}
+	if checker, ok := repo.(internal.HealthChecker); ok {
+		return &instrumentedHealthRepository{instrumentedRepository: instrumented, checker: checker}
+	}
+	return instrumented
+}
+
+// instrumentedRepository implements the ProductRepository interface on top of another one
+type instrumentedRepository struct {
+	repo    internal.ProductRepository
+	backend string
+	metrics *Metrics
+}
+
+func (r *instrumentedRepository) GetAllProducts(ctx context.Context) (products []internal.Product, err error) {
+	defer func(start time.Time) { r.metrics.observeOperation(r.backend, "get_all", start, err) }(time.Now())
+	return r.repo.GetAllProducts(ctx)
+}
+
+func (r *instrumentedRepository) SearchProducts(ctx context.Context, query internal.ProductQuery) (page internal.ProductPage, err error) {
+	defer func(start time.Time) { r.metrics.observeOperation(r.backend, "search", start, err) }(time.Now())
+	return r.repo.SearchProducts(ctx, query)
+}
+
+func (r *instrumentedRepository) GetProductByID(ctx context.Context, id int) (product internal.Product, err error) {
+	defer func(start time.Time) { r.metrics.observeOperation(r.backend, "get_by_id", start, err) }(time.Now())
+	return r.repo.GetProductByID(ctx, id)
+}
+
+func (r *instrumentedRepository) AddProduct(ctx context.Context, product internal.Product) (added internal.Product, err error) {
+	defer func(start time.Time) { r.metrics.observeOperation(r.backend, "add", start, err) }(time.Now())
+	return r.repo.AddProduct(ctx, product)
+}
+
+func (r *instrumentedRepository) UpdateProduct(ctx context.Context, product internal.Product) (updated internal.Product, err error) {
+	defer func(start time.Time) { r.metrics.observeOperation(r.backend, "update", start, err) }(time.Now())
+	return r.repo.UpdateProduct(ctx, product)
+}
+
+func (r *instrumentedRepository) DeleteProduct(ctx context.Context, id, version int) (err error) {
+	defer func(start time.Time) { r.metrics.observeOperation(r.backend, "delete", start, err) }(time.Now())
+	return r.repo.DeleteProduct(ctx, id, version)
+}
+
+// instrumentedHealthRepository forwards the health checks too
+type instrumentedHealthRepository struct {
+	*instrumentedRepository
+	checker internal.HealthChecker
+}
+
+func (r *instrumentedHealthRepository) HealthCheck(ctx context.Context) error {
+	return r.checker.HealthCheck(ctx)
+}
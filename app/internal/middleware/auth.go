@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"goweb/app/internal"
+	appHandler "goweb/app/internal/handler"
+	"goweb/app/internal/rbac"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// the schemes of the Authorization header
+const (
+	apiKeyScheme = "ApiKey"
+	bearerScheme = "Bearer"
+)
+
+// Authenticate resolves the credentials of the request and stores their
+// principal in the request context. An API key is sent as "Authorization:
+// ApiKey <key>" or in the X-API-Key header, and a JWT as "Authorization: Bearer
+// <jwt>". tokens is nil when bearer tokens are not accepted. Requests without
+// valid credentials get a 401. It is Identify followed by RequireAuthentication,
+// which run apart when a middleware must see the unauthenticated requests.
+func Authenticate(keys internal.APIKeyService, tokens internal.TokenVerifier) func(http.Handler) http.Handler {
+
+	identify := Identify(keys, tokens)
+	return func(handler http.Handler) http.Handler {
+		return identify(RequireAuthentication(handler))
+	}
+}
+
+// authFailure is why the credentials of a request were rejected, kept in the
+// context by Identify until RequireAuthentication answers it
+type authFailure struct {
+	problem   appHandler.Problem
+	challenge string
+}
+
+type authFailureKey struct{}
+
+// Identify resolves the credentials of the request, as Authenticate does, but
+// lets the requests without valid credentials go on without a principal.
+// RequireAuthentication answers them later.
+func Identify(keys internal.APIKeyService, tokens internal.TokenVerifier) func(http.Handler) http.Handler {
+
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			var principal internal.Principal
+			var err error
+			if token, ok := bearerToken(r); ok && tokens != nil {
+				principal, err = tokens.VerifyToken(r.Context(), token)
+			} else {
+				principal, err = keys.Authenticate(r.Context(), apiKeyFromRequest(r))
+			}
+
+			if err != nil {
+				switch {
+				case errors.Is(err, internal.ErrAPIKeyInvalid), errors.Is(err, internal.ErrAPIKeyExpired), errors.Is(err, internal.ErrTokenInvalid):
+					failure := authFailure{
+						problem:   appHandler.NewProblem(http.StatusUnauthorized, appHandler.CodeUnauthorized, "Unauthorized"),
+						challenge: apiKeyScheme,
+					}
+					if errors.Is(err, internal.ErrAPIKeyExpired) {
+						failure.problem = appHandler.NewProblem(http.StatusUnauthorized, appHandler.CodeAPIKeyExpired, "API key expired")
+					}
+					if tokens != nil {
+						failure.challenge += ", " + bearerScheme
+					}
+					handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authFailureKey{}, failure)))
+				default:
+					slog.ErrorContext(r.Context(), "authenticating the request", "error", err)
+					appHandler.WriteProblem(w, r, appHandler.NewProblem(http.StatusInternalServerError, appHandler.CodeInternal, "Internal server error"))
+				}
+				return
+			}
+
+			// call the handler with the principal in the context
+			handler.ServeHTTP(w, r.WithContext(internal.ContextWithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// RequireAuthentication answers 401 to the requests Identify found no principal for
+func RequireAuthentication(handler http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if _, ok := internal.PrincipalFromContext(r.Context()); ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		failure, ok := r.Context().Value(authFailureKey{}).(authFailure)
+		if !ok {
+			failure = authFailure{problem: appHandler.NewProblem(http.StatusUnauthorized, appHandler.CodeUnauthorized, "Unauthorized"), challenge: apiKeyScheme}
+		}
+		w.Header().Set("WWW-Authenticate", failure.challenge)
+		appHandler.WriteProblem(w, r, failure.problem)
+	})
+}
+
+// Require answers 403 unless the policy grants the principal of the request
+// at least one of the permissions. It must run after Authenticate.
+func Require(policy *rbac.Policy, permissions ...string) func(http.Handler) http.Handler {
+
+	// the message lists what would have been accepted
+	message := "Forbidden: " + rbac.Requirement(permissions...)
+
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			principal, ok := internal.PrincipalFromContext(r.Context())
+			if !ok {
+				appHandler.WriteProblem(w, r, appHandler.NewProblem(http.StatusUnauthorized, appHandler.CodeUnauthorized, "Unauthorized"))
+				return
+			}
+
+			if !policy.AllowsAny(principal, permissions...) {
+				appHandler.WriteProblem(w, r, appHandler.NewProblem(http.StatusForbidden, appHandler.CodeForbidden, message))
+				return
+			}
+
+			// call the handler
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bearerToken returns the token of an "Authorization: Bearer <jwt>" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, bearerScheme) {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// apiKeyFromRequest returns the key of the request, or "" if it has none
+func apiKeyFromRequest(r *http.Request) string {
+
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, apiKeyScheme) {
+		return strings.TrimSpace(key)
+	}
+
+	return ""
+}
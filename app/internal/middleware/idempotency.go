@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"goweb/app/internal"
+	appHandler "goweb/app/internal/handler"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the headers of the idempotent requests
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	anonymousIdempotencyOwner = "anonymous"
+)
+
+// replayedHeaders are the headers of the response stored with it, the rest are
+// set again by the middleware of the replay
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// IdempotencyKeys is where the responses to the requests with an
+// Idempotency-Key header are kept and for how long
+type IdempotencyKeys struct {
+	Store internal.IdempotencyStore
+	// TTL is how long a key answers with its response, then it can be used again
+	TTL time.Duration
+	// Lease is how long a key stays reserved while its first request is served,
+	// so a server that dies in the middle does not leave it taken for the TTL.
+	// It must outlast the longest request.
+	Lease time.Duration
+}
+
+// Idempotent makes a request with an Idempotency-Key header safe to retry.
+// The first request with a key is served and its response stored, the
+// retries get that response again, with an Idempotent-Replayed header. A key
+// belongs to the principal of the request and to its method, path and body:
+// sending it with another body answers 422, and while the first request is
+// being served the retries answer 409. A response with a 5xx status or a
+// handler that panics is not stored, so the request can be retried. Requests without the header are
+// served as usual and if the store fails the request goes through, as the
+// rate limits do.
+func Idempotent(keys IdempotencyKeys) func(http.Handler) http.Handler {
+
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			key, ok := r.Header[idempotencyKeyHeader]
+			if !ok {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			if len(key) != 1 || key[0] == "" || len(key[0]) > maxIdempotencyKeyLength {
+				detail := "The " + idempotencyKeyHeader + " header must be a single value of 1 to " + strconv.Itoa(maxIdempotencyKeyLength) + " characters"
+				appHandler.WriteProblem(w, r, appHandler.NewProblem(http.StatusBadRequest, appHandler.CodeInvalidIdempotency, detail))
+				return
+			}
+
+			// the body is hashed and handed to the handler again
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				appHandler.WriteProblem(w, r, appHandler.NewProblem(http.StatusBadRequest, appHandler.CodeInvalidBody, "The body could not be read"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			record := internal.IdempotencyRecord{
+				Principal:   idempotencyOwner(r),
+				Key:         key[0],
+				RequestHash: requestHash(r, body),
+				ExpiresAt:   time.Now().Add(keys.Lease),
+			}
+			stored, reserved, err := keys.Store.Reserve(r.Context(), record)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "reserving the idempotency key, letting the request through", "error", err)
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			switch {
+			case reserved:
+			case stored.RequestHash != record.RequestHash:
+				detail := "The idempotency key was used for another request"
+				appHandler.WriteProblem(w, r, appHandler.NewProblem(http.StatusUnprocessableEntity, appHandler.CodeIdempotencyReused, detail))
+				return
+			case !stored.Done:
+				detail := "The first request with the idempotency key is still being served"
+				appHandler.WriteProblem(w, r, appHandler.NewProblem(http.StatusConflict, appHandler.CodeIdempotencyInUse, detail))
+				return
+			default:
+				replay(w, stored)
+				return
+			}
+
+			// the response is stored even if the client went away, so its retry gets it
+			ctx := context.WithoutCancel(r.Context())
+
+			// a handler that panics releases the key before the panic goes on
+			defer func() {
+				if p := recover(); p != nil {
+					release(ctx, keys.Store, record)
+					panic(p)
+				}
+			}()
+
+			// call the handler, recording its response
+			recorder := &bodyRecorder{responseRecorder: responseRecorder{ResponseWriter: w, status: http.StatusOK}}
+			handler.ServeHTTP(recorder, r)
+
+			if recorder.status >= http.StatusInternalServerError {
+				release(ctx, keys.Store, record)
+				return
+			}
+
+			record.Status = recorder.status
+			record.ExpiresAt = time.Now().Add(keys.TTL)
+			record.Body = recorder.body.Bytes()
+			record.Header = make(map[string]string, len(replayedHeaders))
+			for _, name := range replayedHeaders {
+				if value := w.Header().Get(name); value != "" {
+					record.Header[name] = value
+				}
+			}
+			if err := keys.Store.Complete(ctx, record); err != nil {
+				slog.ErrorContext(ctx, "storing the response of the idempotency key", "error", err)
+				// a key without its response would answer 409 until its lease ends
+				release(ctx, keys.Store, record)
+			}
+		})
+	}
+}
+
+// release deletes the reservation of a key whose response is not stored, so
+// the request can be sent again
+func release(ctx context.Context, store internal.IdempotencyStore, record internal.IdempotencyRecord) {
+
+	if err := store.Release(ctx, record.Principal, record.Key); err != nil {
+		slog.ErrorContext(ctx, "releasing the idempotency key", "error", err)
+	}
+}
+
+// replay writes the stored response again
+func replay(w http.ResponseWriter, record internal.IdempotencyRecord) {
+
+	for name, value := range record.Header {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+// idempotencyOwner returns the principal the keys of the request belong to,
+// the requests without one share the keys
+func idempotencyOwner(r *http.Request) string {
+
+	if principal, ok := internal.PrincipalFromContext(r.Context()); ok {
+		return principal.ID
+	}
+	return anonymousIdempotencyOwner
+}
+
+// requestHash is the hex SHA-256 of the method, the path and the body of the request
+func requestHash(r *http.Request, body []byte) string {
+
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// bodyRecorder is a responseRecorder that keeps the body too
+type bodyRecorder struct {
+	responseRecorder
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(data []byte) (int, error) {
+	n, err := r.responseRecorder.Write(data)
+	r.body.Write(data[:n])
+	return n, err
+}
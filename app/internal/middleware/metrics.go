@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"goweb/app/internal/metrics"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// unmatchedRoute labels the requests that matched no route, so a scan of
+// random paths cannot create a metric per path
+const unmatchedRoute = "unmatched"
+
+// Metrics records each request in m, labeled with the chi route pattern it
+// matched, e.g. "/products/{id}", rather than with its path
+func Metrics(m *metrics.Metrics) func(http.Handler) http.Handler {
+
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			start := time.Now()
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+			// call the handler
+			handler.ServeHTTP(recorder, r)
+
+			// the routing filled the pattern in the context chi shares with the handlers
+			route := unmatchedRoute
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			m.ObserveRequest(route, r.Method, recorder.status, time.Since(start))
+		})
+	}
+}
@@ -1,45 +1,114 @@
 package middleware
 
 import (
-	"fmt"
-	appHandler "goweb/app/internal/handler"
+	"crypto/rand"
+	"encoding/hex"
+	"goweb/app/internal"
+	"log/slog"
 	"net/http"
 	"time"
-
-	"github.com/bootcamp-go/web/response"
 )
 
-func Auth(handler http.Handler) http.Handler {
+// RequestIDHeader carries the id of the request, the one of the client if it
+// sends a valid one or a new one otherwise
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength keeps the ids of the clients from flooding the logs
+const maxRequestIDLength = 128
+
+// RequestID stores the id of the request in its context and in the
+// X-Request-ID header of the response
+func RequestID(handler http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "1234" {
-			response.JSON(w, http.StatusUnauthorized, appHandler.ErrorResponse{
-				Message: "Unauthorized",
-				Status:  http.StatusUnauthorized,
-			})
-			return
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
 		}
+		w.Header().Set(RequestIDHeader, id)
 
-		// call the handler
-		handler.ServeHTTP(w, r)
+		// call the handler with the id in the context
+		handler.ServeHTTP(w, r.WithContext(internal.ContextWithRequestID(r.Context(), id)))
 	})
 }
 
-func Logs(handler http.Handler) http.Handler {
+// validRequestID accepts the ids of up to maxRequestIDLength printable ASCII characters
+func validRequestID(id string) bool {
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
 
-		method := r.Method
-		path := r.URL.Path
-		timeReq := time.Now()
-		tamano := r.ContentLength
+// newRequestID returns 16 random bytes in hex
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
 
-		// call the handler
-		handler.ServeHTTP(w, r)
+// Logs writes an access log of each request with its status and the size of
+// its response. The server errors are logged as errors. It must run after
+// RequestID for the logs to carry the request_id.
+func Logs(logger *slog.Logger) func(http.Handler) http.Handler {
 
-		totalTime := time.Since(timeReq).Seconds()
-		fmt.Printf("Method: %s - Path: %s - Time: %s - Tamaño: %db, Total time execution: %fs\n", method, path, timeReq.Format("02/01/2006 15:04:05"), tamano, totalTime)
-	})
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			start := time.Now()
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+			// call the handler
+			handler.ServeHTTP(recorder, r)
+
+			level := slog.LevelInfo
+			if recorder.status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logger.LogAttrs(r.Context(), level, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", recorder.status),
+				slog.Int64("request_bytes", r.ContentLength),
+				slog.Int64("response_bytes", recorder.bytes),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+		})
+	}
+}
+
+// responseRecorder remembers the status and the size of the response it writes
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the flusher and the deadlines of the connection
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
 }
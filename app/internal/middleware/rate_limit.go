@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"goweb/app/internal"
+	appHandler "goweb/app/internal/handler"
+	"goweb/app/internal/ratelimit"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimits are the quotas of the clients. Every route shares the Default
+// bucket of the client but the ones in Routes, which get a bucket of their own.
+type RateLimits struct {
+	Store ratelimit.Store
+	// IP is the bucket of each IP, taken before the credentials are checked.
+	// The clients behind the same IP share it, so it is looser than Default.
+	IP      ratelimit.Limit
+	Default ratelimit.Limit
+	// Routes are keyed by "METHOD /pattern", or by "/pattern" for every
+	// method, e.g. "GET /products/consumer_price"
+	Routes map[string]ratelimit.Limit
+	// Route returns the pattern of the route serving the request, e.g. "/products/{id}"
+	Route func(r *http.Request) string
+}
+
+// limit returns the limit of the request and the name of its bucket
+func (l RateLimits) limit(r *http.Request) (ratelimit.Limit, string) {
+
+	if len(l.Routes) > 0 && l.Route != nil {
+		pattern := l.Route(r)
+		for _, route := range []string{r.Method + " " + pattern, pattern} {
+			if limit, ok := l.Routes[route]; ok {
+				return limit, route
+			}
+		}
+	}
+
+	return l.Default, "default"
+}
+
+// RateLimitIP takes a token from the IP bucket of the client for each request
+// and answers 429 when it is empty. It goes before Identify, so a flood of
+// requests with bad credentials is throttled before they reach the API keys or
+// the token verifier.
+func RateLimitIP(limits RateLimits) func(http.Handler) http.Handler {
+
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if limits.IP.Enabled() && !take(w, r, limits.Store, limits.IP, "ip|"+clientIP(r)) {
+				return
+			}
+
+			// call the handler
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimit takes a token from the bucket of the principal Identify found for
+// each request and answers 429 when it is empty. The requests without a
+// principal go through, RateLimitIP limited them and RequireAuthentication
+// rejects them.
+func RateLimit(limits RateLimits) func(http.Handler) http.Handler {
+
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			principal, ok := internal.PrincipalFromContext(r.Context())
+			if !ok {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			limit, bucket := limits.limit(r)
+			if limit.Enabled() && !take(w, r, limits.Store, limit, bucket+"|"+principal.ID) {
+				return
+			}
+
+			// call the handler
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
+
+// take takes a token from the bucket and answers 429 when it is empty,
+// reporting whether the request can go on. The responses carry the state of
+// the bucket in the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, the last bucket taken from sets them. If the store fails the
+// request goes through, an outage of a shared store must not take the API down.
+func take(w http.ResponseWriter, r *http.Request, store ratelimit.Store, limit ratelimit.Limit, key string) bool {
+
+	result, err := store.Take(r.Context(), key, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "taking a rate limit token, letting the request through", "error", err)
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(max(seconds(result.RetryAfter), 1)))
+		appHandler.WriteProblem(w, r, appHandler.NewProblem(http.StatusTooManyRequests, appHandler.CodeRateLimited, "Too many requests"))
+		return false
+	}
+
+	return true
+}
+
+// clientIP is the IP the request comes from
+func clientIP(r *http.Request) string {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+// seconds rounds d up to whole seconds, as the headers require
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,316 @@
+// Package migrate applies versioned SQL migrations to a database and records
+// them in the schema_migrations table.
+//
+// A migration is a pair of files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql. The statements of a file are separated by ';' at
+// the end of a line. The migrations of the MySQL products table are embedded in MySQL.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed mysql/*.sql
+var mysqlFiles embed.FS
+
+// MySQL holds the migrations of the MySQL products table
+var MySQL = mustSub(mysqlFiles, "mysql")
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+var (
+	// ErrSchemaOutdated is returned by Check when migrations are pending
+	ErrSchemaOutdated = errors.New("migrate: the database schema is outdated")
+	// ErrSchemaTooNew is returned by Check when the database was migrated by a newer version of the code
+	ErrSchemaTooNew = errors.New("migrate: the database schema is newer than the code")
+)
+
+// Migration is a version of the schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in the root of fsys, sorted by version. Every
+// migration needs both files and the versions must be unique.
+func Load(fsys fs.FS) ([]Migration, error) {
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("migrate: reading the migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrate: %s is not named <version>_<name>.up.sql or <version>_<name>.down.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		if version < 1 {
+			return nil, fmt.Errorf("migrate: %s: versions start at 1", entry.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("migrate: reading %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if strings.TrimSpace(migration.Up) == "" || strings.TrimSpace(migration.Down) == "" {
+			return nil, fmt.Errorf("migrate: migration %d_%s needs an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Migrator applies the migrations to a database. The tracking table uses ?
+// placeholders, as MySQL and SQLite do.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New loads the migrations of fsys for db
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Migrations returns the known migrations, sorted by version
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Latest returns the version the code expects, 0 when there are no migrations
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version of the database, 0 when nothing was applied or
+// the schema_migrations table does not exist yet. It only reads the database.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+
+	exists, err := m.tableExists(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version sql.NullInt64
+	err = m.db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("migrate: reading the schema version: %w", err)
+	}
+
+	return int(version.Int64), nil
+}
+
+// Check returns ErrSchemaOutdated or ErrSchemaTooNew when the version of the
+// database differs from the latest migration. Like Version it only reads the
+// database, so the server can call it on startup.
+func (m *Migrator) Check(ctx context.Context) error {
+
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch latest := m.Latest(); {
+	case version < latest:
+		return fmt.Errorf("%w: it is at version %d and the code expects %d, run the migrate up command", ErrSchemaOutdated, version, latest)
+	case version > latest:
+		return fmt.Errorf("%w: it is at version %d and the code expects %d", ErrSchemaTooNew, version, latest)
+	}
+
+	return nil
+}
+
+// Up applies the pending migrations in order and returns the applied ones.
+// It stops at the first migration that fails.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+
+	if err := m.createTable(ctx); err != nil {
+		return nil, err
+	}
+	version, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, migration := range m.migrations {
+		if migration.Version <= version {
+			continue
+		}
+		err := m.apply(ctx, migration, migration.Up, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				migration.Version, migration.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// Down reverts the last steps migrations, newest first, and returns the reverted ones
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+
+	if err := m.createTable(ctx); err != nil {
+		return nil, err
+	}
+	version, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration := m.migrations[i]
+		if migration.Version > version {
+			continue
+		}
+		err := m.apply(ctx, migration, migration.Down, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+			return err
+		})
+		if err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, migration)
+	}
+
+	return reverted, nil
+}
+
+// apply runs the statements of script and record in a transaction. MySQL
+// commits DDL statements implicitly, so there a failed migration may be half
+// applied and has to be fixed by hand.
+func (m *Migrator) apply(ctx context.Context, migration Migration, script string, record func(tx *sql.Tx) error) error {
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migrate: %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements(script) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("migrate: %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	if err := record(tx); err != nil {
+		return fmt.Errorf("migrate: %d_%s: recording the version: %w", migration.Version, migration.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migrate: %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// tableExists reports whether the schema_migrations table exists, reading the
+// catalog of MySQL or, when the database has no information_schema, the one of SQLite
+func (m *Migrator) tableExists(ctx context.Context) (bool, error) {
+
+	var count int
+	err := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'schema_migrations'").Scan(&count)
+	if err != nil {
+		if errSQLite := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&count); errSQLite != nil {
+			return false, fmt.Errorf("migrate: looking for the schema_migrations table: %w", err)
+		}
+	}
+
+	return count > 0, nil
+}
+
+func (m *Migrator) createTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("migrate: creating the schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// statements splits a script on the ';' that end a line, dropping the comment lines
+func statements(script string) []string {
+
+	var result []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			result = append(result, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		result = append(result, rest)
+	}
+
+	return result
+}
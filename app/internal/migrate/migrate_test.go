@@ -0,0 +1,201 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// the runner is tested on SQLite, it takes the same ? placeholders as MySQL
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+var testMigrations = fstest.MapFS{
+	"0001_create_products.up.sql":   {Data: []byte("-- the table\nCREATE TABLE products (id INTEGER PRIMARY KEY, name TEXT NOT NULL);\n")},
+	"0001_create_products.down.sql": {Data: []byte("DROP TABLE products;\n")},
+	"0002_add_price.up.sql": {Data: []byte("ALTER TABLE products ADD COLUMN price REAL NOT NULL DEFAULT 0;\n" +
+		"CREATE INDEX idx_products_price ON products (price);\n")},
+	"0002_add_price.down.sql": {Data: []byte("DROP INDEX idx_products_price;\nALTER TABLE products DROP COLUMN price;\n")},
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("ordena por version", func(t *testing.T) {
+		// Act
+		migrations, err := Load(testMigrations)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, migrations, 2)
+		require.Equal(t, 1, migrations[0].Version)
+		require.Equal(t, "create_products", migrations[0].Name)
+		require.Equal(t, 2, migrations[1].Version)
+	})
+
+	t.Run("las migraciones de MySQL son validas", func(t *testing.T) {
+		// Act
+		migrations, err := Load(MySQL)
+
+		// Assert
+		require.NoError(t, err)
+		require.NotEmpty(t, migrations)
+	})
+
+	t.Run("falta el archivo down", func(t *testing.T) {
+		// Arrange
+		fsys := fstest.MapFS{"0001_create.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER);")}}
+
+		// Act
+		_, err := Load(fsys)
+
+		// Assert
+		require.EqualError(t, err, "migrate: migration 1_create needs an up and a down file")
+	})
+
+	t.Run("nombre invalido", func(t *testing.T) {
+		// Arrange
+		fsys := fstest.MapFS{"create.sql": {Data: []byte("CREATE TABLE a (id INTEGER);")}}
+
+		// Act
+		_, err := Load(fsys)
+
+		// Assert
+		require.ErrorContains(t, err, "create.sql is not named")
+	})
+}
+
+func TestMigrator(t *testing.T) {
+	t.Run("up aplica las pendientes y check las reconoce", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		db := newTestDB(t)
+		migrator, err := New(db, testMigrations)
+		require.NoError(t, err)
+		require.ErrorIs(t, migrator.Check(ctx), ErrSchemaOutdated)
+
+		// Act
+		applied, err := migrator.Up(ctx)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, applied, 2)
+		require.NoError(t, migrator.Check(ctx))
+		_, err = db.Exec("INSERT INTO products (name, price) VALUES ('Brie', 10)")
+		require.NoError(t, err)
+
+		applied, err = migrator.Up(ctx)
+		require.NoError(t, err)
+		require.Empty(t, applied)
+	})
+
+	t.Run("check no modifica una base sin migrar", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		db := newTestDB(t)
+		migrator, err := New(db, testMigrations)
+		require.NoError(t, err)
+
+		// Act
+		errCheck := migrator.Check(ctx)
+		version, errVersion := migrator.Version(ctx)
+
+		// Assert
+		require.ErrorIs(t, errCheck, ErrSchemaOutdated)
+		require.NoError(t, errVersion)
+		require.Zero(t, version)
+		var tables int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables))
+		require.Zero(t, tables)
+	})
+
+	t.Run("down revierte la ultima", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		db := newTestDB(t)
+		migrator, err := New(db, testMigrations)
+		require.NoError(t, err)
+		_, err = migrator.Up(ctx)
+		require.NoError(t, err)
+
+		// Act
+		reverted, err := migrator.Down(ctx, 1)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, reverted, 1)
+		require.Equal(t, 2, reverted[0].Version)
+		version, err := migrator.Version(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, version)
+		_, err = db.Exec("INSERT INTO products (name, price) VALUES ('Brie', 10)")
+		require.Error(t, err)
+
+		reverted, err = migrator.Down(ctx, 5)
+		require.NoError(t, err)
+		require.Len(t, reverted, 1)
+		version, err = migrator.Version(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 0, version)
+	})
+
+	t.Run("una migracion que falla no se registra", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		db := newTestDB(t)
+		fsys := fstest.MapFS{
+			"0001_create_products.up.sql":   testMigrations["0001_create_products.up.sql"],
+			"0001_create_products.down.sql": testMigrations["0001_create_products.down.sql"],
+			"0002_broken.up.sql":            {Data: []byte("ALTER TABLE missing ADD COLUMN price REAL;")},
+			"0002_broken.down.sql":          {Data: []byte("SELECT 1;")},
+		}
+		migrator, err := New(db, fsys)
+		require.NoError(t, err)
+
+		// Act
+		applied, err := migrator.Up(ctx)
+
+		// Assert
+		require.ErrorContains(t, err, "migrate: 2_broken:")
+		require.Len(t, applied, 1)
+		version, err := migrator.Version(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, version)
+	})
+
+	t.Run("una base mas nueva que el codigo", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		db := newTestDB(t)
+		migrator, err := New(db, testMigrations)
+		require.NoError(t, err)
+		_, err = migrator.Up(ctx)
+		require.NoError(t, err)
+		older, err := New(db, fstest.MapFS{
+			"0001_create_products.up.sql":   testMigrations["0001_create_products.up.sql"],
+			"0001_create_products.down.sql": testMigrations["0001_create_products.down.sql"],
+		})
+		require.NoError(t, err)
+
+		// Act
+		err = older.Check(ctx)
+
+		// Assert
+		require.ErrorIs(t, err, ErrSchemaTooNew)
+	})
+}
+
+func TestStatements(t *testing.T) {
+	// Act
+	result := statements("-- comment\nCREATE TABLE a (\n  id INT\n);\n\nDROP TABLE b;\nSELECT 1")
+
+	// Assert
+	require.Equal(t, []string{"CREATE TABLE a (\n  id INT\n)", "DROP TABLE b", "SELECT 1"}, result)
+}
@@ -0,0 +1,11 @@
+-- IF NOT EXISTS adopts the products table of databases created by hand
+CREATE TABLE IF NOT EXISTS products (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    quantity INT NOT NULL,
+    code_value VARCHAR(255) NOT NULL,
+    is_published BOOLEAN NOT NULL,
+    expiration DATE NOT NULL,
+    price DECIMAL(10, 2) NOT NULL,
+    PRIMARY KEY (id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,2 @@
+-- the full-text search of the repository (MATCH ... AGAINST) needs this index
+CREATE FULLTEXT INDEX idx_products_name ON products (name);
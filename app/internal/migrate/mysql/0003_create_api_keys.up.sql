@@ -0,0 +1,12 @@
+-- only the SHA-256 of the secrets is stored, the scopes are separated by spaces
+CREATE TABLE api_keys (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    expires_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_api_keys_hash (hash)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,2 @@
+-- the optimistic concurrency of the repository compares and increments it on every change
+ALTER TABLE products ADD COLUMN version INT NOT NULL DEFAULT 1;
@@ -0,0 +1,14 @@
+-- the responses to the requests sent with an Idempotency-Key header, expires_at
+-- is in unix seconds
+CREATE TABLE idempotency_keys (
+    principal VARCHAR(255) NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    done BOOLEAN NOT NULL,
+    status INT NOT NULL,
+    header TEXT NOT NULL,
+    body MEDIUMBLOB NOT NULL,
+    expires_at BIGINT NOT NULL,
+    PRIMARY KEY (principal, idempotency_key),
+    KEY idx_idempotency_keys_expires_at (expires_at)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1 @@
+DROP INDEX idx_products_code_value ON products;
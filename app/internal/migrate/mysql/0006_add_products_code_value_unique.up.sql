@@ -0,0 +1,3 @@
+-- the repository maps a duplicated code value to a conflict, so two concurrent
+-- creations cannot both take the same code
+CREATE UNIQUE INDEX idx_products_code_value ON products (code_value);
@@ -0,0 +1,14 @@
+package internal
+
+import "errors"
+
+// the errors of the patch documents, see the patch package
+var (
+	// ErrInvalidPatch is a patch document that cannot be parsed
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrPatchConflict is an operation that cannot be applied to the document,
+	// e.g. replacing a member it does not have
+	ErrPatchConflict = errors.New("patch cannot be applied")
+	// ErrPatchTestFailed is a test operation whose value differs from the one of the document
+	ErrPatchTestFailed = errors.New("patch test failed")
+)
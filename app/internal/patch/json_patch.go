@@ -0,0 +1,329 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goweb/app/internal"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// operation is an operation of a JSON Patch, with its pointers parsed
+type operation struct {
+	op    string
+	path  []string
+	from  []string
+	value any
+}
+
+// Apply applies the JSON Patch to the JSON document and returns the result.
+// The patch is parsed as a whole before any operation is applied. Errors are
+// *Error but for a patch that is not a JSON array of objects, which wraps
+// internal.ErrInvalidPatch too.
+func Apply(document, patch []byte) ([]byte, error) {
+
+	var target any
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, err
+	}
+	operations, err := parseOperations(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, operation := range operations {
+		target, err = operation.apply(target)
+		if err != nil {
+			// the operations only build the *Error of a failed test
+			var cause *Error
+			if !errors.As(err, &cause) {
+				cause = &Error{Message: err.Error(), err: internal.ErrPatchConflict}
+			}
+			cause.Index, cause.Op, cause.Path = i, operation.op, formatPointer(operation.path)
+			return nil, cause
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+// parseOperations parses every operation of the patch, checking they have the members their op needs
+func parseOperations(patch []byte) ([]operation, error) {
+
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &raw); err != nil {
+		return nil, fmt.Errorf("%w: a JSON Patch must be an array of operations: %v", internal.ErrInvalidPatch, err)
+	}
+
+	operations := make([]operation, 0, len(raw))
+	for i, members := range raw {
+		invalid := func(path, format string, args ...any) error {
+			var op string
+			json.Unmarshal(members["op"], &op)
+			return &Error{Index: i, Op: op, Path: path, Message: fmt.Sprintf(format, args...), err: internal.ErrInvalidPatch}
+		}
+
+		var op, path string
+		if err := json.Unmarshal(members["op"], &op); err != nil || op == "" {
+			return nil, invalid("", "op must be a string")
+		}
+		if _, ok := members["path"]; !ok {
+			return nil, invalid("", "path is required")
+		}
+		if err := json.Unmarshal(members["path"], &path); err != nil {
+			return nil, invalid("", "path must be a string")
+		}
+		operation := operation{op: op}
+		var err error
+		if operation.path, err = parsePointer(path); err != nil {
+			return nil, invalid(path, "%v", err)
+		}
+
+		switch op {
+		case "add", "replace", "test":
+			value, ok := members["value"]
+			if !ok {
+				return nil, invalid(path, "value is required")
+			}
+			if err := json.Unmarshal(value, &operation.value); err != nil {
+				return nil, invalid(path, "invalid value: %v", err)
+			}
+		case "move", "copy":
+			var from string
+			if err := json.Unmarshal(members["from"], &from); err != nil {
+				return nil, invalid(path, "from must be a string")
+			}
+			if operation.from, err = parsePointer(from); err != nil {
+				return nil, invalid(path, "from: %v", err)
+			}
+			if op == "move" && isPrefix(operation.from, operation.path) && len(operation.from) < len(operation.path) {
+				return nil, invalid(path, "cannot move a value into one of its children")
+			}
+		case "remove":
+		default:
+			return nil, invalid(path, "unknown op %q", op)
+		}
+		operations = append(operations, operation)
+	}
+
+	return operations, nil
+}
+
+// apply applies the operation to the document, returning the new document
+func (o operation) apply(document any) (any, error) {
+
+	switch o.op {
+	case "add":
+		return put(document, o.path, o.value, true)
+	case "remove":
+		return remove(document, o.path)
+	case "replace":
+		return put(document, o.path, o.value, false)
+	case "move":
+		value, err := get(document, o.from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		if document, err = remove(document, o.from); err != nil {
+			return nil, err
+		}
+		return put(document, o.path, value, true)
+	case "copy":
+		value, err := get(document, o.from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		return put(document, o.path, deepCopy(value), true)
+	}
+
+	// test
+	value, err := get(document, o.path)
+	if err != nil {
+		return nil, err
+	}
+	if !reflect.DeepEqual(value, o.value) {
+		expected, _ := json.Marshal(o.value)
+		found, _ := json.Marshal(value)
+		return nil, &Error{Message: fmt.Sprintf("expected %s but found %s", expected, found), err: internal.ErrPatchTestFailed}
+	}
+	return document, nil
+}
+
+// get returns the value at the pointer
+func get(document any, pointer []string) (any, error) {
+
+	for i, token := range pointer {
+		switch node := document.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%s does not exist", formatPointer(pointer[:i+1]))
+			}
+			document = value
+		case []any:
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", formatPointer(pointer[:i+1]), err)
+			}
+			document = node[index]
+		default:
+			return nil, fmt.Errorf("%s is not an object or an array", formatPointer(pointer[:i]))
+		}
+	}
+	return document, nil
+}
+
+// put sets the value at the pointer and returns the new document. With insert
+// it adds the value, inserting it in arrays, otherwise the value must exist
+// and is replaced.
+func put(document any, pointer []string, value any, insert bool) (any, error) {
+
+	if len(pointer) == 0 {
+		return value, nil
+	}
+	parent, err := get(document, pointer[:len(pointer)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	token := pointer[len(pointer)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		if _, ok := node[token]; !ok && !insert {
+			return nil, fmt.Errorf("%s does not exist", formatPointer(pointer))
+		}
+		node[token] = value
+		return document, nil
+	case []any:
+		last := len(node) - 1
+		if insert {
+			last = len(node)
+			if token == "-" {
+				token = strconv.Itoa(len(node))
+			}
+		}
+		index, err := arrayIndex(token, last)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", formatPointer(pointer), err)
+		}
+		if !insert {
+			node[index] = value
+			return document, nil
+		}
+		node = append(node[:index], append([]any{value}, node[index:]...)...)
+		return put(document, pointer[:len(pointer)-1], node, false)
+	}
+	return nil, fmt.Errorf("%s is not an object or an array", formatPointer(pointer[:len(pointer)-1]))
+}
+
+// remove removes the value at the pointer, which must exist, and returns the new document
+func remove(document any, pointer []string) (any, error) {
+
+	if len(pointer) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	parent, err := get(document, pointer[:len(pointer)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	token := pointer[len(pointer)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		if _, ok := node[token]; !ok {
+			return nil, fmt.Errorf("%s does not exist", formatPointer(pointer))
+		}
+		delete(node, token)
+		return document, nil
+	case []any:
+		index, err := arrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", formatPointer(pointer), err)
+		}
+		node = append(node[:index:index], node[index+1:]...)
+		return put(document, pointer[:len(pointer)-1], node, false)
+	}
+	return nil, fmt.Errorf("%s is not an object or an array", formatPointer(pointer[:len(pointer)-1]))
+}
+
+// arrayIndex parses the token of an array element, which cannot be greater than last
+func arrayIndex(token string, last int) (int, error) {
+
+	// RFC 6901 does not allow leading zeros nor signs
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index > last {
+		return 0, fmt.Errorf("index %s is out of bounds", token)
+	}
+	return index, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("pointer %q must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		// ~ only escapes itself, as ~0, and /, as ~1
+		if strings.Count(token, "~") != strings.Count(token, "~0")+strings.Count(token, "~1") {
+			return nil, fmt.Errorf("pointer %q has an invalid escape", pointer)
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// formatPointer escapes the tokens back into a JSON Pointer
+func formatPointer(tokens []string) string {
+
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteString("/")
+		b.WriteString(strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1"))
+	}
+	return b.String()
+}
+
+// isPrefix reports whether the prefix pointer points at pointer or at one of its parents
+func isPrefix(prefix, pointer []string) bool {
+
+	if len(prefix) > len(pointer) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != pointer[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// deepCopy copies a decoded JSON value, so a copied value does not share its objects and arrays
+func deepCopy(value any) any {
+
+	switch v := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(v))
+		for name, member := range v {
+			copied[name] = deepCopy(member)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(v))
+		for i, element := range v {
+			copied[i] = deepCopy(element)
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,82 @@
+// Package patch applies the patch documents of PATCH requests to a JSON
+// document, either an RFC 7396 JSON Merge Patch:
+//
+//	{"price": 12.5, "is_published": null}
+//
+// where null removes a member, or an RFC 6902 JSON Patch, a list of
+// operations applied in order that fails as a whole if any of them fails:
+//
+//	[{"op": "test", "path": "/price", "value": 10}, {"op": "replace", "path": "/price", "value": 12.5}]
+//
+// Neither knows the schema of the document, the result must be validated.
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"goweb/app/internal"
+)
+
+// the media types of the patch documents
+const (
+	MediaTypeMergePatch = "application/merge-patch+json"
+	MediaTypeJSONPatch  = "application/json-patch+json"
+)
+
+// Error reports the operation of a JSON Patch that could not be applied. It
+// wraps internal.ErrInvalidPatch, internal.ErrPatchConflict or
+// internal.ErrPatchTestFailed.
+type Error struct {
+	// Index is the position of the operation in the patch, from 0
+	Index   int
+	Op      string
+	Path    string
+	Message string
+	err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("operation %d (%s %q): %s", e.Index, e.Op, e.Path, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Merge applies the merge patch to the JSON document and returns the result.
+// A patch that is not JSON wraps internal.ErrInvalidPatch.
+func Merge(document, patch []byte) ([]byte, error) {
+
+	var target, changes any
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, fmt.Errorf("%w: %v", internal.ErrInvalidPatch, err)
+	}
+
+	return json.Marshal(merge(target, changes))
+}
+
+// merge is the MergePatch function of RFC 7396
+func merge(target, patch any) any {
+
+	changes, ok := patch.(map[string]any)
+	if !ok {
+		// anything but an object replaces the target as a whole
+		return patch
+	}
+	members, ok := target.(map[string]any)
+	if !ok {
+		members = map[string]any{}
+	}
+
+	for name, value := range changes {
+		if value == nil {
+			delete(members, name)
+			continue
+		}
+		members[name] = merge(members[name], value)
+	}
+	return members
+}
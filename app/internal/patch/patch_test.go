@@ -0,0 +1,116 @@
+package patch_test
+
+import (
+	"goweb/app/internal"
+	"goweb/app/internal/patch"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	t.Run("Aplica los ejemplos de la RFC 7396", func(t *testing.T) {
+		cases := []struct {
+			document string
+			patch    string
+			expected string
+		}{
+			{document: `{"a":"b"}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+			{document: `{"a":"b"}`, patch: `{"b":"c"}`, expected: `{"a":"b","b":"c"}`},
+			{document: `{"a":"b"}`, patch: `{"a":null}`, expected: `{}`},
+			{document: `{"a":"b","b":"c"}`, patch: `{"a":null}`, expected: `{"b":"c"}`},
+			{document: `{"a":["b"]}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+			{document: `{"a":"c"}`, patch: `{"a":["b"]}`, expected: `{"a":["b"]}`},
+			{document: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, expected: `{"a":{"b":"d"}}`},
+			{document: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, expected: `{"a":[1]}`},
+			{document: `{"e":null}`, patch: `{"a":1}`, expected: `{"e":null,"a":1}`},
+			{document: `[1,2]`, patch: `{"a":"b","c":null}`, expected: `{"a":"b"}`},
+			{document: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, expected: `{"a":{"bb":{}}}`},
+		}
+		for _, c := range cases {
+			// Act
+			result, err := patch.Merge([]byte(c.document), []byte(c.patch))
+
+			// Assert
+			require.NoError(t, err, c.patch)
+			require.JSONEq(t, c.expected, string(result), c.patch)
+		}
+	})
+
+	t.Run("Un patch que no es JSON es invalido", func(t *testing.T) {
+		// Act
+		_, err := patch.Merge([]byte(`{"a":"b"}`), []byte(`{"a":`))
+
+		// Assert
+		require.ErrorIs(t, err, internal.ErrInvalidPatch)
+	})
+}
+
+func TestApply(t *testing.T) {
+	t.Run("Aplica las operaciones en orden", func(t *testing.T) {
+		cases := []struct {
+			name     string
+			document string
+			patch    string
+			expected string
+		}{
+			{name: "add a un objeto", document: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz","value":"qux"}]`, expected: `{"foo":"bar","baz":"qux"}`},
+			{name: "add a un array", document: `{"foo":["bar","baz"]}`, patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`, expected: `{"foo":["bar","qux","baz"]}`},
+			{name: "add al final", document: `{"foo":["bar"]}`, patch: `[{"op":"add","path":"/foo/-","value":["abc"]}]`, expected: `{"foo":["bar",["abc"]]}`},
+			{name: "remove", document: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"remove","path":"/baz"}]`, expected: `{"foo":"bar"}`},
+			{name: "remove de un array", document: `{"foo":["bar","qux","baz"]}`, patch: `[{"op":"remove","path":"/foo/1"}]`, expected: `{"foo":["bar","baz"]}`},
+			{name: "replace", document: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"replace","path":"/baz","value":null}]`, expected: `{"baz":null,"foo":"bar"}`},
+			{name: "move", document: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, expected: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+			{name: "move en un array", document: `{"foo":["all","grass","cows","eat"]}`, patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, expected: `{"foo":["all","cows","eat","grass"]}`},
+			{name: "copy no comparte el valor", document: `{"a":{"b":1}}`, patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, expected: `{"a":{"b":1},"c":{"b":2}}`},
+			{name: "test y replace", document: `{"price":10}`, patch: `[{"op":"test","path":"/price","value":10.0},{"op":"replace","path":"/price","value":12.5}]`, expected: `{"price":12.5}`},
+			{name: "punteros escapados", document: `{"a/b":1,"m~n":2}`, patch: `[{"op":"test","path":"/a~1b","value":1},{"op":"remove","path":"/m~0n"}]`, expected: `{"a/b":1}`},
+			{name: "patch vacio", document: `{"foo":"bar"}`, patch: `[]`, expected: `{"foo":"bar"}`},
+		}
+		for _, c := range cases {
+			// Act
+			result, err := patch.Apply([]byte(c.document), []byte(c.patch))
+
+			// Assert
+			require.NoError(t, err, c.name)
+			require.JSONEq(t, c.expected, string(result), c.name)
+		}
+	})
+
+	t.Run("Los errores indican la operacion que fallo", func(t *testing.T) {
+		cases := []struct {
+			name  string
+			patch string
+			err   error
+			index int
+			path  string
+		}{
+			{name: "op desconocida", patch: `[{"op":"test","path":"/a","value":1},{"op":"merge","path":"/a"}]`, err: internal.ErrInvalidPatch, index: 1, path: "/a"},
+			{name: "sin value", patch: `[{"op":"add","path":"/b"}]`, err: internal.ErrInvalidPatch, index: 0, path: "/b"},
+			{name: "puntero invalido", patch: `[{"op":"remove","path":"a"}]`, err: internal.ErrInvalidPatch, index: 0, path: "a"},
+			{name: "move a un hijo", patch: `[{"op":"move","from":"/a","path":"/a/b"}]`, err: internal.ErrInvalidPatch, index: 0, path: "/a/b"},
+			{name: "replace de un miembro que no existe", patch: `[{"op":"replace","path":"/b","value":1}]`, err: internal.ErrPatchConflict, index: 0, path: "/b"},
+			{name: "remove fuera del array", patch: `[{"op":"remove","path":"/list/2"}]`, err: internal.ErrPatchConflict, index: 0, path: "/list/2"},
+			{name: "test fallido", patch: `[{"op":"replace","path":"/a","value":2},{"op":"test","path":"/a","value":1}]`, err: internal.ErrPatchTestFailed, index: 1, path: "/a"},
+		}
+		for _, c := range cases {
+			// Act
+			_, err := patch.Apply([]byte(`{"a":1,"list":[1,2]}`), []byte(c.patch))
+
+			// Assert
+			var patchErr *patch.Error
+			require.ErrorAs(t, err, &patchErr, c.name)
+			require.ErrorIs(t, err, c.err, c.name)
+			require.Equal(t, c.index, patchErr.Index, c.name)
+			require.Equal(t, c.path, patchErr.Path, c.name)
+		}
+	})
+
+	t.Run("Un patch que no es un array es invalido", func(t *testing.T) {
+		// Act
+		_, err := patch.Apply([]byte(`{"a":1}`), []byte(`{"op":"remove","path":"/a"}`))
+
+		// Assert
+		require.ErrorIs(t, err, internal.ErrInvalidPatch)
+	})
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"context"
+	"errors"
+)
+
+// the roles carried by the JWT bearer tokens, the RBAC policy defines their permissions
+const (
+	RoleViewer         = "viewer"
+	RoleEditor         = "editor"
+	RolePricingManager = "pricing_manager"
+	RoleAdmin          = "admin"
+)
+
+var (
+	// ErrForbidden is returned when the principal lacks a permission
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Principal is the authenticated client of a request. API keys carry scopes
+// and bearer tokens carry roles.
+type Principal struct {
+	// ID identifies the client, e.g. "apikey:3" or "jwt:<subject>"
+	ID     string
+	Name   string
+	Scopes []string
+	Roles  []string
+}
+
+// HasScope reports whether the principal was granted scope
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the principal has role
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx carrying the principal
+func ContextWithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal of the request, if it was authenticated
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
@@ -10,6 +10,11 @@ type Product struct {
 	IsPublished bool
 	Expiration  time.Time
 	Price       float64
+	// Version counts the changes of the product, from 1. Given to
+	// UpdateProduct it is the version the change was based on and the update
+	// fails with ErrProductVersionMismatch if the product changed since, 0
+	// updates whatever the version.
+	Version int
 }
 
 func (p *Product) IsEmpty() bool {
@@ -0,0 +1,118 @@
+package internal
+
+import "errors"
+
+// ProductField is a product attribute that can be used to filter or sort
+type ProductField string
+
+const (
+	ProductFieldID          ProductField = "id"
+	ProductFieldName        ProductField = "name"
+	ProductFieldQuantity    ProductField = "quantity"
+	ProductFieldCodeValue   ProductField = "code_value"
+	ProductFieldIsPublished ProductField = "is_published"
+	ProductFieldExpiration  ProductField = "expiration"
+	ProductFieldPrice       ProductField = "price"
+)
+
+// ProductFields lists every field a query can reference
+var ProductFields = []ProductField{
+	ProductFieldID,
+	ProductFieldName,
+	ProductFieldQuantity,
+	ProductFieldCodeValue,
+	ProductFieldIsPublished,
+	ProductFieldExpiration,
+	ProductFieldPrice,
+}
+
+// IsValid reports whether the field is one of ProductFields
+func (f ProductField) IsValid() bool {
+	for _, field := range ProductFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterOp is the comparison applied by a ProductFilter
+type FilterOp string
+
+const (
+	FilterOpEq  FilterOp = "="
+	FilterOpNe  FilterOp = "!="
+	FilterOpGt  FilterOp = ">"
+	FilterOpGte FilterOp = ">="
+	FilterOpLt  FilterOp = "<"
+	FilterOpLte FilterOp = "<="
+	// FilterOpContains matches strings containing the value, ignoring case
+	FilterOpContains FilterOp = "~"
+)
+
+// FilterExpr is a boolean expression over product fields. It is either a
+// ProductFilter or a combination of expressions with FilterAnd, FilterOr and FilterNot.
+type FilterExpr interface {
+	filterExpr()
+}
+
+// FilterAnd matches when both expressions match
+type FilterAnd struct {
+	Left, Right FilterExpr
+}
+
+// FilterOr matches when any of the expressions match
+type FilterOr struct {
+	Left, Right FilterExpr
+}
+
+// FilterNot matches when the expression does not match
+type FilterNot struct {
+	Expr FilterExpr
+}
+
+func (ProductFilter) filterExpr() {}
+func (FilterAnd) filterExpr()     {}
+func (FilterOr) filterExpr()      {}
+func (FilterNot) filterExpr()     {}
+
+// ProductFilter compares a field against a value. The value type must match the
+// field: int for id and quantity, string for name and code_value, bool for
+// is_published, time.Time for expiration and float64 for price.
+type ProductFilter struct {
+	Field ProductField
+	Op    FilterOp
+	Value any
+}
+
+// ProductSort orders the results by a field
+type ProductSort struct {
+	Field ProductField
+	Desc  bool
+}
+
+// ProductQuery describes which products to fetch. Every filter and the Where
+// expression (if any) must match, the results are ordered by Sort (and then by
+// id) and a Limit of 0 means no limit.
+//
+// Text is a full-text search over the product names: every word must match a
+// word of the name or be a prefix of it, ignoring case and accents. When Text is
+// set and there is no Sort the results are ordered by relevance.
+type ProductQuery struct {
+	Text    string
+	Filters []ProductFilter
+	Where   FilterExpr
+	Sort    []ProductSort
+	Limit   int
+	Offset  int
+}
+
+// ProductPage is a page of products plus the number of products matching the query
+type ProductPage struct {
+	Products []Product
+	Total    int
+}
+
+var (
+	ErrInvalidQuery = errors.New("invalid product query")
+)
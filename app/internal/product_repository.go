@@ -1,10 +1,20 @@
 package internal
 
+import "context"
+
 type ProductRepository interface {
-	GetAllProducts() []Product
-	GetProductByID(id int) Product
-	GetProductsByPriceGreaterThan(price float64) []Product
-	AddProduct(product Product) Product
-	UpdateProduct(product Product) (Product, error)
-	DeleteProduct(id int) error
+	GetAllProducts(ctx context.Context) ([]Product, error)
+	SearchProducts(ctx context.Context, query ProductQuery) (ProductPage, error)
+	GetProductByID(ctx context.Context, id int) (Product, error)
+	AddProduct(ctx context.Context, product Product) (Product, error)
+	UpdateProduct(ctx context.Context, product Product) (Product, error)
+	// DeleteProduct deletes the product if it still has the version, 0 deletes it whatever the version
+	DeleteProduct(ctx context.Context, id, version int) error
+}
+
+// HealthChecker is implemented by the repositories that depend on something
+// that can fail, like a database server or a file. HealthCheck returns an
+// error when the repository cannot serve requests.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
 }
@@ -1,16 +1,19 @@
 package internal
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 type ProductService interface {
-	GetAllProducts() []Product
-	GetProductByID(id int) (Product, error)
-	GetProductsByPriceGreaterThan(price float64) []Product
-	CreateProduct(product Product) (Product, error)
+	GetAllProducts(ctx context.Context) ([]Product, error)
+	SearchProducts(ctx context.Context, query ProductQuery) (ProductPage, error)
+	GetProductByID(ctx context.Context, id int) (Product, error)
+	CreateProduct(ctx context.Context, product Product) (Product, error)
 	// UpdateOrCreateProduct(product Product) (Product, error)
-	UpdateProduct(product Product) (Product, error)
-	DeleteProduct(id int) error
-	CalculateConsumerPrice(id ...int) ([]Product, float64, error)
+	UpdateProduct(ctx context.Context, product Product) (Product, error)
+	DeleteProduct(ctx context.Context, id, version int) error
+	CalculateConsumerPrice(ctx context.Context, id ...int) ([]Product, float64, error)
 }
 
 var (
@@ -19,4 +22,5 @@ var (
 	ErrProductEmpty            = errors.New("product is empty")
 	ErrInvalidExpirationFormat = errors.New("invalid expiration format")
 	ErrCodeValueBelongsToOther = errors.New("code value belongs to other product")
+	ErrProductVersionMismatch  = errors.New("product version mismatch")
 )
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how often MemoryStore drops the buckets that are full again,
+// a full bucket is the same as no bucket at all
+const sweepEvery = time.Minute
+
+// bucket has tokens at last, the tokens refilled since then are counted on the next take
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket is full again
+	full time.Time
+}
+
+// MemoryStore implements the Store interface in memory, so each instance of
+// the server has its own buckets. It is safe for concurrent use.
+type MemoryStore struct {
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take takes a token from the bucket of key
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	// refill the bucket with the tokens earned since the last take
+	interval := limit.interval()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+float64(now.Sub(b.last))/float64(interval))
+	b.last = now
+
+	result := Result{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(interval))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((float64(limit.Burst) - b.tokens) * float64(interval))
+	b.full = now.Add(result.Reset)
+
+	return result, nil
+}
+
+// sweep drops the buckets that are full at now, at most once every sweepEvery
+func (s *MemoryStore) sweep(now time.Time) {
+
+	if now.Sub(s.lastSweep) < sweepEvery {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if !b.full.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
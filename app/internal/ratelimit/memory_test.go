@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+
+	// Arrange
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Requests: 6, Period: time.Minute, Burst: 2}
+	take := func(key string) Result {
+		result, err := store.Take(context.Background(), key, limit)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("Se vacia el bucket y se rellena con el tiempo", func(t *testing.T) {
+		// Act
+		first := take("a")
+		second := take("a")
+		third := take("a")
+		other := take("b")
+		now = now.Add(4 * time.Second)
+		early := take("a")
+		now = now.Add(6 * time.Second)
+		refilled := take("a")
+
+		// Assert
+		require.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 1, Reset: 10 * time.Second}, first)
+		require.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 20 * time.Second}, second)
+		require.Equal(t, Result{Allowed: false, Limit: 2, Remaining: 0, RetryAfter: 10 * time.Second, Reset: 20 * time.Second}, third)
+		require.True(t, other.Allowed)
+		require.False(t, early.Allowed)
+		require.Equal(t, 6*time.Second, early.RetryAfter)
+		require.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 20 * time.Second}, refilled)
+	})
+
+	t.Run("Se descartan los buckets llenos", func(t *testing.T) {
+		// Act
+		now = now.Add(sweepEvery)
+		take("c")
+
+		// Assert
+		require.Len(t, store.buckets, 1)
+	})
+}
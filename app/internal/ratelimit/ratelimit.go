@@ -0,0 +1,49 @@
+// Package ratelimit implements token buckets. Each bucket holds up to Burst
+// tokens and gets Requests tokens back every Period, a request takes one token
+// and is rejected when the bucket is empty. The buckets live in a Store, in
+// memory with MemoryStore or in a shared store when several instances of the
+// server must share the quotas.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit is the size and the refill rate of a bucket. A zero Requests means no limit.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// Enabled reports whether the limit rejects requests at all
+func (l Limit) Enabled() bool {
+	return l.Requests > 0
+}
+
+// interval is the time it takes to get one token back
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Result is the state of a bucket after a Take
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket
+	Limit int
+	// Remaining is the number of tokens left
+	Remaining int
+	// RetryAfter is the time until the next token, zero when Allowed
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps the buckets. Take must be atomic: two concurrent calls for the
+// same key cannot both take the last token.
+type Store interface {
+	// Take takes a token from the bucket of key, creating a full one if it
+	// does not exist yet
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
@@ -0,0 +1,201 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"goweb/app/internal"
+)
+
+// NewProductServiceGuard wraps service, checking the policy against the
+// principal of the context before each operation
+func NewProductServiceGuard(service internal.ProductService, policy *Policy) *ProductServiceGuard {
+	return &ProductServiceGuard{service: service, policy: policy}
+}
+
+// ProductServiceGuard implements the ProductService interface. It hides the
+// unpublished products from the principals without products:read:unpublished,
+// as if they did not exist, and rejects the updates that change a field the
+// principal is not allowed to change with internal.ErrForbidden.
+type ProductServiceGuard struct {
+	service internal.ProductService
+	policy  *Policy
+}
+
+func (g *ProductServiceGuard) GetAllProducts(ctx context.Context) ([]internal.Product, error) {
+
+	principal, err := g.require(ctx, internal.ScopeProductsRead)
+	if err != nil {
+		return nil, err
+	}
+
+	products, err := g.service.GetAllProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.policy.Allows(principal, internal.ScopeProductsReadUnpublished) {
+		return products, nil
+	}
+	published := make([]internal.Product, 0, len(products))
+	for _, product := range products {
+		if product.IsPublished {
+			published = append(published, product)
+		}
+	}
+	return published, nil
+}
+
+func (g *ProductServiceGuard) SearchProducts(ctx context.Context, query internal.ProductQuery) (internal.ProductPage, error) {
+
+	principal, err := g.require(ctx, internal.ScopeProductsRead)
+	if err != nil {
+		return internal.ProductPage{}, err
+	}
+
+	// the filter goes to the repository so the total and the pages stay right
+	if !g.policy.Allows(principal, internal.ScopeProductsReadUnpublished) {
+		filters := make([]internal.ProductFilter, len(query.Filters), len(query.Filters)+1)
+		copy(filters, query.Filters)
+		query.Filters = append(filters, internal.ProductFilter{Field: internal.ProductFieldIsPublished, Op: internal.FilterOpEq, Value: true})
+	}
+
+	return g.service.SearchProducts(ctx, query)
+}
+
+func (g *ProductServiceGuard) GetProductByID(ctx context.Context, id int) (internal.Product, error) {
+
+	principal, err := g.require(ctx, internal.ScopeProductsRead)
+	if err != nil {
+		return internal.Product{}, err
+	}
+
+	product, err := g.service.GetProductByID(ctx, id)
+	if err != nil {
+		return internal.Product{}, err
+	}
+
+	if !product.IsPublished && !g.policy.Allows(principal, internal.ScopeProductsReadUnpublished) {
+		return internal.Product{}, internal.ErrProductNotFound
+	}
+	return product, nil
+}
+
+// CreateProduct needs products:write and, as if every field changed from its
+// zero value, the permission of each protected field the product sets. So an
+// editor creates the products without a price, or deleting and creating a
+// product again would change its price.
+func (g *ProductServiceGuard) CreateProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+
+	principal, err := g.require(ctx, internal.ScopeProductsWrite)
+	if err != nil {
+		return internal.Product{}, err
+	}
+	if err := g.checkFields(principal, internal.Product{}, product); err != nil {
+		return internal.Product{}, err
+	}
+
+	return g.service.CreateProduct(ctx, product)
+}
+
+// UpdateProduct compares the product with the stored one, each changed field
+// needs products:write or, if it is protected, its own permission
+func (g *ProductServiceGuard) UpdateProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+
+	principal, err := g.require(ctx, g.policy.WritePermissions()...)
+	if err != nil {
+		return internal.Product{}, err
+	}
+
+	current, err := g.service.GetProductByID(ctx, product.ID)
+	if err != nil {
+		return internal.Product{}, err
+	}
+
+	if err := g.checkFields(principal, current, product); err != nil {
+		return internal.Product{}, err
+	}
+
+	// the fields were checked against current, so the update must not land on
+	// another version, e.g. one with a price the principal could not set
+	if product.Version == 0 {
+		product.Version = current.Version
+	}
+
+	return g.service.UpdateProduct(ctx, product)
+}
+
+func (g *ProductServiceGuard) DeleteProduct(ctx context.Context, id, version int) error {
+
+	if _, err := g.require(ctx, internal.ScopeProductsDelete); err != nil {
+		return err
+	}
+
+	return g.service.DeleteProduct(ctx, id, version)
+}
+
+func (g *ProductServiceGuard) CalculateConsumerPrice(ctx context.Context, id ...int) ([]internal.Product, float64, error) {
+
+	if _, err := g.require(ctx, internal.ScopeProductsConsumerPrice); err != nil {
+		return nil, 0, err
+	}
+
+	return g.service.CalculateConsumerPrice(ctx, id...)
+}
+
+// checkFields checks that the principal can change each field of updated that
+// differs from current, with products:write or, if it is protected, its own permission
+func (g *ProductServiceGuard) checkFields(principal internal.Principal, current, updated internal.Product) error {
+
+	for _, field := range changedFields(current, updated) {
+		permission := internal.ScopeProductsWrite
+		if g.policy.Protected(field) {
+			permission = FieldPermission(field)
+		}
+		if !g.policy.Allows(principal, permission) {
+			return fmt.Errorf("%w: changing %s requires the %s permission", internal.ErrForbidden, field, permission)
+		}
+	}
+
+	return nil
+}
+
+// require returns the principal of the context if it has any of the permissions
+func (g *ProductServiceGuard) require(ctx context.Context, permissions ...string) (internal.Principal, error) {
+
+	principal, ok := internal.PrincipalFromContext(ctx)
+	if !ok {
+		return internal.Principal{}, fmt.Errorf("%w: the request is not authenticated", internal.ErrForbidden)
+	}
+
+	if !g.policy.AllowsAny(principal, permissions...) {
+		return internal.Principal{}, fmt.Errorf("%w: %s", internal.ErrForbidden, Requirement(permissions...))
+	}
+
+	return principal, nil
+}
+
+// changedFields lists the fields of updated that differ from current
+func changedFields(current, updated internal.Product) []internal.ProductField {
+
+	var fields []internal.ProductField
+	if current.Name != updated.Name {
+		fields = append(fields, internal.ProductFieldName)
+	}
+	if current.Quantity != updated.Quantity {
+		fields = append(fields, internal.ProductFieldQuantity)
+	}
+	if current.CodeValue != updated.CodeValue {
+		fields = append(fields, internal.ProductFieldCodeValue)
+	}
+	if current.IsPublished != updated.IsPublished {
+		fields = append(fields, internal.ProductFieldIsPublished)
+	}
+	if !current.Expiration.Equal(updated.Expiration) {
+		fields = append(fields, internal.ProductFieldExpiration)
+	}
+	if current.Price != updated.Price {
+		fields = append(fields, internal.ProductFieldPrice)
+	}
+
+	return fields
+}
@@ -0,0 +1,165 @@
+// Package rbac decides what each principal can do with the products. The
+// permissions are the scopes of internal: API keys are granted them directly
+// and the roles of the bearer tokens get them from a declarative Policy. The
+// policy is enforced twice, by the middleware on each route and by
+// ProductServiceGuard on each operation, so the rules hold whichever handler
+// reaches the service.
+package rbac
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"goweb/app/internal"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AllPermissions grants a role every permission
+const AllPermissions = "*"
+
+//go:embed default_policy.yaml
+var defaultPolicy []byte
+
+// Policy is the permissions of each role plus the product fields that need a
+// permission of their own, FieldPermission, to be changed. products:write
+// changes every other field.
+type Policy struct {
+	ProtectedFields []internal.ProductField `yaml:"protected_fields"`
+	Roles           map[string][]string     `yaml:"roles"`
+}
+
+// Default returns the embedded policy, see default_policy.yaml
+func Default() *Policy {
+	policy, err := Parse(defaultPolicy)
+	if err != nil {
+		panic(fmt.Sprintf("rbac: invalid default policy: %v", err))
+	}
+	return policy
+}
+
+// Load reads the policy of a YAML file
+func Load(path string) (*Policy, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading the rbac policy: %w", err)
+	}
+
+	policy, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("rbac policy %s: %w", path, err)
+	}
+
+	return policy, nil
+}
+
+// Parse decodes and validates a YAML policy. Unknown keys, fields and
+// permissions are errors, so a typo cannot silently deny or grant access.
+func Parse(data []byte) (*Policy, error) {
+
+	var policy Policy
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&policy); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	var errs []error
+	for _, field := range policy.ProtectedFields {
+		if !field.IsValid() || field == internal.ProductFieldID {
+			errs = append(errs, fmt.Errorf("protected_fields: unknown field %q", field))
+		}
+	}
+	for role, permissions := range policy.Roles {
+		for _, permission := range permissions {
+			if !policy.isKnown(permission) {
+				errs = append(errs, fmt.Errorf("roles.%s: unknown permission %q", role, permission))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return &policy, nil
+}
+
+// FieldPermission is the permission that changes a protected field, e.g. products:write:price
+func FieldPermission(field internal.ProductField) string {
+	return internal.ScopeProductsWrite + ":" + string(field)
+}
+
+// Allows reports whether the principal has the permission, either as a scope
+// of its API key or through one of its roles
+func (p *Policy) Allows(principal internal.Principal, permission string) bool {
+
+	if principal.HasScope(permission) {
+		return true
+	}
+
+	for _, role := range principal.Roles {
+		for _, granted := range p.Roles[role] {
+			if granted == permission || granted == AllPermissions {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// AllowsAny reports whether the principal has at least one of the permissions
+func (p *Policy) AllowsAny(principal internal.Principal, permissions ...string) bool {
+	for _, permission := range permissions {
+		if p.Allows(principal, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Requirement describes the permissions of a denied operation, e.g. "the
+// products:read permission is required"
+func Requirement(permissions ...string) string {
+	if len(permissions) == 1 {
+		return "the " + permissions[0] + " permission is required"
+	}
+	return "one of the permissions " + strings.Join(permissions, ", ") + " is required"
+}
+
+// Protected reports whether changing the field needs its FieldPermission
+func (p *Policy) Protected(field internal.ProductField) bool {
+	for _, protected := range p.ProtectedFields {
+		if protected == field {
+			return true
+		}
+	}
+	return false
+}
+
+// WritePermissions lists the permissions that change at least one field:
+// products:write and the permission of each protected field
+func (p *Policy) WritePermissions() []string {
+	permissions := []string{internal.ScopeProductsWrite}
+	for _, field := range p.ProtectedFields {
+		permissions = append(permissions, FieldPermission(field))
+	}
+	return permissions
+}
+
+// isKnown reports whether the permission is a scope, the permission of a
+// protected field or AllPermissions
+func (p *Policy) isKnown(permission string) bool {
+
+	if permission == AllPermissions || internal.IsValidScope(permission) {
+		return true
+	}
+
+	field, ok := strings.CutPrefix(permission, internal.ScopeProductsWrite+":")
+	return ok && p.Protected(internal.ProductField(field))
+}
@@ -0,0 +1,189 @@
+package rbac
+
+import (
+	"context"
+	"goweb/app/internal"
+	"goweb/app/internal/repository"
+	"goweb/app/internal/service"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	t.Run("La politica por defecto es valida", func(t *testing.T) {
+		// Act
+		policy := Default()
+
+		// Assert
+		require.Equal(t, []internal.ProductField{internal.ProductFieldPrice}, policy.ProtectedFields)
+		require.Equal(t, []string{"products:write", "products:write:price"}, policy.WritePermissions())
+	})
+
+	t.Run("Los permisos salen de los scopes y de los roles", func(t *testing.T) {
+		// Arrange
+		policy := Default()
+		apiKey := internal.Principal{Scopes: []string{internal.ScopeProductsRead}}
+		editor := internal.Principal{Roles: []string{internal.RoleEditor}}
+		admin := internal.Principal{Roles: []string{"unknown", internal.RoleAdmin}}
+
+		// Act & Assert
+		require.True(t, policy.Allows(apiKey, internal.ScopeProductsRead))
+		require.False(t, policy.Allows(apiKey, internal.ScopeProductsWrite))
+		require.True(t, policy.Allows(editor, internal.ScopeProductsWrite))
+		require.False(t, policy.Allows(editor, internal.ScopeProductsWritePrice))
+		require.True(t, policy.Allows(admin, internal.ScopeAPIKeysAdmin))
+		require.True(t, policy.AllowsAny(editor, policy.WritePermissions()...))
+	})
+
+	t.Run("Se carga una politica de un archivo", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "policy.yaml")
+		data := "protected_fields: [price, quantity]\nroles:\n  stock_keeper: [products:read, products:write:quantity]\n"
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+
+		// Act
+		policy, err := Load(path)
+
+		// Assert
+		require.NoError(t, err)
+		stockKeeper := internal.Principal{Roles: []string{"stock_keeper"}}
+		require.True(t, policy.Allows(stockKeeper, FieldPermission(internal.ProductFieldQuantity)))
+		require.False(t, policy.Allows(stockKeeper, internal.ScopeProductsWrite))
+	})
+
+	t.Run("Se rechazan los campos y permisos desconocidos", func(t *testing.T) {
+		// Act
+		_, err := Parse([]byte("protected_fields: [id, color]\nroles:\n  editor: [products:write, products:write:name, products:erase]\n"))
+		_, errKey := Parse([]byte("role:\n  editor: [products:read]\n"))
+
+		// Assert
+		require.EqualError(t, err, "protected_fields: unknown field \"id\"\n"+
+			"protected_fields: unknown field \"color\"\n"+
+			"roles.editor: unknown permission \"products:write:name\"\n"+
+			"roles.editor: unknown permission \"products:erase\"")
+		require.Error(t, errKey)
+	})
+}
+
+func TestProductServiceGuard(t *testing.T) {
+
+	// Arrange
+	repo := repository.NewRepositoryMap(map[int]internal.Product{
+		1: {ID: 1, Name: "Wine", Quantity: 10, CodeValue: "W1", IsPublished: true, Expiration: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Price: 100},
+		2: {ID: 2, Name: "Bread", Quantity: 5, CodeValue: "B1", IsPublished: false, Expiration: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC), Price: 20},
+	})
+	guard := NewProductServiceGuard(service.NewProductService(repo), Default())
+	as := func(roles ...string) context.Context {
+		return internal.ContextWithPrincipal(context.Background(), internal.Principal{Roles: roles})
+	}
+
+	t.Run("Sin principal no hay acceso", func(t *testing.T) {
+		// Act
+		_, err := guard.GetProductByID(context.Background(), 1)
+
+		// Assert
+		require.ErrorIs(t, err, internal.ErrForbidden)
+	})
+
+	t.Run("Los productos no publicados no existen para los viewers", func(t *testing.T) {
+		// Act
+		all, err := guard.GetAllProducts(as(internal.RoleViewer))
+		require.NoError(t, err)
+		page, err := guard.SearchProducts(as(internal.RoleViewer), internal.ProductQuery{})
+		require.NoError(t, err)
+		_, errHidden := guard.GetProductByID(as(internal.RoleViewer), 2)
+		hidden, errEditor := guard.GetProductByID(as(internal.RoleEditor), 2)
+
+		// Assert
+		require.Len(t, all, 1)
+		require.Equal(t, 1, page.Total)
+		require.ErrorIs(t, errHidden, internal.ErrProductNotFound)
+		require.NoError(t, errEditor)
+		require.Equal(t, "Bread", hidden.Name)
+	})
+
+	t.Run("Cada campo modificado necesita su permiso", func(t *testing.T) {
+		// Arrange
+		product, err := guard.GetProductByID(as(internal.RoleEditor), 1)
+		require.NoError(t, err)
+		renamed, repriced := product, product
+		renamed.Name = "Red Wine"
+		repriced.Price = 80
+
+		// Act
+		_, errEditorPrice := guard.UpdateProduct(as(internal.RoleEditor), repriced)
+		_, errManagerName := guard.UpdateProduct(as(internal.RolePricingManager), renamed)
+		_, errViewer := guard.UpdateProduct(as(internal.RoleViewer), product)
+		updated, errManagerPrice := guard.UpdateProduct(as(internal.RolePricingManager), repriced)
+
+		// Assert
+		require.ErrorIs(t, errEditorPrice, internal.ErrForbidden)
+		require.EqualError(t, errManagerName, "forbidden: changing name requires the products:write permission")
+		require.EqualError(t, errViewer, "forbidden: one of the permissions products:write, products:write:price is required")
+		require.NoError(t, errManagerPrice)
+		require.Equal(t, 80.0, updated.Price)
+	})
+
+	t.Run("Crear un producto con precio necesita el permiso del precio", func(t *testing.T) {
+		// Arrange
+		product := internal.Product{Name: "Cheese", Quantity: 3, CodeValue: "C1", IsPublished: true, Expiration: time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), Price: 30}
+		unpriced := product
+		unpriced.CodeValue, unpriced.Price = "C2", 0
+
+		// Act
+		_, errEditorPrice := guard.CreateProduct(as(internal.RoleEditor), product)
+		created, errEditor := guard.CreateProduct(as(internal.RoleEditor), unpriced)
+		priced, errAdmin := guard.CreateProduct(as(internal.RoleAdmin), product)
+
+		// Assert
+		require.EqualError(t, errEditorPrice, "forbidden: changing price requires the products:write:price permission")
+		require.NoError(t, errEditor)
+		require.Equal(t, 0.0, created.Price)
+		require.NoError(t, errAdmin)
+		require.Equal(t, 30.0, priced.Price)
+	})
+	t.Run("Una modificacion sin version no pisa un cambio de precio concurrente", func(t *testing.T) {
+		// Arrange
+		racing := &racingService{ProductService: service.NewProductService(repo)}
+		guard := NewProductServiceGuard(racing, Default())
+		product, err := guard.GetProductByID(as(internal.RoleEditor), 1)
+		require.NoError(t, err)
+		renamed := product
+		renamed.Name, renamed.Version = "Rose Wine", 0
+		// the pricing manager changes the price right after the guard reads the product
+		racing.race = func() {
+			repriced := product
+			repriced.Price = 150
+			_, err := repo.UpdateProduct(context.Background(), repriced)
+			require.NoError(t, err)
+		}
+
+		// Act
+		_, err = guard.UpdateProduct(as(internal.RoleEditor), renamed)
+		stored, _ := repo.GetProductByID(context.Background(), 1)
+
+		// Assert
+		require.ErrorIs(t, err, internal.ErrProductVersionMismatch)
+		require.Equal(t, 150.0, stored.Price)
+	})
+}
+
+// racingService runs race once, right after the next GetProductByID, as a concurrent request would
+type racingService struct {
+	internal.ProductService
+	race func()
+}
+
+func (s *racingService) GetProductByID(ctx context.Context, id int) (internal.Product, error) {
+	product, err := s.ProductService.GetProductByID(ctx, id)
+	if s.race != nil {
+		race := s.race
+		s.race = nil
+		race()
+	}
+	return product, err
+}
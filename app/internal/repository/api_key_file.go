@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goweb/app/internal"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyDTO is an API key as stored in the keys file
+type APIKeyDTO struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"hash"`
+	Scopes    string     `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// apiKeysFileDTO is the content of the keys file. LastID is the highest id
+// ever assigned, so the ids of deleted keys are not reused.
+type apiKeysFileDTO struct {
+	LastID int         `json:"last_id"`
+	Keys   []APIKeyDTO `json:"keys"`
+}
+
+// implements the APIKeyRepository interface, storing the keys as a JSON object
+// in a file next to the products of the file and wal backends. Like
+// RepositoryFile it writes atomically under an advisory lock, and keeps the
+// parsed file until it changes, since the keys are read on every request.
+type APIKeyRepositoryFile struct {
+	path  string
+	fsync FsyncPolicy
+
+	mu   sync.Mutex
+	file apiKeysFileDTO
+	stat os.FileInfo
+}
+
+func NewAPIKeyRepositoryFile(path string, fsync FsyncPolicy) *APIKeyRepositoryFile {
+	return &APIKeyRepositoryFile{
+		path:  path,
+		fsync: fsync,
+	}
+}
+
+// load returns the keys of the file, from the cache if the file did not change.
+// The caller must hold r.mu and the file lock.
+func (r *APIKeyRepositoryFile) load() (apiKeysFileDTO, error) {
+
+	info, err := os.Stat(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return apiKeysFileDTO{}, nil
+	}
+	if err != nil {
+		return apiKeysFileDTO{}, fmt.Errorf("reading the api keys file: %w", err)
+	}
+	if r.stat != nil && os.SameFile(info, r.stat) && info.ModTime().Equal(r.stat.ModTime()) && info.Size() == r.stat.Size() {
+		return r.file, nil
+	}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return apiKeysFileDTO{}, fmt.Errorf("reading the api keys file: %w", err)
+	}
+	var file apiKeysFileDTO
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &file); err != nil {
+			return apiKeysFileDTO{}, fmt.Errorf("decoding the api keys file: %w", err)
+		}
+	}
+
+	r.file, r.stat = file, info
+	return file, nil
+}
+
+// read runs fn with the current keys under the shared file lock
+func (r *APIKeyRepositoryFile) read(fn func(keys []APIKeyDTO) error) error {
+
+	unlock, err := lockFile(r.path+".lock", false)
+	if err != nil {
+		return fmt.Errorf("locking the api keys file: %w", err)
+	}
+	defer unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	file, err := r.load()
+	if err != nil {
+		return err
+	}
+	return fn(file.Keys)
+}
+
+// write runs fn with a copy of the current file under the exclusive lock and
+// saves the file it returns
+func (r *APIKeyRepositoryFile) write(fn func(file apiKeysFileDTO) (apiKeysFileDTO, error)) error {
+
+	unlock, err := lockFile(r.path+".lock", true)
+	if err != nil {
+		return fmt.Errorf("locking the api keys file: %w", err)
+	}
+	defer unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	file, err := r.load()
+	if err != nil {
+		return err
+	}
+	file.Keys = append([]APIKeyDTO{}, file.Keys...)
+	updated, err := fn(file)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return fmt.Errorf("encoding the api keys: %w", err)
+	}
+	if err := writeFileAtomic(r.path, data, r.fsync); err != nil {
+		return fmt.Errorf("saving the api keys file: %w", err)
+	}
+	// the next load reads the new file
+	r.stat = nil
+
+	return nil
+}
+
+// GetAllAPIKeys returns all keys, sorted by id
+func (r *APIKeyRepositoryFile) GetAllAPIKeys(ctx context.Context) ([]internal.APIKey, error) {
+
+	keys := []internal.APIKey{}
+	err := r.read(func(dtos []APIKeyDTO) error {
+		for _, dto := range dtos {
+			keys = append(keys, apiKeyFromDTO(dto))
+		}
+		return nil
+	})
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys, err
+}
+
+// GetAPIKeyByHash returns the key whose secret has the hash
+func (r *APIKeyRepositoryFile) GetAPIKeyByHash(ctx context.Context, hash string) (internal.APIKey, error) {
+
+	var key internal.APIKey
+	err := r.read(func(dtos []APIKeyDTO) error {
+		for _, dto := range dtos {
+			if dto.Hash == hash {
+				key = apiKeyFromDTO(dto)
+				return nil
+			}
+		}
+		return internal.ErrAPIKeyNotFound
+	})
+
+	return key, err
+}
+
+// AddAPIKey stores a key with the next id
+func (r *APIKeyRepositoryFile) AddAPIKey(ctx context.Context, key internal.APIKey) (internal.APIKey, error) {
+
+	err := r.write(func(file apiKeysFileDTO) (apiKeysFileDTO, error) {
+		file.LastID++
+		key.ID = file.LastID
+		file.Keys = append(file.Keys, apiKeyToDTO(key))
+		return file, nil
+	})
+	if err != nil {
+		return internal.APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// DeleteAPIKey deletes a key
+func (r *APIKeyRepositoryFile) DeleteAPIKey(ctx context.Context, id int) error {
+
+	return r.write(func(file apiKeysFileDTO) (apiKeysFileDTO, error) {
+		for i, dto := range file.Keys {
+			if dto.ID == id {
+				file.Keys = append(file.Keys[:i], file.Keys[i+1:]...)
+				return file, nil
+			}
+		}
+		return file, internal.ErrAPIKeyNotFound
+	})
+}
+
+func apiKeyToDTO(key internal.APIKey) APIKeyDTO {
+	var expiresAt *time.Time
+	if !key.ExpiresAt.IsZero() {
+		expiresAt = &key.ExpiresAt
+	}
+	return APIKeyDTO{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Hash:      key.Hash,
+		Scopes:    strings.Join(key.Scopes, " "),
+		ExpiresAt: expiresAt,
+		CreatedAt: key.CreatedAt,
+	}
+}
+
+func apiKeyFromDTO(dto APIKeyDTO) internal.APIKey {
+	var expiresAt time.Time
+	if dto.ExpiresAt != nil {
+		expiresAt = *dto.ExpiresAt
+	}
+	return internal.APIKey{
+		ID:        dto.ID,
+		Name:      dto.Name,
+		Prefix:    dto.Prefix,
+		Hash:      dto.Hash,
+		Scopes:    strings.Fields(dto.Scopes),
+		ExpiresAt: expiresAt,
+		CreatedAt: dto.CreatedAt,
+	}
+}
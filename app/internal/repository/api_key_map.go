@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"goweb/app/internal"
+	"sort"
+	"sync"
+)
+
+// implements the APIKeyRepository interface in memory, so the keys are lost on
+// restart. It is safe for concurrent use.
+type APIKeyRepositoryMap struct {
+	keys   map[int]internal.APIKey
+	lastID int
+	mu     sync.RWMutex
+}
+
+func NewAPIKeyRepositoryMap() *APIKeyRepositoryMap {
+	return &APIKeyRepositoryMap{
+		keys: make(map[int]internal.APIKey),
+	}
+}
+
+// GetAllAPIKeys returns all keys, sorted by id
+func (r *APIKeyRepositoryMap) GetAllAPIKeys(ctx context.Context) ([]internal.APIKey, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]internal.APIKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns the key whose secret has the hash
+func (r *APIKeyRepositoryMap) GetAPIKeyByHash(ctx context.Context, hash string) (internal.APIKey, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.keys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+
+	return internal.APIKey{}, internal.ErrAPIKeyNotFound
+}
+
+// AddAPIKey stores a key with the next id
+func (r *APIKeyRepositoryMap) AddAPIKey(ctx context.Context, key internal.APIKey) (internal.APIKey, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastID++
+	key.ID = r.lastID
+	r.keys[key.ID] = key
+
+	return key, nil
+}
+
+// DeleteAPIKey deletes a key
+func (r *APIKeyRepositoryMap) DeleteAPIKey(ctx context.Context, id int) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.keys[id]; !ok {
+		return internal.ErrAPIKeyNotFound
+	}
+	delete(r.keys, id)
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"goweb/app/internal"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+// apiKeyRepositories returns a constructor of an empty repository for every
+// backend, the SQL servers are found as productRepositories does
+func apiKeyRepositories(t *testing.T) map[string]func(t *testing.T) internal.APIKeyRepository {
+
+	repositories := map[string]func(t *testing.T) internal.APIKeyRepository{
+		"map": func(t *testing.T) internal.APIKeyRepository {
+			return NewAPIKeyRepositoryMap()
+		},
+		"file": func(t *testing.T) internal.APIKeyRepository {
+			return NewAPIKeyRepositoryFile(filepath.Join(t.TempDir(), "api_keys.json"), FsyncNever)
+		},
+		"sqlite": func(t *testing.T) internal.APIKeyRepository {
+			db, err := NewSQLiteConnection(":memory:")
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			repo, err := NewAPIKeyRepositorySQLite(db)
+			require.NoError(t, err)
+			return repo
+		},
+	}
+
+	repositories["postgres"] = func(t *testing.T) internal.APIKeyRepository {
+		db, err := NewPostgresConnection(postgresTestDSN(t))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		repo, err := NewAPIKeyRepositoryPostgres(db)
+		require.NoError(t, err)
+		_, err = db.Exec("TRUNCATE api_keys RESTART IDENTITY")
+		require.NoError(t, err)
+		return repo
+	}
+
+	repositories["mysql"] = func(t *testing.T) internal.APIKeyRepository {
+		config, err := mysql.ParseDSN(mysqlTestDSN(t))
+		require.NoError(t, err)
+		db, err := NewMySQLConnection(*config)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		// the table is created by the migrations, run by productRepositories
+		_, err = db.Exec("DELETE FROM api_keys")
+		require.NoError(t, err)
+		return NewAPIKeyRepositorySQL(db)
+	}
+
+	return repositories
+}
+
+// TestAPIKeyRepositoryConformance checks that every backend stores the keys the same way
+func TestAPIKeyRepositoryConformance(t *testing.T) {
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	keys := []internal.APIKey{
+		{Name: "reader", Prefix: "gwk_aaaaaa", Hash: "hash-reader", Scopes: []string{internal.ScopeProductsRead}, CreatedAt: created},
+		{Name: "writer", Prefix: "gwk_bbbbbb", Hash: "hash-writer", Scopes: []string{internal.ScopeProductsRead, internal.ScopeProductsWrite},
+			ExpiresAt: created.Add(24 * time.Hour), CreatedAt: created},
+	}
+
+	for name, newRepo := range apiKeyRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newRepo(t)
+
+			// add
+			for i, key := range keys {
+				added, err := repo.AddAPIKey(ctx, key)
+				require.NoError(t, err)
+				require.Equal(t, i+1, added.ID)
+			}
+
+			// get by hash
+			key, err := repo.GetAPIKeyByHash(ctx, "hash-writer")
+			require.NoError(t, err)
+			expected := keys[1]
+			expected.ID = 2
+			require.Equal(t, expected, key)
+
+			_, err = repo.GetAPIKeyByHash(ctx, "missing")
+			require.ErrorIs(t, err, internal.ErrAPIKeyNotFound)
+
+			// get all
+			all, err := repo.GetAllAPIKeys(ctx)
+			require.NoError(t, err)
+			require.Len(t, all, 2)
+			require.Equal(t, expected, all[1])
+
+			// delete
+			require.NoError(t, repo.DeleteAPIKey(ctx, 2))
+			require.ErrorIs(t, repo.DeleteAPIKey(ctx, 2), internal.ErrAPIKeyNotFound)
+
+			// the ids are not reused
+			added, err := repo.AddAPIKey(ctx, keys[1])
+			require.NoError(t, err)
+			require.Equal(t, 3, added.ID)
+			all, err = repo.GetAllAPIKeys(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []int{1, 3}, []int{all[0].ID, all[1].ID})
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"goweb/app/internal"
+	"strings"
+)
+
+// the api_keys table of SQLite and Postgres, MySQL creates it with a migration
+const (
+	apiKeysSQLiteSchema = `CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		expires_at DATETIME NULL,
+		created_at DATETIME NOT NULL
+	)`
+	apiKeysPostgresSchema = `CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		expires_at TIMESTAMPTZ NULL,
+		created_at TIMESTAMPTZ NOT NULL
+	)`
+)
+
+const apiKeySelect = "SELECT id, name, prefix, hash, scopes, expires_at, created_at FROM api_keys"
+
+// implements the APIKeyRepository interface on an api_keys table. The scopes
+// are stored separated by spaces.
+type APIKeyRepositorySQL struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// NewAPIKeyRepositorySQL uses the api_keys table of MySQL, created by the migrations
+func NewAPIKeyRepositorySQL(db *sql.DB) *APIKeyRepositorySQL {
+	return &APIKeyRepositorySQL{db: db, dialect: mysqlDialect}
+}
+
+// NewAPIKeyRepositorySQLite creates the api_keys table if it does not exist yet
+func NewAPIKeyRepositorySQLite(db *sql.DB) (*APIKeyRepositorySQL, error) {
+	if _, err := db.Exec(apiKeysSQLiteSchema); err != nil {
+		return nil, fmt.Errorf("creating the api_keys table: %w", err)
+	}
+	return &APIKeyRepositorySQL{db: db, dialect: sqliteDialect}, nil
+}
+
+// NewAPIKeyRepositoryPostgres creates the api_keys table if it does not exist yet
+func NewAPIKeyRepositoryPostgres(db *sql.DB) (*APIKeyRepositorySQL, error) {
+	if _, err := db.Exec(apiKeysPostgresSchema); err != nil {
+		return nil, fmt.Errorf("creating the api_keys table: %w", err)
+	}
+	return &APIKeyRepositorySQL{db: db, dialect: postgresDialect}, nil
+}
+
+// GetAllAPIKeys returns all keys, sorted by id
+func (s *APIKeyRepositorySQL) GetAllAPIKeys(ctx context.Context) ([]internal.APIKey, error) {
+
+	rows, err := s.db.QueryContext(ctx, apiKeySelect+" ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("querying the api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []internal.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading the api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns the key whose secret has the hash
+func (s *APIKeyRepositorySQL) GetAPIKeyByHash(ctx context.Context, hash string) (internal.APIKey, error) {
+
+	row := s.db.QueryRowContext(ctx, s.dialect.bind(apiKeySelect+" WHERE hash = ?"), hash)
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return internal.APIKey{}, internal.ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// AddAPIKey inserts a key
+func (s *APIKeyRepositorySQL) AddAPIKey(ctx context.Context, key internal.APIKey) (internal.APIKey, error) {
+
+	statement := "INSERT INTO api_keys (name, prefix, hash, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+	expiresAt := sql.NullTime{Time: key.ExpiresAt, Valid: !key.ExpiresAt.IsZero()}
+	args := []any{key.Name, key.Prefix, key.Hash, strings.Join(key.Scopes, " "), expiresAt, key.CreatedAt}
+
+	// get the id of the inserted key
+	if s.dialect.returningID {
+		err := s.db.QueryRowContext(ctx, s.dialect.bind(statement+" RETURNING id"), args...).Scan(&key.ID)
+		if err != nil {
+			return internal.APIKey{}, fmt.Errorf("inserting the api key: %w", err)
+		}
+		return key, nil
+	}
+
+	result, err := s.db.ExecContext(ctx, s.dialect.bind(statement), args...)
+	if err != nil {
+		return internal.APIKey{}, fmt.Errorf("inserting the api key: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return internal.APIKey{}, fmt.Errorf("getting the last inserted id: %w", err)
+	}
+
+	key.ID = int(id)
+	return key, nil
+}
+
+// DeleteAPIKey deletes a key
+func (s *APIKeyRepositorySQL) DeleteAPIKey(ctx context.Context, id int) error {
+
+	res, err := s.db.ExecContext(ctx, s.dialect.bind("DELETE FROM api_keys WHERE id = ?"), id)
+	if err != nil {
+		return fmt.Errorf("deleting the api key %d: %w", id, err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting the rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return internal.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// scanAPIKey reads a row with the columns of apiKeySelect
+func scanAPIKey(row scanner) (internal.APIKey, error) {
+
+	var key internal.APIKey
+	var scopes string
+	var expiresAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &scopes, &expiresAt, &key.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return internal.APIKey{}, err
+		}
+		return internal.APIKey{}, fmt.Errorf("reading the api key: %w", err)
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		key.ExpiresAt = expiresAt.Time.UTC()
+	}
+	key.CreatedAt = key.CreatedAt.UTC()
+
+	return key, nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces the file at path with data. The data is written to a
+// temporary file in the same directory which is then renamed over path, so
+// readers see either the old or the new contents, never a mix of both.
+func writeFileAtomic(path string, data []byte, fsync FsyncPolicy) error {
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if fsync == FsyncAlways {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if fsync == FsyncAlways {
+		return syncDir(dir)
+	}
+
+	return nil
+}
@@ -5,6 +5,9 @@ import (
 	"time"
 )
 
+// DefaultSeedFile is the catalog loaded by the map and slice repositories when no data is given
+const DefaultSeedFile = "app/data/products.json"
+
 type ProductDTO struct {
 	ID          int     `json:"id"`
 	Name        string  `json:"name"`
@@ -13,11 +16,12 @@ type ProductDTO struct {
 	IsPublished bool    `json:"is_published"`
 	Expiration  string  `json:"expiration"`
 	Price       float64 `json:"price"`
+	Version     int     `json:"version,omitempty"`
 }
 
 func internalsToDTOs(products []internal.Product) []ProductDTO {
 
-	var productsDTO []ProductDTO
+	productsDTO := make([]ProductDTO, 0, len(products))
 
 	for _, product := range products {
 		productsDTO = append(productsDTO, ProductDTO{
@@ -28,6 +32,7 @@ func internalsToDTOs(products []internal.Product) []ProductDTO {
 			IsPublished: product.IsPublished,
 			Quantity:    product.Quantity,
 			Price:       product.Price,
+			Version:     product.Version,
 		})
 	}
 
@@ -48,6 +53,8 @@ func dtosToInternals(products []ProductDTO) []internal.Product {
 			IsPublished: product.IsPublished,
 			Quantity:    product.Quantity,
 			Price:       product.Price,
+			// the products of the seed files and older catalogs start at the first version
+			Version: max(product.Version, 1),
 		})
 	}
 
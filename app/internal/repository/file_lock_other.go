@@ -0,0 +1,14 @@
+//go:build !unix
+
+package repository
+
+// lockFile is a no-op where flock is not available, so only a single process
+// should use the products file there
+func lockFile(path string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
+
+// syncDir is a no-op, directories cannot be synced on these platforms
+func syncDir(dir string) error {
+	return nil
+}
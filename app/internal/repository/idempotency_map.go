@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"goweb/app/internal"
+	"sync"
+	"time"
+)
+
+// idempotencySweepEvery is how often the idempotency stores drop the expired records
+const idempotencySweepEvery = time.Minute
+
+// idempotencyKey identifies a record, the keys of each principal are apart
+type idempotencyKey struct {
+	principal string
+	key       string
+}
+
+// implements the IdempotencyStore interface in memory, so each instance of
+// the server has its own keys and they are lost on restart. It is safe for
+// concurrent use.
+type IdempotencyStoreMap struct {
+	records   map[idempotencyKey]internal.IdempotencyRecord
+	lastSweep time.Time
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+func NewIdempotencyStoreMap() *IdempotencyStoreMap {
+	return &IdempotencyStoreMap{
+		records: make(map[idempotencyKey]internal.IdempotencyRecord),
+		now:     time.Now,
+	}
+}
+
+// Reserve stores the record unless the principal has a record for the key that has not expired
+func (s *IdempotencyStoreMap) Reserve(ctx context.Context, record internal.IdempotencyRecord) (internal.IdempotencyRecord, bool, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	key := idempotencyKey{principal: record.Principal, key: record.Key}
+	if stored, ok := s.records[key]; ok && !stored.Expired(now) {
+		return stored, false, nil
+	}
+
+	record.Done = false
+	s.records[key] = record
+	return record, true, nil
+}
+
+// Complete stores the response of a reserved record
+func (s *IdempotencyStoreMap) Complete(ctx context.Context, record internal.IdempotencyRecord) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.Done = true
+	s.records[idempotencyKey{principal: record.Principal, key: record.Key}] = record
+	return nil
+}
+
+// Release deletes a record
+func (s *IdempotencyStoreMap) Release(ctx context.Context, principal, key string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, idempotencyKey{principal: principal, key: key})
+	return nil
+}
+
+// sweep drops the expired records, at most once every idempotencySweepEvery
+func (s *IdempotencyStoreMap) sweep(now time.Time) {
+
+	if now.Sub(s.lastSweep) < idempotencySweepEvery {
+		return
+	}
+	s.lastSweep = now
+
+	for key, record := range s.records {
+		if record.Expired(now) {
+			delete(s.records, key)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goweb/app/internal"
+	"sync"
+	"time"
+)
+
+// the idempotency_keys table of SQLite and Postgres, MySQL creates it with a
+// migration. expires_at is in unix seconds, so every engine compares it the same way.
+const (
+	idempotencyKeysSQLiteSchema = `CREATE TABLE IF NOT EXISTS idempotency_keys (
+		principal TEXT NOT NULL,
+		idempotency_key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		done BOOLEAN NOT NULL,
+		status INTEGER NOT NULL,
+		header TEXT NOT NULL,
+		body BLOB NOT NULL,
+		expires_at BIGINT NOT NULL,
+		PRIMARY KEY (principal, idempotency_key)
+	)`
+	idempotencyKeysPostgresSchema = `CREATE TABLE IF NOT EXISTS idempotency_keys (
+		principal TEXT NOT NULL,
+		idempotency_key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		done BOOLEAN NOT NULL,
+		status INTEGER NOT NULL,
+		header TEXT NOT NULL,
+		body BYTEA NOT NULL,
+		expires_at BIGINT NOT NULL,
+		PRIMARY KEY (principal, idempotency_key)
+	)`
+)
+
+const idempotencySelect = "SELECT principal, idempotency_key, request_hash, done, status, header, body, expires_at FROM idempotency_keys"
+
+// the INSERT of a record that leaves the table as it was when the principal
+// already has a record for the key, instead of failing
+const (
+	idempotencyInsert = "INSERT INTO idempotency_keys (principal, idempotency_key, request_hash, done, status, header, body, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	// mysql has no ON CONFLICT, setting a column to itself does not change the row
+	idempotencyInsertMySQL      = idempotencyInsert + " ON DUPLICATE KEY UPDATE idempotency_key = idempotency_key"
+	idempotencyInsertOnConflict = idempotencyInsert + " ON CONFLICT DO NOTHING"
+)
+
+// implements the IdempotencyStore interface on an idempotency_keys table, so
+// the instances of the server sharing the database share the keys. The
+// headers are stored as a JSON object.
+type IdempotencyStoreSQL struct {
+	db      *sql.DB
+	dialect sqlDialect
+	// insert is the INSERT of the engine that ignores a taken key
+	insert    string
+	now       func() time.Time
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewIdempotencyStoreSQL uses the idempotency_keys table of MySQL, created by the migrations
+func NewIdempotencyStoreSQL(db *sql.DB) *IdempotencyStoreSQL {
+	return &IdempotencyStoreSQL{db: db, dialect: mysqlDialect, insert: idempotencyInsertMySQL, now: time.Now}
+}
+
+// NewIdempotencyStoreSQLite creates the idempotency_keys table if it does not exist yet
+func NewIdempotencyStoreSQLite(db *sql.DB) (*IdempotencyStoreSQL, error) {
+	if _, err := db.Exec(idempotencyKeysSQLiteSchema); err != nil {
+		return nil, fmt.Errorf("creating the idempotency_keys table: %w", err)
+	}
+	return &IdempotencyStoreSQL{db: db, dialect: sqliteDialect, insert: idempotencyInsertOnConflict, now: time.Now}, nil
+}
+
+// NewIdempotencyStorePostgres creates the idempotency_keys table if it does not exist yet
+func NewIdempotencyStorePostgres(db *sql.DB) (*IdempotencyStoreSQL, error) {
+	if _, err := db.Exec(idempotencyKeysPostgresSchema); err != nil {
+		return nil, fmt.Errorf("creating the idempotency_keys table: %w", err)
+	}
+	return &IdempotencyStoreSQL{db: db, dialect: postgresDialect, insert: idempotencyInsertOnConflict, now: time.Now}, nil
+}
+
+// Reserve inserts the record unless the principal has a record for the key
+// that has not expired. The insert is what decides between concurrent
+// requests, only one of them inserts the row.
+func (s *IdempotencyStoreSQL) Reserve(ctx context.Context, record internal.IdempotencyRecord) (internal.IdempotencyRecord, bool, error) {
+
+	now := s.now()
+	if err := s.sweep(ctx, now); err != nil {
+		return internal.IdempotencyRecord{}, false, err
+	}
+
+	record.Done = false
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return internal.IdempotencyRecord{}, false, fmt.Errorf("encoding the headers: %w", err)
+	}
+	if record.Body == nil {
+		record.Body = []byte{}
+	}
+
+	// the stored record can be released or expire before it is read, then the insert is tried again
+	for attempt := 0; attempt < 2; attempt++ {
+		// 1. an expired record is the same as no record
+		_, err := s.db.ExecContext(ctx, s.dialect.bind("DELETE FROM idempotency_keys WHERE principal = ? AND idempotency_key = ? AND expires_at <= ?"),
+			record.Principal, record.Key, now.Unix())
+		if err != nil {
+			return internal.IdempotencyRecord{}, false, fmt.Errorf("deleting the expired idempotency key: %w", err)
+		}
+
+		// 2. insert the record
+		res, err := s.db.ExecContext(ctx, s.dialect.bind(s.insert),
+			record.Principal, record.Key, record.RequestHash, false, record.Status, string(header), record.Body, record.ExpiresAt.Unix())
+		if err != nil {
+			return internal.IdempotencyRecord{}, false, fmt.Errorf("inserting the idempotency key: %w", err)
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return internal.IdempotencyRecord{}, false, fmt.Errorf("getting the rows affected: %w", err)
+		}
+		if rowsAffected > 0 {
+			return record, true, nil
+		}
+
+		// 3. the key is taken, read its record
+		row := s.db.QueryRowContext(ctx, s.dialect.bind(idempotencySelect+" WHERE principal = ? AND idempotency_key = ?"), record.Principal, record.Key)
+		stored, err := scanIdempotencyRecord(row)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return internal.IdempotencyRecord{}, false, err
+		}
+		return stored, false, nil
+	}
+
+	return internal.IdempotencyRecord{}, false, fmt.Errorf("reserving the idempotency key %q: the key keeps changing", record.Key)
+}
+
+// Complete stores the response of a reserved record
+func (s *IdempotencyStoreSQL) Complete(ctx context.Context, record internal.IdempotencyRecord) error {
+
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return fmt.Errorf("encoding the headers: %w", err)
+	}
+	if record.Body == nil {
+		record.Body = []byte{}
+	}
+
+	statement := "UPDATE idempotency_keys SET done = ?, status = ?, header = ?, body = ?, expires_at = ? WHERE principal = ? AND idempotency_key = ?"
+	_, err = s.db.ExecContext(ctx, s.dialect.bind(statement),
+		true, record.Status, string(header), record.Body, record.ExpiresAt.Unix(), record.Principal, record.Key)
+	if err != nil {
+		return fmt.Errorf("completing the idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Release deletes a record
+func (s *IdempotencyStoreSQL) Release(ctx context.Context, principal, key string) error {
+
+	_, err := s.db.ExecContext(ctx, s.dialect.bind("DELETE FROM idempotency_keys WHERE principal = ? AND idempotency_key = ?"), principal, key)
+	if err != nil {
+		return fmt.Errorf("releasing the idempotency key: %w", err)
+	}
+	return nil
+}
+
+// sweep deletes the expired records, at most once every idempotencySweepEvery
+func (s *IdempotencyStoreSQL) sweep(ctx context.Context, now time.Time) error {
+
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < idempotencySweepEvery {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, s.dialect.bind("DELETE FROM idempotency_keys WHERE expires_at <= ?"), now.Unix())
+	if err != nil {
+		return fmt.Errorf("deleting the expired idempotency keys: %w", err)
+	}
+	return nil
+}
+
+// scanIdempotencyRecord reads a row with the columns of idempotencySelect
+func scanIdempotencyRecord(row scanner) (internal.IdempotencyRecord, error) {
+
+	var record internal.IdempotencyRecord
+	var header string
+	var expiresAt int64
+	err := row.Scan(&record.Principal, &record.Key, &record.RequestHash, &record.Done, &record.Status, &header, &record.Body, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return internal.IdempotencyRecord{}, err
+		}
+		return internal.IdempotencyRecord{}, fmt.Errorf("reading the idempotency key: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(header), &record.Header); err != nil {
+		return internal.IdempotencyRecord{}, fmt.Errorf("decoding the headers: %w", err)
+	}
+	record.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+
+	return record, nil
+}
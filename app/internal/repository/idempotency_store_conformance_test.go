@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"goweb/app/internal"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+// idempotencyStores returns a constructor of an empty store for every backend
+// with its clock at now, the SQL servers are found as productRepositories does
+func idempotencyStores(t *testing.T, now func() time.Time) map[string]func(t *testing.T) internal.IdempotencyStore {
+
+	stores := map[string]func(t *testing.T) internal.IdempotencyStore{
+		"map": func(t *testing.T) internal.IdempotencyStore {
+			store := NewIdempotencyStoreMap()
+			store.now = now
+			return store
+		},
+		"sqlite": func(t *testing.T) internal.IdempotencyStore {
+			db, err := NewSQLiteConnection(":memory:")
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			store, err := NewIdempotencyStoreSQLite(db)
+			require.NoError(t, err)
+			store.now = now
+			return store
+		},
+	}
+
+	stores["postgres"] = func(t *testing.T) internal.IdempotencyStore {
+		db, err := NewPostgresConnection(postgresTestDSN(t))
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		store, err := NewIdempotencyStorePostgres(db)
+		require.NoError(t, err)
+		_, err = db.Exec("TRUNCATE idempotency_keys")
+		require.NoError(t, err)
+		store.now = now
+		return store
+	}
+
+	stores["mysql"] = func(t *testing.T) internal.IdempotencyStore {
+		config, err := mysql.ParseDSN(mysqlTestDSN(t))
+		require.NoError(t, err)
+		db, err := NewMySQLConnection(*config)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		// the table is created by the migrations, run by productRepositories
+		_, err = db.Exec("DELETE FROM idempotency_keys")
+		require.NoError(t, err)
+		store := NewIdempotencyStoreSQL(db)
+		store.now = now
+		return store
+	}
+
+	return stores
+}
+
+// TestIdempotencyStoreConformance checks that every backend keeps the keys the same way
+func TestIdempotencyStoreConformance(t *testing.T) {
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := start
+	clock := func() time.Time { return now }
+
+	record := internal.IdempotencyRecord{Principal: "apikey:1", Key: "key-1", RequestHash: "hash-1", ExpiresAt: start.Add(time.Hour)}
+
+	for name, newStore := range idempotencyStores(t, clock) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now = start
+			store := newStore(t)
+
+			t.Run("la primera request reserva la key", func(t *testing.T) {
+				// Act
+				stored, reserved, err := store.Reserve(ctx, record)
+
+				// Assert
+				require.NoError(t, err)
+				require.True(t, reserved)
+				require.False(t, stored.Done)
+			})
+
+			t.Run("mientras se sirve, la key devuelve el record sin respuesta", func(t *testing.T) {
+				// Arrange
+				retry := record
+				retry.RequestHash = "hash-2"
+
+				// Act
+				stored, reserved, err := store.Reserve(ctx, retry)
+
+				// Assert
+				require.NoError(t, err)
+				require.False(t, reserved)
+				require.False(t, stored.Done)
+				require.Equal(t, "hash-1", stored.RequestHash)
+			})
+
+			t.Run("completada, la key devuelve la respuesta", func(t *testing.T) {
+				// Arrange
+				completed := record
+				completed.Status = 201
+				completed.Header = map[string]string{"Content-Type": "application/json", "ETag": `"1"`}
+				completed.Body = []byte(`{"id":1}`)
+				require.NoError(t, store.Complete(ctx, completed))
+
+				// Act
+				stored, reserved, err := store.Reserve(ctx, record)
+
+				// Assert
+				require.NoError(t, err)
+				require.False(t, reserved)
+				require.True(t, stored.Done)
+				require.Equal(t, 201, stored.Status)
+				require.Equal(t, completed.Header, stored.Header)
+				require.Equal(t, `{"id":1}`, string(stored.Body))
+				require.True(t, stored.ExpiresAt.Equal(record.ExpiresAt))
+			})
+
+			t.Run("cada principal tiene sus keys", func(t *testing.T) {
+				// Arrange
+				other := record
+				other.Principal = "apikey:2"
+
+				// Act
+				_, reserved, err := store.Reserve(ctx, other)
+
+				// Assert
+				require.NoError(t, err)
+				require.True(t, reserved)
+			})
+
+			t.Run("una key liberada se puede volver a reservar", func(t *testing.T) {
+				// Arrange
+				require.NoError(t, store.Release(ctx, "apikey:2", record.Key))
+				other := record
+				other.Principal = "apikey:2"
+
+				// Act
+				_, reserved, err := store.Reserve(ctx, other)
+
+				// Assert
+				require.NoError(t, err)
+				require.True(t, reserved)
+			})
+
+			t.Run("una key vencida se puede usar para otra request", func(t *testing.T) {
+				// Arrange
+				now = record.ExpiresAt
+				later := record
+				later.RequestHash = "hash-3"
+				later.ExpiresAt = now.Add(time.Hour)
+
+				// Act
+				stored, reserved, err := store.Reserve(ctx, later)
+
+				// Assert
+				require.NoError(t, err)
+				require.True(t, reserved)
+				require.Equal(t, "hash-3", stored.RequestHash)
+			})
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// tokenizeName splits a product name into lowercase tokens without accents,
+// so "Café Crème" and "cafe creme" produce the same tokens
+func tokenizeName(name string) []string {
+
+	// remove the accents by decomposing the runes and dropping the combining marks
+	folder := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(folder, name)
+	if err != nil {
+		folded = name
+	}
+
+	return strings.FieldsFunc(strings.ToLower(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// nameIndex is an inverted index over the product names. It maps every token to
+// the products containing it and keeps the tokens sorted to resolve prefixes.
+// It is not safe for concurrent use, the repositories guard it with their own lock.
+type nameIndex struct {
+	// postings maps token -> product id -> occurrences of the token in the name
+	postings map[string]map[int]int
+	// docs keeps the tokens of each product so they can be removed on update and delete
+	docs map[int][]string
+	// terms are the keys of postings, sorted
+	terms []string
+}
+
+func newNameIndex() *nameIndex {
+	return &nameIndex{
+		postings: make(map[string]map[int]int),
+		docs:     make(map[int][]string),
+	}
+}
+
+// set indexes the name of a product, replacing the previous one
+func (x *nameIndex) set(id int, name string) {
+
+	x.remove(id)
+
+	tokens := tokenizeName(name)
+	x.docs[id] = tokens
+	for _, token := range tokens {
+		posting, ok := x.postings[token]
+		if !ok {
+			posting = make(map[int]int)
+			x.postings[token] = posting
+			i := sort.SearchStrings(x.terms, token)
+			x.terms = append(x.terms, "")
+			copy(x.terms[i+1:], x.terms[i:])
+			x.terms[i] = token
+		}
+		posting[id]++
+	}
+}
+
+// remove drops a product from the index
+func (x *nameIndex) remove(id int) {
+
+	for _, token := range x.docs[id] {
+		posting := x.postings[token]
+		delete(posting, id)
+		if len(posting) == 0 {
+			delete(x.postings, token)
+			i := sort.SearchStrings(x.terms, token)
+			if i < len(x.terms) && x.terms[i] == token {
+				x.terms = append(x.terms[:i], x.terms[i+1:]...)
+			}
+		}
+	}
+	delete(x.docs, id)
+}
+
+// search returns the relevance of every product matching all the terms of the
+// text. A term matches a token equal to it or starting with it, exact matches
+// weigh more than prefix matches and rare tokens more than common ones.
+func (x *nameIndex) search(text string) map[int]float64 {
+
+	terms := tokenizeName(text)
+	if len(terms) == 0 {
+		return map[int]float64{}
+	}
+
+	var scores map[int]float64
+	for _, term := range terms {
+
+		termScores := make(map[int]float64)
+		for i := sort.SearchStrings(x.terms, term); i < len(x.terms) && strings.HasPrefix(x.terms[i], term); i++ {
+			token := x.terms[i]
+			posting := x.postings[token]
+
+			weight := 1.0
+			if token != term {
+				weight = 0.5 * float64(len(term)) / float64(len(token))
+			}
+			idf := math.Log(1 + float64(len(x.docs))/float64(len(posting)))
+
+			for id, occurrences := range posting {
+				termScores[id] += weight * idf * float64(occurrences)
+			}
+		}
+
+		// every term has to match
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for id, score := range scores {
+			termScore, ok := termScores[id]
+			if !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] = score + termScore
+		}
+	}
+
+	return scores
+}
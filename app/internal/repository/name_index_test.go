@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"goweb/app/internal"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenizeName(t *testing.T) {
+	t.Run("Se quitan los acentos, las mayusculas y los separadores", func(t *testing.T) {
+		// Act
+		tokens := tokenizeName("Café - Crème Brûlée, 250g")
+
+		// Assert
+		require.Equal(t, []string{"cafe", "creme", "brulee", "250g"}, tokens)
+	})
+}
+
+func TestNameIndex(t *testing.T) {
+	t.Run("Las coincidencias exactas pesan mas que los prefijos", func(t *testing.T) {
+		// Arrange
+		index := newNameIndex()
+		index.set(1, "Wine - Red Oakridge Merlot")
+		index.set(2, "Bread - Baguette")
+		index.set(3, "Wine - Merlotte Blend")
+
+		// Act
+		scores := index.search("merlot")
+
+		// Assert
+		require.Len(t, scores, 2)
+		require.Greater(t, scores[1], scores[3])
+	})
+
+	t.Run("Todas las palabras deben coincidir", func(t *testing.T) {
+		// Arrange
+		index := newNameIndex()
+		index.set(1, "Wine - Red Oakridge Merlot")
+		index.set(2, "Wine - White Chardonnay")
+
+		// Act
+		scores := index.search("wine merl")
+
+		// Assert
+		require.Equal(t, []int{1}, keys(scores))
+	})
+
+	t.Run("El indice se actualiza al modificar y borrar productos", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		repo := NewRepositoryMap(map[int]internal.Product{})
+		added, err := repo.AddProduct(ctx, internal.Product{Name: "Bread - Baguette", CodeValue: "B1", Expiration: time.Now()})
+		require.NoError(t, err)
+
+		// Act
+		added.Name = "Bread - Focaccia"
+		_, err = repo.UpdateProduct(ctx, added)
+		require.NoError(t, err)
+		baguette, _ := repo.SearchProducts(ctx, internal.ProductQuery{Text: "baguette"})
+		focaccia, _ := repo.SearchProducts(ctx, internal.ProductQuery{Text: "focac"})
+		require.NoError(t, repo.DeleteProduct(ctx, added.ID, 0))
+		deleted, _ := repo.SearchProducts(ctx, internal.ProductQuery{Text: "focac"})
+
+		// Assert
+		require.Equal(t, 0, baguette.Total)
+		require.Equal(t, 1, focaccia.Total)
+		require.Equal(t, 0, deleted.Total)
+		require.Empty(t, repo.index.terms)
+	})
+}
+
+func keys(scores map[int]float64) []int {
+	var ids []int
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,24 @@
+package repository
+
+import "goweb/app/internal"
+
+// codeValueTaken is the error of a write that would give product id the code
+// value of another product: internal.ErrProductExists for a new product (id 0)
+// and internal.ErrCodeValueBelongsToOther for an update
+func codeValueTaken(id int) error {
+	if id == 0 {
+		return internal.ErrProductExists
+	}
+	return internal.ErrCodeValueBelongsToOther
+}
+
+// checkCodeValue fails when a product of the catalog other than id has the code
+// value, for the repositories that go through the whole catalog on every write anyway
+func checkCodeValue(products []internal.Product, id int, code string) error {
+	for _, product := range products {
+		if product.CodeValue == code && product.ID != id {
+			return codeValueTaken(id)
+		}
+	}
+	return nil
+}
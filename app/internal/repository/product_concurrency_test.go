@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"goweb/app/internal"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// the stress tests are meant to be run with the race detector: go test -race ./...
+const (
+	stressWorkers    = 16
+	stressIterations = 50
+)
+
+func TestInMemoryRepositoriesConcurrency(t *testing.T) {
+
+	repositories := map[string]func() internal.ProductRepository{
+		"map":   func() internal.ProductRepository { return NewRepositoryMap(map[int]internal.Product{}) },
+		"slice": func() internal.ProductRepository { return NewRepository([]internal.Product{}) },
+	}
+
+	for name, newRepo := range repositories {
+		t.Run(name+": las altas concurrentes reciben ids unicos", func(t *testing.T) {
+			// Arrange
+			ctx := context.Background()
+			repo := newRepo()
+			ids := make(chan int, stressWorkers*stressIterations)
+
+			// Act
+			var wg sync.WaitGroup
+			for w := 0; w < stressWorkers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < stressIterations; i++ {
+						product, err := repo.AddProduct(ctx, stressProduct(w, i))
+						require.NoError(t, err)
+						ids <- product.ID
+					}
+				}(w)
+			}
+			wg.Wait()
+			close(ids)
+
+			// Assert
+			seen := make(map[int]bool)
+			for id := range ids {
+				require.False(t, seen[id], "id %d assigned twice", id)
+				seen[id] = true
+			}
+			products, err := repo.GetAllProducts(ctx)
+			require.NoError(t, err)
+			require.Len(t, products, stressWorkers*stressIterations)
+		})
+
+		t.Run(name+": altas, modificaciones, bajas y lecturas en paralelo", func(t *testing.T) {
+			// Arrange
+			ctx := context.Background()
+			repo := newRepo()
+
+			// Act
+			var wg sync.WaitGroup
+			for w := 0; w < stressWorkers; w++ {
+				wg.Add(2)
+				// writer: creates, updates and deletes every other product
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < stressIterations; i++ {
+						product, err := repo.AddProduct(ctx, stressProduct(w, i))
+						require.NoError(t, err)
+
+						product.Quantity++
+						_, err = repo.UpdateProduct(ctx, product)
+						require.NoError(t, err)
+
+						if i%2 == 0 {
+							require.NoError(t, repo.DeleteProduct(ctx, product.ID, 0))
+						}
+					}
+				}(w)
+				// reader
+				go func() {
+					defer wg.Done()
+					for i := 0; i < stressIterations; i++ {
+						_, err := repo.GetAllProducts(ctx)
+						require.NoError(t, err)
+						_, err = repo.SearchProducts(ctx, internal.ProductQuery{Text: "stress", Limit: 10})
+						require.NoError(t, err)
+						_, err = repo.GetProductByID(ctx, i)
+						if err != nil {
+							require.ErrorIs(t, err, internal.ErrProductNotFound)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			// Assert
+			products, err := repo.GetAllProducts(ctx)
+			require.NoError(t, err)
+			require.Len(t, products, stressWorkers*stressIterations/2)
+			for _, product := range products {
+				require.Equal(t, 11, product.Quantity)
+			}
+		})
+
+		t.Run(name+": los ids no se reutilizan despues de una baja", func(t *testing.T) {
+			// Arrange
+			ctx := context.Background()
+			repo := newRepo()
+			first, _ := repo.AddProduct(ctx, stressProduct(0, 0))
+			second, _ := repo.AddProduct(ctx, stressProduct(0, 1))
+			require.NoError(t, repo.DeleteProduct(ctx, first.ID, 0))
+
+			// Act
+			third, err := repo.AddProduct(ctx, stressProduct(0, 2))
+
+			// Assert
+			require.NoError(t, err)
+			require.Greater(t, third.ID, second.ID)
+		})
+	}
+}
+
+func stressProduct(worker, iteration int) internal.Product {
+	return internal.Product{
+		Name:        fmt.Sprintf("Stress product %d-%d", worker, iteration),
+		Quantity:    10,
+		CodeValue:   fmt.Sprintf("S%d-%d", worker, iteration),
+		IsPublished: true,
+		Expiration:  time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		Price:       10,
+	}
+}
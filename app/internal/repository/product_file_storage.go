@@ -1,175 +1,417 @@
 package repository
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"goweb/app/internal"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
-// implements the ProductRepository interface
+// DefaultProductsFile is the file used by RepositoryFile when no path is given
+const DefaultProductsFile = "app/data/file_storage/products.json"
+
+// FsyncPolicy decides how hard RepositoryFile tries to get the writes to disk
+type FsyncPolicy int
+
+const (
+	// FsyncAlways syncs the new file and its directory before a write returns,
+	// so an acknowledged write survives a power loss
+	FsyncAlways FsyncPolicy = iota
+	// FsyncNever leaves the flushing to the OS. A crash can lose the last
+	// writes but never leaves a half-written file, since files are replaced atomically.
+	FsyncNever
+)
+
+// implements the ProductRepository interface, storing the products as a JSON
+// array in a file. Writes go to a temporary file that is renamed over the
+// original, and an advisory lock on path+".lock" lets several processes share
+// the file. The parsed file is cached until the file is replaced or its
+// modification time or size change.
 type RepositoryFile struct {
+	path  string
+	fsync FsyncPolicy
+
+	mu sync.RWMutex
+	// cache of the file contents, valid while stat describes the current file
+	products []internal.Product
+	stat     os.FileInfo
+	loaded   bool
+	// lastID is the highest id seen, so ids are not reused after a delete
 	lastID int
+	// index is the full-text index over the product names, rebuilt when the
+	// file is reparsed and kept up to date by the writes
+	index *nameIndex
 }
 
-func NewRepositoryFile() *RepositoryFile {
-	return &RepositoryFile{}
+func NewRepositoryFile(path string, fsync FsyncPolicy) *RepositoryFile {
+	if path == "" {
+		path = DefaultProductsFile
+	}
+	return &RepositoryFile{
+		path:  path,
+		fsync: fsync,
+	}
 }
 
-func (r *RepositoryFile) getDataFromFile() ([]internal.Product, error) {
+// statFile describes the current file, or returns nil if it does not exist yet
+func (r *RepositoryFile) statFile() (os.FileInfo, error) {
+	info, err := os.Stat(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the products file: %w", err)
+	}
+	return info, nil
+}
 
-	// read the json file as a slice of bytes
-	data, err := os.ReadFile("app/data/file_storage/products.json")
+// cached reports whether the cache holds the contents of the file described by info.
+// The caller must hold r.mu.
+func (r *RepositoryFile) cached(info os.FileInfo) bool {
+	if !r.loaded || info == nil || r.stat == nil {
+		return r.loaded && info == nil && r.stat == nil
+	}
+	return os.SameFile(info, r.stat) && info.ModTime().Equal(r.stat.ModTime()) && info.Size() == r.stat.Size()
+}
+
+// load returns the products of the file, parsing it again only if it changed
+// since the last read. The caller must hold r.mu exclusively and the file lock.
+func (r *RepositoryFile) load(ctx context.Context) ([]internal.Product, error) {
+
+	info, err := r.statFile()
 	if err != nil {
-		fmt.Println(err)
-		return []internal.Product{}, err
+		return nil, err
+	}
+	if r.cached(info) {
+		return r.products, nil
 	}
 
-	// unmarshal the bytes to a slice
-	var products []ProductDTO
-	err = json.Unmarshal([]byte(data), &products)
+	// a missing file is an empty catalog, it is created on the first write
+	if info == nil {
+		r.setCache(nil, nil)
+		return nil, nil
+	}
+
+	// read the json file as a slice of bytes
+	data, err := os.ReadFile(r.path)
 	if err != nil {
-		return []internal.Product{}, err
+		return nil, fmt.Errorf("reading the products file: %w", err)
 	}
 
-	// get the last id
-	lastId := 0
-	for _, product := range products {
-		if product.ID > lastId {
-			lastId = product.ID
+	products, err := decodeProductsFile(data)
+	if err != nil {
+		// the readable part of the file is served and the next write replaces it
+		// with a valid file, so the damaged file is copied aside first
+		corrupt := r.path + ".corrupt"
+		if err := writeFileAtomic(corrupt, data, r.fsync); err != nil {
+			return nil, fmt.Errorf("copying the damaged products file: %w", err)
 		}
+		slog.WarnContext(ctx, "products file is damaged, serving the readable products", "path", r.path, "copy", corrupt, "recovered", len(products), "error", err)
 	}
-	r.lastID = lastId
 
-	// convert the slice of DTOs to a slice of internal.Product
-	productsInternal := dtosToInternals(products)
+	r.setCache(dtosToInternals(products), info)
+
+	return r.products, nil
+}
+
+// setCache replaces the cached products with the ones read from the file and
+// rebuilds the index
+func (r *RepositoryFile) setCache(products []internal.Product, info os.FileInfo) {
 
-	return productsInternal, nil
+	r.products = products
+	r.stat = info
+	r.loaded = true
 
+	r.index = newNameIndex()
+	for _, product := range products {
+		r.index.set(product.ID, product.Name)
+	}
+	r.lastID = max(r.lastID, maxProductID(products))
 }
 
-func (r *RepositoryFile) saveDataToFile(products []internal.Product) error {
+// decodeProductsFile parses the JSON array of products. A zero-length file is an
+// empty catalog. When the file is damaged (cut in the middle of a write or followed
+// by leftovers of a longer file) the products before the damage are returned along
+// with the error.
+func decodeProductsFile(data []byte) ([]ProductDTO, error) {
 
-	// open the file
-	file, err := os.OpenFile("app/data/file_storage/products.json", os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println(err)
-		return err
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
 	}
-	defer file.Close()
 
-	// parse internal.Product[] to ProductDTO
-	productsDTO := internalsToDTOs(products)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('[') {
+		return nil, errors.New("the file does not start with a JSON array")
+	}
+
+	var products []ProductDTO
+	for decoder.More() {
+		var product ProductDTO
+		if err := decoder.Decode(&product); err != nil {
+			return products, fmt.Errorf("decoding product %d: %w", len(products)+1, err)
+		}
+		products = append(products, product)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return products, fmt.Errorf("the JSON array is not closed: %w", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return products, errors.New("unexpected data after the JSON array")
+	}
+
+	return products, nil
+}
+
+// save atomically replaces the file with the products. The caller must hold
+// r.mu and the exclusive file lock and have updated the index.
+func (r *RepositoryFile) save(products []internal.Product) error {
 
-	// convert the slice to bytes
-	bytes, err := json.Marshal(productsDTO)
+	// parse internal.Product[] to ProductDTO and convert the slice to bytes
+	data, err := json.Marshal(internalsToDTOs(products))
 	if err != nil {
-		fmt.Println(err)
-		return err
+		return fmt.Errorf("encoding the products: %w", err)
+	}
+
+	if err := writeFileAtomic(r.path, data, r.fsync); err != nil {
+		return fmt.Errorf("saving the products file: %w", err)
 	}
 
-	// write the bytes to the file
-	_, err = file.Write(bytes)
+	info, err := os.Stat(r.path)
 	if err != nil {
-		fmt.Println(err)
-		return err
+		return fmt.Errorf("reading the products file: %w", err)
 	}
+	r.products = products
+	r.stat = info
 
 	return nil
-
 }
 
-// implement the methods from the interface internal.ProductRepository
-func (r *RepositoryFile) GetAllProducts() []internal.Product {
-
-	products, _ := r.getDataFromFile()
-	return products
-}
+// read runs fn with the current products under the shared file lock. fn must
+// not modify the products.
+func (r *RepositoryFile) read(ctx context.Context, fn func(products []internal.Product) error) error {
 
-func (r *RepositoryFile) GetProductByID(id int) internal.Product {
+	unlock, err := lockFile(r.path+".lock", false)
+	if err != nil {
+		return fmt.Errorf("locking the products file: %w", err)
+	}
+	defer unlock()
 
-	products, _ := r.getDataFromFile()
+	info, err := r.statFile()
+	if err != nil {
+		return err
+	}
 
-	for _, product := range products {
-		if product.ID == id {
-			return product
-		}
+	// fast path: concurrent reads of an unchanged file share the cache
+	r.mu.RLock()
+	if r.cached(info) {
+		defer r.mu.RUnlock()
+		return fn(r.products)
 	}
+	r.mu.RUnlock()
 
-	return internal.Product{}
+	// the file changed, reload it
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
+	products, err := r.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	return fn(products)
 }
 
-func (r *RepositoryFile) GetProductsByPriceGreaterThan(price float64) []internal.Product {
+// write runs fn with a copy of the current products under the exclusive lock and
+// saves the products it returns. fn updates r.index with its changes; if they
+// cannot be saved the cache is dropped, so the next access reads the file and
+// rebuilds the index. The file lock is taken before r.mu, as read
+// does: the locks of the file conflict within the process too, so taking them
+// in another order deadlocks a write with the reads.
+func (r *RepositoryFile) write(ctx context.Context, fn func(products []internal.Product) ([]internal.Product, error)) error {
 
-	products, _ := r.getDataFromFile()
+	unlock, err := lockFile(r.path+".lock", true)
+	if err != nil {
+		return fmt.Errorf("locking the products file: %w", err)
+	}
+	defer unlock()
 
-	var productsSorted []internal.Product
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	for _, product := range products {
-		if product.Price > price {
-			productsSorted = append(productsSorted, product)
-		}
+	products, err := r.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	updated, err := fn(append([]internal.Product(nil), products...))
+	if err != nil {
+		return err
 	}
-	return productsSorted
-}
 
-func (r *RepositoryFile) AddProduct(product internal.Product) internal.Product {
+	if err := r.save(updated); err != nil {
+		r.loaded = false
+		return err
+	}
 
-	products, _ := r.getDataFromFile()
+	return nil
+}
 
-	r.lastID++
-	product.ID = r.lastID
+// Close waits for the writes in progress and flushes the products file to disk,
+// which the writes skip under FsyncNever. The repository holds no open files,
+// so it can still be used afterwards.
+func (r *RepositoryFile) Close() error {
 
-	products = append(products, product)
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	err := r.saveDataToFile(products)
+	file, err := os.Open(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
-		fmt.Println(err)
+		return fmt.Errorf("flushing the products file: %w", err)
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("flushing the products file: %w", err)
+	}
+	if err := syncDir(filepath.Dir(r.path)); err != nil {
+		return fmt.Errorf("flushing the products file: %w", err)
 	}
 
-	return product
+	return nil
+}
 
+// HealthCheck checks that the products file can be read and decoded
+func (r *RepositoryFile) HealthCheck(ctx context.Context) error {
+	return r.read(ctx, func(products []internal.Product) error {
+		return nil
+	})
 }
 
-func (r *RepositoryFile) UpdateProduct(product internal.Product) (internal.Product, error) {
+// implement the methods from the interface internal.ProductRepository
+func (r *RepositoryFile) GetAllProducts(ctx context.Context) ([]internal.Product, error) {
+
+	var products []internal.Product
+	err := r.read(ctx, func(current []internal.Product) error {
+		products = append(products, current...)
+		return nil
+	})
 
-	products, _ := r.getDataFromFile()
+	return products, err
+}
+
+func (r *RepositoryFile) GetProductByID(ctx context.Context, id int) (internal.Product, error) {
 
-	for i, prod := range products {
+	product := internal.Product{}
+	err := r.read(ctx, func(products []internal.Product) error {
+		for _, p := range products {
+			if p.ID == id {
+				product = p
+				return nil
+			}
+		}
+		return internal.ErrProductNotFound
+	})
 
-		if prod.ID == product.ID {
-			prod.Name = product.Name
-			prod.CodeValue = product.CodeValue
-			prod.Expiration = product.Expiration
-			prod.IsPublished = product.IsPublished
-			prod.Quantity = product.Quantity
-			prod.Price = product.Price
+	return product, err
 
-			products[i] = prod
+}
 
-			r.saveDataToFile(products)
+func (r *RepositoryFile) AddProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
 
-			return prod, nil
+	err := r.write(ctx, func(products []internal.Product) ([]internal.Product, error) {
+		if err := checkCodeValue(products, 0, product.CodeValue); err != nil {
+			return nil, err
 		}
+		r.lastID++
+		product.ID = r.lastID
+		product.Version = 1
+		r.index.set(product.ID, product.Name)
+		return append(products, product), nil
+	})
+	if err != nil {
+		return internal.Product{}, err
 	}
 
-	return internal.Product{}, internal.ErrProductNotFound
+	return product, nil
+
 }
 
-func (r *RepositoryFile) DeleteProduct(id int) error {
+func (r *RepositoryFile) UpdateProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+
+	var updated internal.Product
+	err := r.write(ctx, func(products []internal.Product) ([]internal.Product, error) {
+		for i, prod := range products {
+
+			if prod.ID == product.ID {
+				if err := checkVersion(prod, product.Version); err != nil {
+					return nil, err
+				}
+				if err := checkCodeValue(products, prod.ID, product.CodeValue); err != nil {
+					return nil, err
+				}
+				prod.Name = product.Name
+				prod.CodeValue = product.CodeValue
+				prod.Expiration = product.Expiration
+				prod.IsPublished = product.IsPublished
+				prod.Quantity = product.Quantity
+				prod.Price = product.Price
+				prod.Version++
+
+				products[i] = prod
+				updated = prod
+				r.index.set(prod.ID, prod.Name)
+
+				return products, nil
+			}
+		}
+		return nil, internal.ErrProductNotFound
+	})
+	if err != nil {
+		return internal.Product{}, err
+	}
 
-	products, _ := r.getDataFromFile()
+	return updated, nil
+}
 
-	for i, p := range products {
-		if p.ID == id {
-			products = append(products[:i], products[i+1:]...)
+func (r *RepositoryFile) DeleteProduct(ctx context.Context, id, version int) error {
+
+	return r.write(ctx, func(products []internal.Product) ([]internal.Product, error) {
+		for i, p := range products {
+			if p.ID == id {
+				if err := checkVersion(p, version); err != nil {
+					return nil, err
+				}
+				r.index.remove(id)
+				return append(products[:i], products[i+1:]...), nil
+			}
+		}
+		return nil, internal.ErrProductNotFound
+	})
+}
 
-			r.saveDataToFile(products)
+func (r *RepositoryFile) SearchProducts(ctx context.Context, query internal.ProductQuery) (internal.ProductPage, error) {
 
-			return nil
+	var page internal.ProductPage
+	err := r.read(ctx, func(products []internal.Product) error {
+		var scores map[int]float64
+		if query.Text != "" {
+			scores = r.index.search(query.Text)
 		}
-	}
 
-	return internal.ErrProductNotFound
+		var err error
+		page, err = applyProductQuery(products, query, scores)
+		return err
+	})
+
+	return page, err
 }
@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"goweb/app/internal"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryFile(t *testing.T) {
+
+	newProduct := func(code string) internal.Product {
+		return internal.Product{Name: "Product " + code, Quantity: 1, CodeValue: code, IsPublished: true, Expiration: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), Price: 1}
+	}
+
+	t.Run("Achicar el catalogo no deja basura al final del archivo", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "products.json")
+		repo := NewRepositoryFile(path, FsyncAlways)
+		for _, code := range []string{"A", "B", "C"} {
+			_, err := repo.AddProduct(ctx, newProduct(code))
+			require.NoError(t, err)
+		}
+
+		// Act
+		require.NoError(t, repo.DeleteProduct(ctx, 1, 0))
+		require.NoError(t, repo.DeleteProduct(ctx, 2, 0))
+
+		// Assert
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var products []ProductDTO
+		require.NoError(t, json.Unmarshal(data, &products))
+		require.Len(t, products, 1)
+		require.Equal(t, 3, products[0].ID)
+	})
+
+	t.Run("Un archivo vacio es un catalogo vacio", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "products.json")
+		require.NoError(t, os.WriteFile(path, nil, 0644))
+		repo := NewRepositoryFile(path, FsyncNever)
+
+		// Act
+		products, err := repo.GetAllProducts(ctx)
+		require.NoError(t, err)
+		added, err := repo.AddProduct(ctx, newProduct("A"))
+
+		// Assert
+		require.NoError(t, err)
+		require.Empty(t, products)
+		require.Equal(t, 1, added.ID)
+	})
+
+	t.Run("Se recuperan los productos de un archivo a medio escribir y se guarda una copia", func(t *testing.T) {
+		cases := map[string]string{
+			"cortado":         `[{"id":1,"name":"A","code_value":"A","expiration":"01/01/2030"},{"id":2,"name":"B","code_va`,
+			"basura al final": `[{"id":1,"name":"A","code_value":"A","expiration":"01/01/2030"}]"expiration":"01/01/2030"}]`,
+		}
+		for name, content := range cases {
+			// Arrange
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "products.json")
+			require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+			repo := NewRepositoryFile(path, FsyncNever)
+
+			// Act
+			products, err := repo.GetAllProducts(ctx)
+			require.NoError(t, err, name)
+			added, err := repo.AddProduct(ctx, newProduct("C"))
+			require.NoError(t, err, name)
+
+			// Assert
+			require.Len(t, products, 1, name)
+			require.Equal(t, 2, added.ID, name)
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.True(t, json.Valid(data), name)
+			damaged, err := os.ReadFile(path + ".corrupt")
+			require.NoError(t, err, name)
+			require.Equal(t, content, string(damaged), name)
+		}
+	})
+
+	t.Run("Los cambios hechos por otro proceso invalidan el cache", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "products.json")
+		repo := NewRepositoryFile(path, FsyncNever)
+		other := NewRepositoryFile(path, FsyncNever)
+		_, err := repo.AddProduct(ctx, newProduct("A"))
+		require.NoError(t, err)
+		_, err = repo.GetAllProducts(ctx)
+		require.NoError(t, err)
+
+		// Act
+		_, err = other.AddProduct(ctx, newProduct("B"))
+		require.NoError(t, err)
+		products, err := repo.GetAllProducts(ctx)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, products, 2)
+	})
+
+	t.Run("Las escrituras actualizan el indice sin reconstruirlo", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		repo := NewRepositoryFile(filepath.Join(t.TempDir(), "products.json"), FsyncNever)
+		for _, code := range []string{"A", "B"} {
+			_, err := repo.AddProduct(ctx, newProduct(code))
+			require.NoError(t, err)
+		}
+		index := repo.index
+
+		// Act
+		renamed := newProduct("B")
+		renamed.ID = 2
+		renamed.Name = "Galletitas"
+		_, err := repo.UpdateProduct(ctx, renamed)
+		require.NoError(t, err)
+		require.NoError(t, repo.DeleteProduct(ctx, 1, 0))
+
+		// Assert
+		require.Same(t, index, repo.index)
+		require.Equal(t, map[int]float64{}, repo.index.search("product"))
+		require.Contains(t, repo.index.search("galletitas"), 2)
+	})
+
+	t.Run("Dos instancias sobre el mismo archivo no pierden escrituras", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "products.json")
+		repos := []*RepositoryFile{NewRepositoryFile(path, FsyncNever), NewRepositoryFile(path, FsyncNever)}
+
+		// Act
+		var wg sync.WaitGroup
+		for i, repo := range repos {
+			wg.Add(1)
+			go func(i int, repo *RepositoryFile) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					_, err := repo.AddProduct(ctx, newProduct(string(rune('A'+i))+string(rune('a'+j))))
+					require.NoError(t, err)
+				}
+			}(i, repo)
+		}
+		wg.Wait()
+
+		// Assert
+		products, err := NewRepositoryFile(path, FsyncNever).GetAllProducts(ctx)
+		require.NoError(t, err)
+		require.Len(t, products, 40)
+		ids := make(map[int]bool)
+		for _, product := range products {
+			require.False(t, ids[product.ID], "id %d assigned twice", product.ID)
+			ids[product.ID] = true
+		}
+	})
+	t.Run("Lecturas y escrituras concurrentes no se bloquean entre si", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		repo := NewRepositoryFile(filepath.Join(t.TempDir(), "products.json"), FsyncNever)
+		done := make(chan struct{})
+
+		// Act
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					if i%2 == 0 {
+						_, err := repo.AddProduct(ctx, newProduct(string(rune('A'+i))+string(rune('a'+j))))
+						require.NoError(t, err)
+						continue
+					}
+					_, err := repo.GetAllProducts(ctx)
+					require.NoError(t, err)
+				}
+			}(i)
+		}
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		// Assert
+		select {
+		case <-done:
+		case <-time.After(20 * time.Second):
+			t.Fatal("the reads and the writes deadlocked")
+		}
+		products, err := repo.GetAllProducts(ctx)
+		require.NoError(t, err)
+		require.Len(t, products, 80)
+	})
+
+	t.Run("Close vuelca a disco lo escrito sin fsync", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "products.json")
+		repo := NewRepositoryFile(path, FsyncNever)
+		require.NoError(t, NewRepositoryFile(filepath.Join(t.TempDir(), "missing.json"), FsyncNever).Close())
+
+		for _, code := range []string{"A", "B", "C"} {
+			_, err := repo.AddProduct(ctx, newProduct(code))
+			require.NoError(t, err)
+		}
+
+		// Act
+		err := repo.Close()
+
+		// Assert
+		require.NoError(t, err)
+		products, err := NewRepositoryFile(path, FsyncNever).GetAllProducts(ctx)
+		require.NoError(t, err)
+		require.Len(t, products, 3)
+	})
+}
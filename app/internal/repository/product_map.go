@@ -1,16 +1,26 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"goweb/app/internal"
+	"log/slog"
 	"os"
+	"sync"
 )
 
-// implements the ProductRepository interface
+// implements the ProductRepository interface. It is safe for concurrent use:
+// reads share a read lock and writes take the lock exclusively, so Products
+// must not be accessed directly once the repository is in use.
 type RepositoryMap struct {
 	Products map[int]internal.Product
 	lastID   int
+	// index is the full-text index over the product names
+	index *nameIndex
+	// codes maps every code value to the product that has it
+	codes map[string]int
+	mu    sync.RWMutex
 }
 
 func NewRepositoryMap(data map[int]internal.Product) *RepositoryMap {
@@ -18,40 +28,57 @@ func NewRepositoryMap(data map[int]internal.Product) *RepositoryMap {
 	if data == nil {
 		repo := &RepositoryMap{
 			Products: make(map[int]internal.Product),
+			index:    newNameIndex(),
+			codes:    make(map[string]int),
+		}
+		if err := repo.LoadData(DefaultSeedFile); err != nil {
+			slog.Error("loading the default catalog", "path", DefaultSeedFile, "error", err)
 		}
-		repo.LoadData()
 		return repo
 	}
 
-	// find the last id
+	// find the last id and index the names and the code values
 	lastID := 0
-	for _, product := range data {
+	index := newNameIndex()
+	codes := make(map[string]int, len(data))
+	for id, product := range data {
+		// the products of older catalogs start at the first version
+		if product.Version == 0 {
+			product.Version = 1
+			data[id] = product
+		}
 		if product.ID > lastID {
 			lastID = product.ID
 		}
+		index.set(product.ID, product.Name)
+		codes[product.CodeValue] = product.ID
 	}
 
 	return &RepositoryMap{
 		Products: data,
 		lastID:   lastID,
+		index:    index,
+		codes:    codes,
 	}
 }
 
-func (r *RepositoryMap) LoadData() {
+// LoadData adds the products of the JSON file at path to the repository
+func (r *RepositoryMap) LoadData(path string) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
 	// read the json file as a slice of bytes
-	data, err := os.ReadFile("app/data/products.json")
+	data, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return fmt.Errorf("reading the seed file: %w", err)
 	}
 
 	// unmarshal the bytes to a slice
 	var products []ProductDTO
 	err = json.Unmarshal([]byte(data), &products)
 	if err != nil {
-		fmt.Println("Hubo un error")
-		return
+		return fmt.Errorf("decoding the seed file %s: %w", path, err)
 	}
 
 	// convert the slice of DTOs to a slice of internal.Product
@@ -61,74 +88,176 @@ func (r *RepositoryMap) LoadData() {
 	lastId := 0
 	for _, product := range productsInternal {
 		r.Products[product.ID] = product
+		r.index.set(product.ID, product.Name)
+		r.codes[product.CodeValue] = product.ID
 		if product.ID > lastId {
 			lastId = product.ID
 		}
 	}
 	r.lastID = lastId
 
+	return nil
 }
 
 // implement the methods from the interface internal.ProductRepository
-func (r *RepositoryMap) GetAllProducts() []internal.Product {
-	var products []internal.Product
+func (r *RepositoryMap) GetAllProducts(ctx context.Context) ([]internal.Product, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.products(), nil
+}
+
+// products copies the products so they can be used once the lock is released
+func (r *RepositoryMap) products() []internal.Product {
+	products := make([]internal.Product, 0, len(r.Products))
 	for _, product := range r.Products {
 		products = append(products, product)
 	}
 	return products
 }
 
-func (r *RepositoryMap) GetProductByID(id int) internal.Product {
+func (r *RepositoryMap) GetProductByID(ctx context.Context, id int) (internal.Product, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
 	prod, ok := r.Products[id]
 	if !ok {
-		return internal.Product{}
+		return internal.Product{}, internal.ErrProductNotFound
 	}
 
-	return prod
+	return prod, nil
 
 }
 
-func (r *RepositoryMap) GetProductsByPriceGreaterThan(price float64) []internal.Product {
-	var products []internal.Product
-	for _, product := range r.Products {
-		if product.Price > price {
-			products = append(products, product)
-		}
+func (r *RepositoryMap) AddProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkCodeLocked(0, product.CodeValue); err != nil {
+		return internal.Product{}, err
 	}
-	return products
-}
 
-func (r *RepositoryMap) AddProduct(product internal.Product) internal.Product {
 	r.lastID++
 	product.ID = r.lastID
+	product.Version = 1
 	r.Products[r.lastID] = product
+	r.index.set(product.ID, product.Name)
+	r.codes[product.CodeValue] = product.ID
 
-	return product
+	return product, nil
 }
 
-func (r *RepositoryMap) UpdateProduct(product internal.Product) (internal.Product, error) {
+func (r *RepositoryMap) UpdateProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
 
-	for id, prod := range r.Products {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-		if id == product.ID {
-			prod.Name = product.Name
-			prod.CodeValue = product.CodeValue
-			prod.Expiration = product.Expiration
-			prod.IsPublished = product.IsPublished
-			prod.Quantity = product.Quantity
-			prod.Price = product.Price
+	prod, ok := r.Products[product.ID]
+	if !ok {
+		return internal.Product{}, internal.ErrProductNotFound
+	}
+	if err := checkVersion(prod, product.Version); err != nil {
+		return internal.Product{}, err
+	}
+	if err := r.checkCodeLocked(prod.ID, product.CodeValue); err != nil {
+		return internal.Product{}, err
+	}
+	r.dropCode(prod)
 
-			r.Products[id] = prod
+	prod.Name = product.Name
+	prod.CodeValue = product.CodeValue
+	prod.Expiration = product.Expiration
+	prod.IsPublished = product.IsPublished
+	prod.Quantity = product.Quantity
+	prod.Price = product.Price
+	prod.Version++
 
-			return prod, nil
-		}
-	}
+	r.Products[prod.ID] = prod
+	r.index.set(prod.ID, prod.Name)
+	r.codes[prod.CodeValue] = prod.ID
 
-	return internal.Product{}, internal.ErrProductNotFound
+	return prod, nil
 }
 
-func (r *RepositoryMap) DeleteProduct(id int) error {
+func (r *RepositoryMap) DeleteProduct(ctx context.Context, id, version int) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prod, ok := r.Products[id]
+	if !ok {
+		return internal.ErrProductNotFound
+	}
+	if err := checkVersion(prod, version); err != nil {
+		return err
+	}
 	delete(r.Products, id)
+	r.index.remove(id)
+	r.dropCode(prod)
 	return nil
 }
+
+// put stores the product as is, keeping its id
+func (r *RepositoryMap) put(product internal.Product) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.Products[product.ID]; ok {
+		r.dropCode(stored)
+	}
+	r.Products[product.ID] = product
+	r.index.set(product.ID, product.Name)
+	r.codes[product.CodeValue] = product.ID
+	r.lastID = max(r.lastID, product.ID)
+}
+
+// checkCode fails when the code value belongs to a product other than id, 0 for a new product
+func (r *RepositoryMap) checkCode(id int, code string) error {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.checkCodeLocked(id, code)
+}
+
+// checkCodeLocked is checkCode for a caller holding r.mu
+func (r *RepositoryMap) checkCodeLocked(id int, code string) error {
+	if owner, ok := r.codes[code]; ok && owner != id {
+		return codeValueTaken(id)
+	}
+	return nil
+}
+
+// dropCode forgets the code value of a product that is changed or deleted.
+// The caller must hold r.mu.
+func (r *RepositoryMap) dropCode(product internal.Product) {
+	if r.codes[product.CodeValue] == product.ID {
+		delete(r.codes, product.CodeValue)
+	}
+}
+
+// nextID returns the id the next added product would get
+func (r *RepositoryMap) nextID() int {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.lastID + 1
+}
+
+func (r *RepositoryMap) SearchProducts(ctx context.Context, query internal.ProductQuery) (internal.ProductPage, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var scores map[int]float64
+	if query.Text != "" {
+		scores = r.index.search(query.Text)
+	}
+
+	return applyProductQuery(r.products(), query, scores)
+}
@@ -0,0 +1,252 @@
+package repository
+
+import (
+	"fmt"
+	"goweb/app/internal"
+	"sort"
+	"strings"
+	"time"
+)
+
+// applyProductQuery evaluates a query against products held in memory. The
+// scores are the relevance of the products matching query.Text, computed by
+// the repository index; when they are nil and there is a text, the products
+// are indexed on the fly.
+func applyProductQuery(products []internal.Product, query internal.ProductQuery, scores map[int]float64) (internal.ProductPage, error) {
+
+	if query.Text != "" && scores == nil {
+		index := newNameIndex()
+		for _, product := range products {
+			index.set(product.ID, product.Name)
+		}
+		scores = index.search(query.Text)
+	}
+
+	// filter
+	var matched []internal.Product
+	for _, product := range products {
+		if _, ok := scores[product.ID]; query.Text != "" && !ok {
+			continue
+		}
+		ok, err := matchProductQuery(product, query)
+		if err != nil {
+			return internal.ProductPage{}, err
+		}
+		if ok {
+			matched = append(matched, product)
+		}
+	}
+
+	// sort, always falling back to the id so pages are stable
+	var sortErr error
+	sort.SliceStable(matched, func(i, j int) bool {
+		for _, s := range query.Sort {
+			cmp, err := compareProductFields(matched[i], matched[j], s.Field)
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			if cmp != 0 {
+				if s.Desc {
+					return cmp > 0
+				}
+				return cmp < 0
+			}
+		}
+		// without an explicit order the best matches of the text go first
+		if query.Text != "" && len(query.Sort) == 0 {
+			if si, sj := scores[matched[i].ID], scores[matched[j].ID]; si != sj {
+				return si > sj
+			}
+		}
+		return matched[i].ID < matched[j].ID
+	})
+	if sortErr != nil {
+		return internal.ProductPage{}, sortErr
+	}
+
+	// paginate
+	page := internal.ProductPage{Total: len(matched)}
+	start := min(max(query.Offset, 0), len(matched))
+	end := len(matched)
+	if query.Limit > 0 {
+		end = min(start+query.Limit, len(matched))
+	}
+	page.Products = matched[start:end]
+
+	return page, nil
+}
+
+// matchProductQuery reports whether the product matches every filter and the where expression
+func matchProductQuery(product internal.Product, query internal.ProductQuery) (bool, error) {
+	for _, filter := range query.Filters {
+		ok, err := matchProductFilter(product, filter)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	if query.Where == nil {
+		return true, nil
+	}
+	return matchFilterExpr(product, query.Where)
+}
+
+// matchFilterExpr evaluates the expression as a predicate over the product
+func matchFilterExpr(product internal.Product, expr internal.FilterExpr) (bool, error) {
+	switch e := expr.(type) {
+	case internal.ProductFilter:
+		return matchProductFilter(product, e)
+	case internal.FilterAnd:
+		ok, err := matchFilterExpr(product, e.Left)
+		if err != nil || !ok {
+			return false, err
+		}
+		return matchFilterExpr(product, e.Right)
+	case internal.FilterOr:
+		ok, err := matchFilterExpr(product, e.Left)
+		if err != nil || ok {
+			return ok, err
+		}
+		return matchFilterExpr(product, e.Right)
+	case internal.FilterNot:
+		ok, err := matchFilterExpr(product, e.Expr)
+		return !ok, err
+	}
+	return false, fmt.Errorf("%w: unknown expression %T", internal.ErrInvalidQuery, expr)
+}
+
+func matchProductFilter(product internal.Product, filter internal.ProductFilter) (bool, error) {
+
+	if filter.Op == internal.FilterOpContains {
+		current, err := productFieldValue(product, filter.Field)
+		if err != nil {
+			return false, err
+		}
+		currentStr, ok := current.(string)
+		value, okValue := filter.Value.(string)
+		if !ok || !okValue {
+			return false, fmt.Errorf("%w: operator ~ needs a string field and value", internal.ErrInvalidQuery)
+		}
+		return strings.Contains(strings.ToLower(currentStr), strings.ToLower(value)), nil
+	}
+
+	cmp, err := compareProductField(product, filter.Field, filter.Value)
+	if err != nil {
+		return false, err
+	}
+
+	switch filter.Op {
+	case internal.FilterOpEq:
+		return cmp == 0, nil
+	case internal.FilterOpNe:
+		return cmp != 0, nil
+	case internal.FilterOpGt:
+		return cmp > 0, nil
+	case internal.FilterOpGte:
+		return cmp >= 0, nil
+	case internal.FilterOpLt:
+		return cmp < 0, nil
+	case internal.FilterOpLte:
+		return cmp <= 0, nil
+	}
+
+	return false, fmt.Errorf("%w: unknown operator %q", internal.ErrInvalidQuery, filter.Op)
+}
+
+// compareProductField compares the value of a product field with the given value
+func compareProductField(product internal.Product, field internal.ProductField, value any) (int, error) {
+
+	invalid := fmt.Errorf("%w: invalid value %v for field %s", internal.ErrInvalidQuery, value, field)
+
+	switch field {
+	case internal.ProductFieldID, internal.ProductFieldQuantity:
+		v, ok := value.(int)
+		if !ok {
+			return 0, invalid
+		}
+		current := product.ID
+		if field == internal.ProductFieldQuantity {
+			current = product.Quantity
+		}
+		return compareOrdered(current, v), nil
+	case internal.ProductFieldName, internal.ProductFieldCodeValue:
+		v, ok := value.(string)
+		if !ok {
+			return 0, invalid
+		}
+		current := product.Name
+		if field == internal.ProductFieldCodeValue {
+			current = product.CodeValue
+		}
+		return strings.Compare(current, v), nil
+	case internal.ProductFieldIsPublished:
+		v, ok := value.(bool)
+		if !ok {
+			return 0, invalid
+		}
+		return compareBool(product.IsPublished, v), nil
+	case internal.ProductFieldExpiration:
+		v, ok := value.(time.Time)
+		if !ok {
+			return 0, invalid
+		}
+		return product.Expiration.Compare(v), nil
+	case internal.ProductFieldPrice:
+		v, ok := value.(float64)
+		if !ok {
+			return 0, invalid
+		}
+		return compareOrdered(product.Price, v), nil
+	}
+
+	return 0, fmt.Errorf("%w: unknown field %q", internal.ErrInvalidQuery, field)
+}
+
+// compareProductFields compares the same field of two products
+func compareProductFields(a, b internal.Product, field internal.ProductField) (int, error) {
+	value, err := productFieldValue(b, field)
+	if err != nil {
+		return 0, err
+	}
+	return compareProductField(a, field, value)
+}
+
+func productFieldValue(product internal.Product, field internal.ProductField) (any, error) {
+	switch field {
+	case internal.ProductFieldID:
+		return product.ID, nil
+	case internal.ProductFieldName:
+		return product.Name, nil
+	case internal.ProductFieldQuantity:
+		return product.Quantity, nil
+	case internal.ProductFieldCodeValue:
+		return product.CodeValue, nil
+	case internal.ProductFieldIsPublished:
+		return product.IsPublished, nil
+	case internal.ProductFieldExpiration:
+		return product.Expiration, nil
+	case internal.ProductFieldPrice:
+		return product.Price, nil
+	}
+	return nil, fmt.Errorf("%w: unknown field %q", internal.ErrInvalidQuery, field)
+}
+
+func compareOrdered[T int | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case !a:
+		return -1
+	}
+	return 1
+}
@@ -1,93 +1,135 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"goweb/app/internal"
+	"log/slog"
 	"os"
+	"sync"
 )
 
-// implements the ProductRepository interface
+// implements the ProductRepository interface. It is safe for concurrent use:
+// reads share a read lock and writes take the lock exclusively, so Products
+// must not be accessed directly once the repository is in use.
 type Repository struct {
 	Products []internal.Product
+	// lastID is the highest id ever assigned, so ids are not reused after a delete
+	lastID int
+	mu     sync.RWMutex
 }
 
 func NewRepository(data []internal.Product) *Repository {
 
 	if data == nil {
 		repo := &Repository{}
-		repo.LoadData()
+		if err := repo.LoadData(DefaultSeedFile); err != nil {
+			slog.Error("loading the default catalog", "path", DefaultSeedFile, "error", err)
+		}
 		return repo
 	}
 
+	// the products of older catalogs start at the first version
+	for i := range data {
+		data[i].Version = max(data[i].Version, 1)
+	}
+
 	return &Repository{
 		Products: data,
+		lastID:   maxProductID(data),
 	}
 }
 
-func (r *Repository) LoadData() {
+// LoadData replaces the products of the repository with the ones of the JSON file at path
+func (r *Repository) LoadData(path string) error {
 
 	// read the json file as a slice of bytes
-	data, err := os.ReadFile("app/data/products.json")
+	data, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return fmt.Errorf("reading the seed file: %w", err)
 	}
 
 	// unmarshal the bytes to the repo slice
 	var products []ProductDTO
 	err = json.Unmarshal([]byte(data), &products)
 	if err != nil {
-		fmt.Println("Hubo un error")
-		return
+		return fmt.Errorf("decoding the seed file %s: %w", path, err)
 	}
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	// convert the slice of DTOs to a slice of internal.Product
 	r.Products = dtosToInternals(products)
+	r.lastID = maxProductID(r.Products)
 
+	return nil
 }
 
 // implement the methods from the interface internal.ProductRepository
-func (r *Repository) GetAllProducts() []internal.Product {
-	return r.Products
+func (r *Repository) GetAllProducts(ctx context.Context) ([]internal.Product, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// return a copy, the slice is modified in place by the writes
+	products := make([]internal.Product, len(r.Products))
+	copy(products, r.Products)
+
+	return products, nil
 }
 
-func (r *Repository) GetProductByID(id int) internal.Product {
+func (r *Repository) GetProductByID(ctx context.Context, id int) (internal.Product, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	for _, product := range r.Products {
 		if product.ID == id {
-			return product
+			return product, nil
 		}
 	}
-	return internal.Product{}
+	return internal.Product{}, internal.ErrProductNotFound
 }
 
-func (r *Repository) GetProductsByPriceGreaterThan(price float64) []internal.Product {
-	var products []internal.Product
-	for _, product := range r.Products {
-		if product.Price > price {
-			products = append(products, product)
-		}
-	}
-	return products
-}
+func (r *Repository) AddProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
 
-func (r *Repository) AddProduct(product internal.Product) internal.Product {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	product.ID = len(r.Products) + 1
+	if err := checkCodeValue(r.Products, 0, product.CodeValue); err != nil {
+		return internal.Product{}, err
+	}
+
+	r.lastID++
+	product.ID = r.lastID
+	product.Version = 1
 	r.Products = append(r.Products, product)
 
-	return product
+	return product, nil
 }
 
-func (r *Repository) UpdateProduct(product internal.Product) (internal.Product, error) {
+func (r *Repository) UpdateProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	for i, p := range r.Products {
 		if p.ID == product.ID {
+			if err := checkVersion(p, product.Version); err != nil {
+				return internal.Product{}, err
+			}
+			if err := checkCodeValue(r.Products, p.ID, product.CodeValue); err != nil {
+				return internal.Product{}, err
+			}
 			r.Products[i].Name = product.Name
 			r.Products[i].CodeValue = product.CodeValue
 			r.Products[i].Expiration = product.Expiration
 			r.Products[i].IsPublished = product.IsPublished
 			r.Products[i].Quantity = product.Quantity
 			r.Products[i].Price = product.Price
+			r.Products[i].Version++
 			return r.Products[i], nil
 		}
 	}
@@ -95,9 +137,16 @@ func (r *Repository) UpdateProduct(product internal.Product) (internal.Product,
 	return internal.Product{}, internal.ErrProductNotFound
 }
 
-func (r *Repository) DeleteProduct(id int) error {
+func (r *Repository) DeleteProduct(ctx context.Context, id, version int) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	for i, p := range r.Products {
 		if p.ID == id {
+			if err := checkVersion(p, version); err != nil {
+				return err
+			}
 			r.Products = append(r.Products[:i], r.Products[i+1:]...)
 			return nil
 		}
@@ -105,3 +154,22 @@ func (r *Repository) DeleteProduct(id int) error {
 
 	return internal.ErrProductNotFound
 }
+
+func (r *Repository) SearchProducts(ctx context.Context, query internal.ProductQuery) (internal.ProductPage, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return applyProductQuery(r.Products, query, nil)
+}
+
+// maxProductID returns the highest id of the products
+func maxProductID(products []internal.Product) int {
+	lastID := 0
+	for _, product := range products {
+		if product.ID > lastID {
+			lastID = product.ID
+		}
+	}
+	return lastID
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"fmt"
+	"goweb/app/internal"
+	"strings"
+)
+
+// productColumns maps each queryable field to its column in the products table
+var productColumns = map[internal.ProductField]string{
+	internal.ProductFieldID:          "id",
+	internal.ProductFieldName:        "name",
+	internal.ProductFieldQuantity:    "quantity",
+	internal.ProductFieldCodeValue:   "code_value",
+	internal.ProductFieldIsPublished: "is_published",
+	internal.ProductFieldExpiration:  "expiration",
+	internal.ProductFieldPrice:       "price",
+}
+
+var sqlFilterOps = map[internal.FilterOp]string{
+	internal.FilterOpEq:  "=",
+	internal.FilterOpNe:  "<>",
+	internal.FilterOpGt:  ">",
+	internal.FilterOpGte: ">=",
+	internal.FilterOpLt:  "<",
+	internal.FilterOpLte: "<=",
+}
+
+// productSQLQuery holds the clauses generated from an internal.ProductQuery
+type productSQLQuery struct {
+	Where   string
+	OrderBy string
+	// Args are the values of the WHERE placeholders and OrderArgs the ones of the ORDER BY
+	Args      []any
+	OrderArgs []any
+}
+
+// sqlFullText describes how a SQL engine runs the full-text search over the product names
+type sqlFullText struct {
+	// Condition is true for the products matching the search, Rank orders them
+	// from best to worst match. Each one has a single placeholder for the search.
+	Condition string
+	Rank      string
+	// Search translates the text to the syntax of the engine, once for the
+	// condition and once for the rank, reporting false when it has no words.
+	// Exact words must rank above prefixes, as they do in the in-memory index.
+	Search func(text string) (match string, rank string, ok bool)
+}
+
+// mysqlFullText needs a FULLTEXT index on products.name
+var mysqlFullText = sqlFullText{
+	Condition: "MATCH(name) AGAINST (? IN BOOLEAN MODE)",
+	Rank:      "MATCH(name) AGAINST (? IN BOOLEAN MODE) DESC",
+	Search:    fullTextBooleanQuery,
+}
+
+// buildProductSQLQuery translates a query into WHERE and ORDER BY clauses using
+// ? placeholders. Only whitelisted columns and operators are written to the SQL.
+func buildProductSQLQuery(query internal.ProductQuery, fullText sqlFullText) (productSQLQuery, error) {
+
+	var result productSQLQuery
+
+	// where
+	var conditions []string
+	if query.Text != "" {
+		search, _, ok := fullText.Search(query.Text)
+		if !ok {
+			conditions = append(conditions, "1 = 0")
+		} else {
+			conditions = append(conditions, fullText.Condition)
+			result.Args = append(result.Args, search)
+		}
+	}
+	for _, filter := range query.Filters {
+		condition, err := compileFilterExpr(filter, &result.Args)
+		if err != nil {
+			return productSQLQuery{}, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if query.Where != nil {
+		condition, err := compileFilterExpr(query.Where, &result.Args)
+		if err != nil {
+			return productSQLQuery{}, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) > 0 {
+		result.Where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// order by, always falling back to the id so pages are stable
+	var order []string
+	for _, s := range query.Sort {
+		column, ok := productColumns[s.Field]
+		if !ok {
+			return productSQLQuery{}, fmt.Errorf("%w: unknown field %q", internal.ErrInvalidQuery, s.Field)
+		}
+		if s.Desc {
+			column += " DESC"
+		}
+		order = append(order, column)
+	}
+	if query.Text != "" && len(query.Sort) == 0 {
+		if _, rank, ok := fullText.Search(query.Text); ok {
+			order = append(order, fullText.Rank)
+			result.OrderArgs = append(result.OrderArgs, rank)
+		}
+	}
+	order = append(order, "id")
+	result.OrderBy = " ORDER BY " + strings.Join(order, ", ")
+
+	return result, nil
+}
+
+// compileFilterExpr writes the expression as a SQL condition, appending its values to args
+func compileFilterExpr(expr internal.FilterExpr, args *[]any) (string, error) {
+
+	switch e := expr.(type) {
+	case internal.ProductFilter:
+		column, ok := productColumns[e.Field]
+		if !ok {
+			return "", fmt.Errorf("%w: unknown field %q", internal.ErrInvalidQuery, e.Field)
+		}
+		if e.Op == internal.FilterOpContains {
+			value, ok := e.Value.(string)
+			if !ok {
+				return "", fmt.Errorf("%w: operator ~ needs a string value", internal.ErrInvalidQuery)
+			}
+			*args = append(*args, "%"+escapeLike(strings.ToLower(value))+"%")
+			return "LOWER(" + column + ") LIKE ? ESCAPE '" + likeEscape + "'", nil
+		}
+		op, ok := sqlFilterOps[e.Op]
+		if !ok {
+			return "", fmt.Errorf("%w: unknown operator %q", internal.ErrInvalidQuery, e.Op)
+		}
+		*args = append(*args, e.Value)
+		return column + " " + op + " ?", nil
+	case internal.FilterAnd, internal.FilterOr:
+		var left, right internal.FilterExpr
+		joiner := " AND "
+		if and, ok := e.(internal.FilterAnd); ok {
+			left, right = and.Left, and.Right
+		} else {
+			or := e.(internal.FilterOr)
+			left, right, joiner = or.Left, or.Right, " OR "
+		}
+		leftSQL, err := compileFilterExpr(left, args)
+		if err != nil {
+			return "", err
+		}
+		rightSQL, err := compileFilterExpr(right, args)
+		if err != nil {
+			return "", err
+		}
+		return "(" + leftSQL + joiner + rightSQL + ")", nil
+	case internal.FilterNot:
+		inner, err := compileFilterExpr(e.Expr, args)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + inner + ")", nil
+	}
+
+	return "", fmt.Errorf("%w: unknown expression %T", internal.ErrInvalidQuery, expr)
+}
+
+// likeEscape is the escape character of the LIKE patterns. SQLite has no
+// default one and a backslash is written differently in the string literals of
+// MySQL and Postgres, so every engine is given this one.
+const likeEscape = "!"
+
+// escapeLike escapes the wildcards of a LIKE pattern with likeEscape
+func escapeLike(value string) string {
+	return strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_").Replace(value)
+}
+
+// fullTextBooleanQuery builds a MySQL boolean mode search where every word is
+// required and matches as a prefix, e.g. "red merl" becomes "+red* +merl*".
+// The rank adds the exact words as optional terms: "red* red merl* merl".
+func fullTextBooleanQuery(text string) (string, string, bool) {
+	tokens := tokenizeName(text)
+	match := make([]string, len(tokens))
+	rank := make([]string, len(tokens))
+	for i, token := range tokens {
+		match[i] = "+" + token + "*"
+		rank[i] = token + "* " + token
+	}
+	return strings.Join(match, " "), strings.Join(rank, " "), len(tokens) > 0
+}
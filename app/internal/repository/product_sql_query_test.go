@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"goweb/app/internal"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildProductSQLQuery(t *testing.T) {
+	t.Run("Los filtros y el orden se traducen a SQL con placeholders.", func(t *testing.T) {
+		// Arrange
+		query := internal.ProductQuery{
+			Filters: []internal.ProductFilter{
+				{Field: internal.ProductFieldIsPublished, Op: internal.FilterOpEq, Value: true},
+				{Field: internal.ProductFieldQuantity, Op: internal.FilterOpGte, Value: 10},
+			},
+			Sort: []internal.ProductSort{
+				{Field: internal.ProductFieldPrice},
+				{Field: internal.ProductFieldExpiration, Desc: true},
+			},
+		}
+
+		// Act
+		clauses, err := buildProductSQLQuery(query, mysqlFullText)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, " WHERE is_published = ? AND quantity >= ?", clauses.Where)
+		require.Equal(t, " ORDER BY price, expiration DESC, id", clauses.OrderBy)
+		require.Equal(t, []any{true, 10}, clauses.Args)
+	})
+
+	t.Run("Un campo desconocido nunca llega al SQL.", func(t *testing.T) {
+		// Arrange
+		query := internal.ProductQuery{
+			Sort: []internal.ProductSort{{Field: "price; DROP TABLE products"}},
+		}
+
+		// Act
+		_, err := buildProductSQLQuery(query, mysqlFullText)
+
+		// Assert
+		require.ErrorIs(t, err, internal.ErrInvalidQuery)
+	})
+
+	t.Run("Las expresiones se compilan respetando la precedencia.", func(t *testing.T) {
+		// Arrange
+		query := internal.ProductQuery{
+			Where: internal.FilterOr{
+				Left: internal.ProductFilter{Field: internal.ProductFieldPrice, Op: internal.FilterOpGt, Value: 100.0},
+				Right: internal.FilterNot{Expr: internal.ProductFilter{
+					Field: internal.ProductFieldName, Op: internal.FilterOpContains, Value: "50%_off",
+				}},
+			},
+		}
+
+		// Act
+		clauses, err := buildProductSQLQuery(query, mysqlFullText)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, " WHERE (price > ? OR NOT (LOWER(name) LIKE ? ESCAPE '!'))", clauses.Where)
+		require.Equal(t, []any{100.0, `%50!%!_off%`}, clauses.Args)
+	})
+}
+
+func TestSQLDialectBind(t *testing.T) {
+	t.Run("Postgres usa placeholders numerados en el orden de la sentencia.", func(t *testing.T) {
+		// Act
+		statement := postgresDialect.bind("SELECT id FROM products WHERE price > ? AND name = ? LIMIT ? OFFSET ?")
+
+		// Assert
+		require.Equal(t, "SELECT id FROM products WHERE price > $1 AND name = $2 LIMIT $3 OFFSET $4", statement)
+		require.Equal(t, "SELECT id FROM products WHERE id = ?", mysqlDialect.bind("SELECT id FROM products WHERE id = ?"))
+	})
+}
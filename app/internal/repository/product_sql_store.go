@@ -0,0 +1,310 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"goweb/app/internal"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// sqlDialect holds what changes between the SQL engines. Every statement is
+// written once with ? placeholders and adapted to the engine by bind.
+type sqlDialect struct {
+	// numberedPlaceholders writes the placeholders as $1, $2... instead of ?
+	numberedPlaceholders bool
+	// returningID gets the id of an inserted row with RETURNING id instead of LastInsertId
+	returningID bool
+	// offsetOnly is the clause used to skip rows without limiting them
+	offsetOnly string
+	fullText   sqlFullText
+	// duplicate reports whether err is the violation of a unique index
+	duplicate func(err error) bool
+	// system is the db.system attribute of the spans of the statements
+	system attribute.KeyValue
+}
+
+var (
+	mysqlDialect = sqlDialect{
+		// mysql does not accept an OFFSET without a LIMIT
+		offsetOnly: " LIMIT 18446744073709551615 OFFSET ?",
+		fullText:   mysqlFullText,
+		duplicate:  mysqlDuplicate,
+		system:     semconv.DBSystemMySQL,
+	}
+	sqliteDialect = sqlDialect{
+		offsetOnly: " LIMIT -1 OFFSET ?",
+		fullText:   sqliteFullText,
+		duplicate:  sqliteDuplicate,
+		system:     semconv.DBSystemSqlite,
+	}
+	postgresDialect = sqlDialect{
+		numberedPlaceholders: true,
+		returningID:          true,
+		offsetOnly:           " OFFSET ?",
+		fullText:             postgresFullText,
+		duplicate:            postgresDuplicate,
+		system:               semconv.DBSystemPostgreSQL,
+	}
+)
+
+// bind rewrites the ? placeholders of the statement for the engine
+func (d sqlDialect) bind(statement string) string {
+
+	if !d.numberedPlaceholders {
+		return statement
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range statement {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+const productSelect = "SELECT id, name, quantity, code_value, is_published, expiration, price, version FROM products"
+
+// sqlProductStore implements internal.ProductRepository on top of a products
+// table. It is shared by the SQL repositories, so they all behave the same.
+type sqlProductStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// HealthCheck pings the database
+func (s *sqlProductStore) HealthCheck(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("pinging the database: %w", err)
+	}
+	return nil
+}
+
+// Stats returns the statistics of the connection pool
+func (s *sqlProductStore) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// GetAllProducts returns all products
+func (s *sqlProductStore) GetAllProducts(ctx context.Context) ([]internal.Product, error) {
+
+	rows, err := s.query(ctx, productSelect)
+	if err != nil {
+		return nil, fmt.Errorf("querying the products: %w", err)
+	}
+	defer rows.Close()
+
+	return scanProducts(rows)
+}
+
+// SearchProducts returns a page of the products matching the query
+func (s *sqlProductStore) SearchProducts(ctx context.Context, query internal.ProductQuery) (internal.ProductPage, error) {
+
+	clauses, err := buildProductSQLQuery(query, s.dialect.fullText)
+	if err != nil {
+		return internal.ProductPage{}, err
+	}
+
+	// count every matching product
+	var page internal.ProductPage
+	err = s.queryRow(ctx, "SELECT COUNT(*) FROM products"+clauses.Where, clauses.Args, &page.Total)
+	if err != nil {
+		return internal.ProductPage{}, fmt.Errorf("counting the products: %w", err)
+	}
+
+	// fetch the requested page
+	statement := productSelect + clauses.Where + clauses.OrderBy
+	args := append(append([]any{}, clauses.Args...), clauses.OrderArgs...)
+	if query.Limit > 0 {
+		statement += " LIMIT ? OFFSET ?"
+		args = append(args, query.Limit, max(query.Offset, 0))
+	} else if query.Offset > 0 {
+		statement += s.dialect.offsetOnly
+		args = append(args, query.Offset)
+	}
+
+	rows, err := s.query(ctx, statement, args...)
+	if err != nil {
+		return internal.ProductPage{}, fmt.Errorf("querying the products: %w", err)
+	}
+	defer rows.Close()
+
+	page.Products, err = scanProducts(rows)
+	if err != nil {
+		return internal.ProductPage{}, err
+	}
+
+	return page, nil
+}
+
+// GetProductByID returns a product by id
+func (s *sqlProductStore) GetProductByID(ctx context.Context, id int) (internal.Product, error) {
+
+	var product internal.Product
+	err := s.queryRow(ctx, productSelect+" WHERE id = ?", []any{id}, productDest(&product)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return internal.Product{}, internal.ErrProductNotFound
+		}
+		return internal.Product{}, fmt.Errorf("querying the product %d: %w", id, err)
+	}
+
+	return product, nil
+}
+
+// AddProduct adds a product, the unique index on code_value rejects a taken code value
+func (s *sqlProductStore) AddProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+
+	product.Version = 1
+	statement := "INSERT INTO products (name, quantity, code_value, is_published, expiration, price, version) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	args := []any{product.Name, product.Quantity, product.CodeValue, product.IsPublished, product.Expiration, product.Price, product.Version}
+
+	// get the id of the inserted product
+	if s.dialect.returningID {
+		err := s.queryRow(ctx, statement+" RETURNING id", args, &product.ID)
+		if err != nil {
+			return internal.Product{}, s.insertError(err)
+		}
+		return product, nil
+	}
+
+	result, err := s.exec(ctx, statement, args...)
+	if err != nil {
+		return internal.Product{}, s.insertError(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return internal.Product{}, fmt.Errorf("getting the last inserted id: %w", err)
+	}
+
+	product.ID = int(id)
+	return product, nil
+}
+
+// UpdateProduct updates a product. With a version the update only matches
+// the row while it has that version, so two writers cannot both update the
+// same version.
+func (s *sqlProductStore) UpdateProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+
+	statement := "UPDATE products SET name = ?, quantity = ?, code_value = ?, is_published = ?, expiration = ?, price = ?, version = version + 1 WHERE id = ?"
+	args := []any{product.Name, product.Quantity, product.CodeValue, product.IsPublished, product.Expiration, product.Price, product.ID}
+	if product.Version != 0 {
+		statement += " AND version = ?"
+		args = append(args, product.Version)
+	}
+
+	res, err := s.exec(ctx, statement, args...)
+	if err != nil {
+		if s.dialect.duplicate(err) {
+			return internal.Product{}, internal.ErrCodeValueBelongsToOther
+		}
+		return internal.Product{}, fmt.Errorf("updating the product %d: %w", product.ID, err)
+	}
+
+	// the version always changes, so no row affected means the product is gone or has another version
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return internal.Product{}, fmt.Errorf("getting the rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return internal.Product{}, s.missingProduct(ctx, product.ID)
+	}
+
+	if product.Version != 0 {
+		product.Version++
+		return product, nil
+	}
+	// without a version the new one has to be read, another writer may have changed it since
+	err = s.queryRow(ctx, "SELECT version FROM products WHERE id = ?", []any{product.ID}, &product.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return internal.Product{}, internal.ErrProductNotFound
+		}
+		return internal.Product{}, fmt.Errorf("querying the version of the product %d: %w", product.ID, err)
+	}
+
+	return product, nil
+}
+
+// DeleteProduct deletes a product, with a version only while it has that version
+func (s *sqlProductStore) DeleteProduct(ctx context.Context, id, version int) error {
+
+	statement := "DELETE FROM products WHERE id = ?"
+	args := []any{id}
+	if version != 0 {
+		statement += " AND version = ?"
+		args = append(args, version)
+	}
+
+	res, err := s.exec(ctx, statement, args...)
+	if err != nil {
+		return fmt.Errorf("deleting the product %d: %w", id, err)
+	}
+
+	// check if the product was deleted
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting the rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return s.missingProduct(ctx, id)
+	}
+
+	return nil
+}
+
+// insertError is the error of a failed insert of a product
+func (s *sqlProductStore) insertError(err error) error {
+	if s.dialect.duplicate(err) {
+		return internal.ErrProductExists
+	}
+	return fmt.Errorf("inserting the product: %w", err)
+}
+
+// missingProduct tells why a statement on the product matched no row: it does
+// not exist or it has another version
+func (s *sqlProductStore) missingProduct(ctx context.Context, id int) error {
+	if _, err := s.GetProductByID(ctx, id); err != nil {
+		return err
+	}
+	return internal.ErrProductVersionMismatch
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// productDest returns the destinations of the columns of productSelect
+func productDest(product *internal.Product) []any {
+	return []any{&product.ID, &product.Name, &product.Quantity, &product.CodeValue, &product.IsPublished, &product.Expiration, &product.Price, &product.Version}
+}
+
+// scanProducts reads every row of a products query
+func scanProducts(rows *sql.Rows) ([]internal.Product, error) {
+
+	var products []internal.Product
+	for rows.Next() {
+		var product internal.Product
+		if err := rows.Scan(productDest(&product)...); err != nil {
+			return nil, fmt.Errorf("scanning the row: %w", err)
+		}
+
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating the rows: %w", err)
+	}
+
+	return products, nil
+}
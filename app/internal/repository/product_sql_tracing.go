@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sqlInstrumentation is the name of the tracer of the SQL statements
+const sqlInstrumentation = "goweb/app/internal/repository"
+
+// startSpan starts the span of a statement, named after its operation, e.g.
+// "sql SELECT". The statement is recorded without its arguments.
+func (s *sqlProductStore) startSpan(ctx context.Context, statement string) (context.Context, trace.Span) {
+
+	operation, _, _ := strings.Cut(strings.TrimSpace(statement), " ")
+	// the tracer is looked up on each statement so it follows the global provider
+	return otel.Tracer(sqlInstrumentation).Start(ctx, "sql "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(s.dialect.system, semconv.DBStatement(statement), semconv.DBOperation(operation)),
+	)
+}
+
+// endSpan records the error of the statement, if any, and ends its span
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// query runs a statement that returns rows, binding its placeholders for the engine
+func (s *sqlProductStore) query(ctx context.Context, statement string, args ...any) (*sql.Rows, error) {
+	statement = s.dialect.bind(statement)
+	ctx, span := s.startSpan(ctx, statement)
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+// queryRow runs a statement that returns a single row and scans it into dest
+func (s *sqlProductStore) queryRow(ctx context.Context, statement string, args []any, dest ...any) error {
+	statement = s.dialect.bind(statement)
+	ctx, span := s.startSpan(ctx, statement)
+	err := s.db.QueryRowContext(ctx, statement, args...).Scan(dest...)
+	endSpan(span, err)
+	return err
+}
+
+// exec runs a statement that returns no rows
+func (s *sqlProductStore) exec(ctx context.Context, statement string, args ...any) (sql.Result, error) {
+	statement = s.dialect.bind(statement)
+	ctx, span := s.startSpan(ctx, statement)
+	result, err := s.db.ExecContext(ctx, statement, args...)
+	if err == nil {
+		if rows, rowsErr := result.RowsAffected(); rowsErr == nil {
+			span.SetAttributes(attribute.Int64("db.rows_affected", rows))
+		}
+	}
+	endSpan(span, err)
+	return result, err
+}
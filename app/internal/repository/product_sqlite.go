@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// DefaultSQLiteFile is the database used when no path is given
+const DefaultSQLiteFile = "app/data/products.db"
+
+// sqliteSchema mirrors the products table of MySQL. The FTS5 table indexes the
+// names for the full-text search and is kept in sync by the triggers.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS products (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		code_value TEXT NOT NULL,
+		is_published BOOLEAN NOT NULL,
+		expiration DATETIME NOT NULL,
+		price REAL NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_products_code_value ON products (code_value)`,
+	`CREATE VIRTUAL TABLE IF NOT EXISTS products_fts USING fts5(
+		name, content='products', content_rowid='id', tokenize='unicode61 remove_diacritics 2'
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS products_fts_insert AFTER INSERT ON products BEGIN
+		INSERT INTO products_fts(rowid, name) VALUES (new.id, new.name);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS products_fts_delete AFTER DELETE ON products BEGIN
+		INSERT INTO products_fts(products_fts, rowid, name) VALUES ('delete', old.id, old.name);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS products_fts_update AFTER UPDATE OF name ON products BEGIN
+		INSERT INTO products_fts(products_fts, rowid, name) VALUES ('delete', old.id, old.name);
+		INSERT INTO products_fts(rowid, name) VALUES (new.id, new.name);
+	END`,
+}
+
+// sqliteFullText uses the FTS5 index, bm25 is lower for better matches
+var sqliteFullText = sqlFullText{
+	Condition: "id IN (SELECT rowid FROM products_fts WHERE products_fts MATCH ?)",
+	Rank:      "(SELECT bm25(products_fts) FROM products_fts WHERE products_fts MATCH ? AND rowid = products.id)",
+	Search:    fts5Query,
+}
+
+// fts5Query builds a FTS5 query where every word is required and matches as a
+// prefix, e.g. "red merl" becomes `"red"* "merl"*`. The rank also matches the
+// exact words: `("red" OR "red"*) AND ("merl" OR "merl"*)`.
+func fts5Query(text string) (string, string, bool) {
+	tokens := tokenizeName(text)
+	match := make([]string, len(tokens))
+	rank := make([]string, len(tokens))
+	for i, token := range tokens {
+		match[i] = `"` + token + `"*`
+		rank[i] = `("` + token + `" OR "` + token + `"*)`
+	}
+	return strings.Join(match, " "), strings.Join(rank, " AND "), len(tokens) > 0
+}
+
+// sqliteDuplicate reports whether err is the violation of a UNIQUE constraint
+func sqliteDuplicate(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// NewSQLiteConnection opens the SQLite database at path, creating it if needed.
+// Use ":memory:" for a database that lives as long as the connection.
+func NewSQLiteConnection(path string) (*sql.DB, error) {
+
+	if path == "" {
+		path = DefaultSQLiteFile
+	}
+
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	if path == ":memory:" {
+		dsn = ":memory:"
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening the sqlite database: %w", err)
+	}
+
+	// sqlite allows a single writer, and every connection to :memory: would be a different database
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("pinging the sqlite database: %w", err)
+	}
+
+	return db, nil
+}
+
+// NewProductRepositorySQLite creates the schema if it does not exist yet
+func NewProductRepositorySQLite(db *sql.DB) (*ProductRepositorySQLite, error) {
+
+	for _, statement := range sqliteSchema {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, fmt.Errorf("creating the sqlite schema: %w", err)
+		}
+	}
+
+	// sqlite cannot add a column only if it does not exist, like the tables created before the products had a version
+	var hasVersion bool
+	err := db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info('products') WHERE name = 'version'").Scan(&hasVersion)
+	if err != nil {
+		return nil, fmt.Errorf("reading the sqlite schema: %w", err)
+	}
+	if !hasVersion {
+		if _, err := db.Exec("ALTER TABLE products ADD COLUMN version INTEGER NOT NULL DEFAULT 1"); err != nil {
+			return nil, fmt.Errorf("adding the version to the sqlite schema: %w", err)
+		}
+	}
+
+	return &ProductRepositorySQLite{
+		sqlProductStore: sqlProductStore{db: db, dialect: sqliteDialect},
+	}, nil
+}
+
+// implements the ProductRepository interface with the same semantics as ProductRepositorySQL
+type ProductRepositorySQLite struct {
+	sqlProductStore
+}
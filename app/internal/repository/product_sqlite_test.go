@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProductRepositorySQLite(t *testing.T) {
+	t.Run("Se agrega la version a una tabla creada antes de que existiera", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		db, err := NewSQLiteConnection(":memory:")
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		_, err = db.Exec(`CREATE TABLE products (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			quantity INTEGER NOT NULL,
+			code_value TEXT NOT NULL,
+			is_published BOOLEAN NOT NULL,
+			expiration DATETIME NOT NULL,
+			price REAL NOT NULL
+		)`)
+		require.NoError(t, err)
+		_, err = db.Exec(`INSERT INTO products (name, quantity, code_value, is_published, expiration, price) VALUES ('Bread', 1, 'B1', true, '2030-01-01 00:00:00+00:00', 2.5)`)
+		require.NoError(t, err)
+
+		// Act
+		repo, err := NewProductRepositorySQLite(db)
+		require.NoError(t, err)
+		_, err = NewProductRepositorySQLite(db)
+		require.NoError(t, err, "opening it again")
+
+		// Assert
+		product, err := repo.GetProductByID(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, 1, product.Version)
+	})
+}
@@ -0,0 +1,12 @@
+package repository
+
+import "goweb/app/internal"
+
+// checkVersion fails with internal.ErrProductVersionMismatch when the stored
+// product no longer has the version a change was based on, 0 matches any version
+func checkVersion(stored internal.Product, version int) error {
+	if version != 0 && stored.Version != version {
+		return internal.ErrProductVersionMismatch
+	}
+	return nil
+}
@@ -0,0 +1,421 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goweb/app/internal"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	walLogFile      = "products.wal"
+	walSnapshotFile = "products.snapshot.json"
+
+	// DefaultWALCompactEvery is the number of log entries after which the log is compacted
+	DefaultWALCompactEvery = 1000
+)
+
+// walOp is the kind of change recorded by a log entry
+type walOp string
+
+const (
+	walOpCreate walOp = "create"
+	walOpUpdate walOp = "update"
+	walOpDelete walOp = "delete"
+)
+
+// walEntry is a change to the catalog. Each entry is written on its own line
+// prefixed by the CRC-32 of its JSON, e.g.
+//
+//	1c291ca3 {"seq":7,"op":"delete","id":3}
+type walEntry struct {
+	Seq     uint64      `json:"seq"`
+	Op      walOp       `json:"op"`
+	ID      int         `json:"id,omitempty"`
+	Product *ProductDTO `json:"product,omitempty"`
+}
+
+// walSnapshot is the whole catalog as of the entry Seq
+type walSnapshot struct {
+	Seq      uint64       `json:"seq"`
+	LastID   int          `json:"last_id"`
+	Products []ProductDTO `json:"products"`
+}
+
+// ErrCorruptLog is returned when an entry in the middle of the log is damaged.
+// A damaged last entry is the expected result of a crash and is discarded instead.
+var ErrCorruptLog = errors.New("the products log is corrupt")
+
+// implements the ProductRepository interface with an append-only log. Every
+// create, update and delete is appended to products.wal in dir, so a write costs
+// one append no matter the size of the catalog. On startup the state is rebuilt
+// from the last snapshot plus the entries logged after it, and every
+// compactEvery entries the catalog is written to a new snapshot and the log is emptied.
+type RepositoryWAL struct {
+	dir          string
+	fsync        FsyncPolicy
+	compactEvery int
+
+	// mu serializes the writers, the reads are served by state
+	mu  sync.Mutex
+	log walFile
+	// size is the length of the log, used to undo a failed append
+	size   int64
+	seq    uint64
+	logged int
+
+	// state is the catalog rebuilt from the log
+	state *RepositoryMap
+}
+
+// walFile is what the repository uses of the log file, so the tests can make it fail
+type walFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Stat() (os.FileInfo, error)
+	Close() error
+}
+
+// NewRepositoryWAL opens (or creates) the log stored in dir and rebuilds the catalog
+func NewRepositoryWAL(dir string, fsync FsyncPolicy, compactEvery int) (*RepositoryWAL, error) {
+
+	if compactEvery <= 0 {
+		compactEvery = DefaultWALCompactEvery
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating the log directory: %w", err)
+	}
+
+	r := &RepositoryWAL{
+		dir:          dir,
+		fsync:        fsync,
+		compactEvery: compactEvery,
+		state:        NewRepositoryMap(map[int]internal.Product{}),
+	}
+
+	if err := r.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := r.replay(); err != nil {
+		return nil, err
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, walLogFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening the products log: %w", err)
+	}
+	r.log = log
+
+	return r, nil
+}
+
+// loadSnapshot restores the catalog from the snapshot, if there is one
+func (r *RepositoryWAL) loadSnapshot() error {
+
+	data, err := os.ReadFile(filepath.Join(r.dir, walSnapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading the products snapshot: %w", err)
+	}
+
+	// snapshots are replaced atomically, so a damaged one is not a crash leftover
+	var snapshot walSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("decoding the products snapshot: %w", err)
+	}
+
+	for _, product := range dtosToInternals(snapshot.Products) {
+		r.state.put(product)
+	}
+	r.state.lastID = max(r.state.lastID, snapshot.LastID)
+	r.seq = snapshot.Seq
+
+	return nil
+}
+
+// replay applies the entries logged after the snapshot. A truncated or damaged
+// last entry is cut off the log.
+func (r *RepositoryWAL) replay() error {
+
+	path := filepath.Join(r.dir, walLogFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading the products log: %w", err)
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(data))
+	valid := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a line without its newline is an entry cut in the middle of the write
+			break
+		}
+
+		entry, decodeErr := decodeWALEntry(line)
+		if decodeErr != nil {
+			if valid+len(line) < len(data) {
+				return fmt.Errorf("%w: entry at offset %d: %v", ErrCorruptLog, valid, decodeErr)
+			}
+			break
+		}
+		valid += len(line)
+
+		// entries already in the snapshot were logged before a compaction finished
+		if entry.Seq <= r.seq {
+			continue
+		}
+		if err := r.apply(entry); err != nil {
+			return fmt.Errorf("%w: entry %d: %v", ErrCorruptLog, entry.Seq, err)
+		}
+		r.seq = entry.Seq
+		r.logged++
+	}
+
+	r.size = int64(valid)
+	if valid < len(data) {
+		slog.Warn("products log ends with a damaged entry, discarding it", "path", path, "bytes", len(data)-valid)
+		if err := os.Truncate(path, int64(valid)); err != nil {
+			return fmt.Errorf("truncating the products log: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func encodeWALEntry(entry walEntry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Appendf(nil, "%08x %s\n", crc32.ChecksumIEEE(data), data), nil
+}
+
+func decodeWALEntry(line []byte) (walEntry, error) {
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	checksum, data, ok := bytes.Cut(line, []byte(" "))
+	if !ok {
+		return walEntry{}, errors.New("missing checksum")
+	}
+	if fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)) != string(checksum) {
+		return walEntry{}, errors.New("checksum mismatch")
+	}
+
+	var entry walEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return walEntry{}, err
+	}
+	return entry, nil
+}
+
+// apply changes the state according to the entry
+func (r *RepositoryWAL) apply(entry walEntry) error {
+	switch entry.Op {
+	case walOpCreate, walOpUpdate:
+		if entry.Product == nil {
+			return errors.New("missing product")
+		}
+		r.state.put(dtosToInternals([]ProductDTO{*entry.Product})[0])
+		return nil
+	case walOpDelete:
+		return r.state.DeleteProduct(context.Background(), entry.ID, 0)
+	}
+	return fmt.Errorf("unknown operation %q", entry.Op)
+}
+
+// append writes the entry to the log and applies it. The caller must hold r.mu.
+// A failed append leaves the log as it was, the replay must not find an entry
+// that was never acknowledged nor give its seq to two entries.
+func (r *RepositoryWAL) append(entry walEntry) error {
+
+	entry.Seq = r.seq + 1
+	line, err := encodeWALEntry(entry)
+	if err != nil {
+		return fmt.Errorf("encoding the log entry: %w", err)
+	}
+
+	if _, err := r.log.Write(line); err != nil {
+		// drop the partial line, so the next entry does not start in the middle of it
+		r.log.Truncate(r.size)
+		return fmt.Errorf("writing the products log: %w", err)
+	}
+	if r.fsync == FsyncAlways {
+		if err := r.log.Sync(); err != nil {
+			r.log.Truncate(r.size)
+			return fmt.Errorf("syncing the products log: %w", err)
+		}
+	}
+
+	if err := r.apply(entry); err != nil {
+		r.log.Truncate(r.size)
+		return err
+	}
+	r.size += int64(len(line))
+	r.seq = entry.Seq
+	r.logged++
+
+	// the entry is durable by now, so a failed compaction does not fail the
+	// write, the next append tries it again
+	if r.logged >= r.compactEvery {
+		if err := r.compact(); err != nil {
+			slog.Error("compacting the products log, retrying on the next write", "dir", r.dir, "error", err)
+		}
+	}
+	return nil
+}
+
+// Compact writes the catalog to a new snapshot and empties the log
+func (r *RepositoryWAL) Compact() error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.compact()
+}
+
+func (r *RepositoryWAL) compact() error {
+
+	products, _ := r.state.GetAllProducts(context.Background())
+	data, err := json.Marshal(walSnapshot{
+		Seq:      r.seq,
+		LastID:   r.state.nextID() - 1,
+		Products: internalsToDTOs(products),
+	})
+	if err != nil {
+		return fmt.Errorf("encoding the products snapshot: %w", err)
+	}
+
+	// if the process dies after the snapshot is written but before the log is
+	// emptied, the replay skips the entries already in the snapshot
+	if err := writeFileAtomic(filepath.Join(r.dir, walSnapshotFile), data, r.fsync); err != nil {
+		return fmt.Errorf("writing the products snapshot: %w", err)
+	}
+	if err := r.log.Truncate(0); err != nil {
+		return fmt.Errorf("truncating the products log: %w", err)
+	}
+	r.size = 0
+	if r.fsync == FsyncAlways {
+		if err := r.log.Sync(); err != nil {
+			return fmt.Errorf("syncing the products log: %w", err)
+		}
+	}
+	r.logged = 0
+
+	return nil
+}
+
+// Close flushes and closes the log
+func (r *RepositoryWAL) Close() error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.log.Sync(); err != nil {
+		r.log.Close()
+		return fmt.Errorf("syncing the products log: %w", err)
+	}
+	return r.log.Close()
+}
+
+// HealthCheck checks that the log is still open and on disk
+func (r *RepositoryWAL) HealthCheck(ctx context.Context) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.log.Stat(); err != nil {
+		return fmt.Errorf("reading the products log: %w", err)
+	}
+	return nil
+}
+
+// implement the methods from the interface internal.ProductRepository
+func (r *RepositoryWAL) GetAllProducts(ctx context.Context) ([]internal.Product, error) {
+	return r.state.GetAllProducts(ctx)
+}
+
+func (r *RepositoryWAL) GetProductByID(ctx context.Context, id int) (internal.Product, error) {
+	return r.state.GetProductByID(ctx, id)
+}
+
+func (r *RepositoryWAL) SearchProducts(ctx context.Context, query internal.ProductQuery) (internal.ProductPage, error) {
+	return r.state.SearchProducts(ctx, query)
+}
+
+func (r *RepositoryWAL) AddProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the state only changes under r.mu, so the code value is still free when the entry is applied
+	if err := r.state.checkCode(0, product.CodeValue); err != nil {
+		return internal.Product{}, err
+	}
+
+	product.ID = r.state.nextID()
+	product.Version = 1
+	dto := internalsToDTOs([]internal.Product{product})[0]
+	if err := r.append(walEntry{Op: walOpCreate, Product: &dto}); err != nil {
+		return internal.Product{}, err
+	}
+
+	return r.state.GetProductByID(ctx, product.ID)
+}
+
+func (r *RepositoryWAL) UpdateProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.state.GetProductByID(ctx, product.ID)
+	if err != nil {
+		return internal.Product{}, err
+	}
+	if err := checkVersion(stored, product.Version); err != nil {
+		return internal.Product{}, err
+	}
+	if err := r.state.checkCode(stored.ID, product.CodeValue); err != nil {
+		return internal.Product{}, err
+	}
+
+	// the entry holds the new version, so the replay does not count the updates
+	product.Version = stored.Version + 1
+	dto := internalsToDTOs([]internal.Product{product})[0]
+	if err := r.append(walEntry{Op: walOpUpdate, Product: &dto}); err != nil {
+		return internal.Product{}, err
+	}
+
+	return r.state.GetProductByID(ctx, product.ID)
+}
+
+func (r *RepositoryWAL) DeleteProduct(ctx context.Context, id, version int) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.state.GetProductByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(stored, version); err != nil {
+		return err
+	}
+
+	return r.append(walEntry{Op: walOpDelete, ID: id})
+}
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"goweb/app/internal"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryWAL(t *testing.T) {
+
+	newProduct := func(code string) internal.Product {
+		return internal.Product{Name: "Product " + code, Quantity: 1, CodeValue: code, IsPublished: true, Expiration: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), Price: 1}
+	}
+
+	t.Run("El estado se reconstruye al reabrir el log", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		dir := t.TempDir()
+		repo, err := NewRepositoryWAL(dir, FsyncAlways, 0)
+		require.NoError(t, err)
+		a, _ := repo.AddProduct(ctx, newProduct("A"))
+		b, _ := repo.AddProduct(ctx, newProduct("B"))
+		c, _ := repo.AddProduct(ctx, newProduct("C"))
+		b.Price = 99
+		_, err = repo.UpdateProduct(ctx, b)
+		require.NoError(t, err)
+		require.NoError(t, repo.DeleteProduct(ctx, c.ID, 0))
+		require.NoError(t, repo.Close())
+
+		// Act
+		reopened, err := NewRepositoryWAL(dir, FsyncAlways, 0)
+		require.NoError(t, err)
+		products, _ := reopened.SearchProducts(ctx, internal.ProductQuery{})
+		added, _ := reopened.AddProduct(ctx, newProduct("D"))
+
+		// Assert
+		require.Equal(t, 2, products.Total)
+		require.Equal(t, a.ID, products.Products[0].ID)
+		require.Equal(t, 99.0, products.Products[1].Price)
+		require.Equal(t, 2, products.Products[1].Version)
+		require.Equal(t, 4, added.ID)
+	})
+
+	t.Run("Se descarta una ultima entrada cortada por un crash", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		dir := t.TempDir()
+		repo, err := NewRepositoryWAL(dir, FsyncNever, 0)
+		require.NoError(t, err)
+		repo.AddProduct(ctx, newProduct("A"))
+		repo.AddProduct(ctx, newProduct("B"))
+		require.NoError(t, repo.Close())
+
+		path := filepath.Join(dir, walLogFile)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, data[:len(data)-10], 0644))
+
+		// Act
+		reopened, err := NewRepositoryWAL(dir, FsyncNever, 0)
+		require.NoError(t, err)
+		products, _ := reopened.GetAllProducts(ctx)
+		_, err = reopened.AddProduct(ctx, newProduct("C"))
+		require.NoError(t, err)
+		require.NoError(t, reopened.Close())
+		again, err := NewRepositoryWAL(dir, FsyncNever, 0)
+		require.NoError(t, err)
+		all, _ := again.GetAllProducts(ctx)
+
+		// Assert
+		require.Len(t, products, 1)
+		require.Len(t, all, 2)
+	})
+
+	t.Run("Una entrada danada en el medio del log es un error", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		dir := t.TempDir()
+		repo, err := NewRepositoryWAL(dir, FsyncNever, 0)
+		require.NoError(t, err)
+		repo.AddProduct(ctx, newProduct("A"))
+		repo.AddProduct(ctx, newProduct("B"))
+		require.NoError(t, repo.Close())
+
+		path := filepath.Join(dir, walLogFile)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		data[12] ^= 0xff
+		require.NoError(t, os.WriteFile(path, data, 0644))
+
+		// Act
+		_, err = NewRepositoryWAL(dir, FsyncNever, 0)
+
+		// Assert
+		require.ErrorIs(t, err, ErrCorruptLog)
+	})
+
+	t.Run("La compactacion vacia el log sin perder datos ni reutilizar ids", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		dir := t.TempDir()
+		repo, err := NewRepositoryWAL(dir, FsyncNever, 3)
+		require.NoError(t, err)
+		repo.AddProduct(ctx, newProduct("A"))
+		repo.AddProduct(ctx, newProduct("B"))
+		last, _ := repo.AddProduct(ctx, newProduct("C"))
+		require.NoError(t, repo.DeleteProduct(ctx, last.ID, 0))
+		require.NoError(t, repo.Compact())
+		require.NoError(t, repo.Close())
+
+		// Act
+		info, err := os.Stat(filepath.Join(dir, walLogFile))
+		require.NoError(t, err)
+		reopened, err := NewRepositoryWAL(dir, FsyncNever, 3)
+		require.NoError(t, err)
+		products, _ := reopened.GetAllProducts(ctx)
+		added, _ := reopened.AddProduct(ctx, newProduct("D"))
+
+		// Assert
+		require.Zero(t, info.Size())
+		require.Len(t, products, 2)
+		require.Equal(t, 4, added.ID)
+	})
+
+	t.Run("Una compactacion fallida no hace fallar la escritura y se reintenta", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		dir := t.TempDir()
+		repo, err := NewRepositoryWAL(dir, FsyncNever, 2)
+		require.NoError(t, err)
+		// a directory with files in the place of the snapshot makes writing it fail
+		snapshot := filepath.Join(dir, walSnapshotFile)
+		require.NoError(t, os.MkdirAll(filepath.Join(snapshot, "blocker"), 0o755))
+
+		// Act
+		_, errFirst := repo.AddProduct(ctx, newProduct("A"))
+		_, errCompacting := repo.AddProduct(ctx, newProduct("B"))
+		_, errRetrying := repo.AddProduct(ctx, newProduct("C"))
+		require.NoError(t, os.RemoveAll(snapshot))
+		_, errCompacted := repo.AddProduct(ctx, newProduct("D"))
+		info, err := os.Stat(filepath.Join(dir, walLogFile))
+		require.NoError(t, err)
+		require.NoError(t, repo.Close())
+		reopened, err := NewRepositoryWAL(dir, FsyncNever, 2)
+		require.NoError(t, err)
+		products, _ := reopened.GetAllProducts(ctx)
+
+		// Assert
+		require.NoError(t, errFirst)
+		require.NoError(t, errCompacting)
+		require.NoError(t, errRetrying)
+		require.NoError(t, errCompacted)
+		require.Zero(t, info.Size())
+		require.Len(t, products, 4)
+	})
+	t.Run("Una entrada que no se pudo sincronizar no se reproduce al reabrir", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		dir := t.TempDir()
+		repo, err := NewRepositoryWAL(dir, FsyncAlways, 0)
+		require.NoError(t, err)
+		_, err = repo.AddProduct(ctx, newProduct("A"))
+		require.NoError(t, err)
+		log := repo.log
+		repo.log = failingSyncFile{walFile: log}
+
+		// Act
+		_, errSync := repo.AddProduct(ctx, newProduct("B"))
+		repo.log = log
+		added, errAfter := repo.AddProduct(ctx, newProduct("C"))
+		require.NoError(t, repo.Close())
+		reopened, err := NewRepositoryWAL(dir, FsyncAlways, 0)
+		require.NoError(t, err)
+		products, _ := reopened.GetAllProducts(ctx)
+		second, errSecond := reopened.GetProductByID(ctx, 2)
+
+		// Assert
+		require.Error(t, errSync)
+		require.NoError(t, errAfter)
+		require.Equal(t, 2, added.ID)
+		require.Len(t, products, 2)
+		require.NoError(t, errSecond)
+		require.Equal(t, "C", second.CodeValue)
+	})
+}
+
+// failingSyncFile is a log whose syncs fail, as on a full or broken disk
+type failingSyncFile struct {
+	walFile
+}
+
+func (failingSyncFile) Sync() error {
+	return errors.New("sync failed")
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// localPostgres is a throwaway Postgres cluster started from the binaries of
+// the machine for the tests, when POSTGRES_TEST_DSN does not name a server
+var localPostgres struct {
+	once sync.Once
+	dsn  string
+	err  error
+	stop func()
+}
+
+func TestMain(m *testing.M) {
+
+	code := m.Run()
+	if localPostgres.stop != nil {
+		localPostgres.stop()
+	}
+	os.Exit(code)
+}
+
+// postgresTestDSN returns the DSN of the Postgres server of the tests: the one
+// of POSTGRES_TEST_DSN or else a local cluster, started once for every test.
+// Without either the test is skipped, so the missing backend shows in the
+// output instead of going untested unnoticed.
+func postgresTestDSN(t *testing.T) string {
+
+	if dsn := os.Getenv("POSTGRES_TEST_DSN"); dsn != "" {
+		return dsn
+	}
+
+	localPostgres.once.Do(startLocalPostgres)
+	if localPostgres.err != nil {
+		t.Skipf("postgres backend not tested, set POSTGRES_TEST_DSN or install initdb and pg_ctl: %v", localPostgres.err)
+	}
+	return localPostgres.dsn
+}
+
+// mysqlTestDSN returns the DSN of MYSQL_TEST_DSN, skipping the test without it
+func mysqlTestDSN(t *testing.T) string {
+
+	dsn := os.Getenv("MYSQL_TEST_DSN")
+	if dsn == "" {
+		t.Skip("mysql backend not tested, set MYSQL_TEST_DSN")
+	}
+	return dsn
+}
+
+// postgresBinary finds a program of Postgres on the PATH or in the directories
+// of the Debian and Ubuntu packages, which do not add it to the PATH
+func postgresBinary(name string) (string, error) {
+
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	matches, _ := filepath.Glob(filepath.Join("/usr/lib/postgresql", "*", "bin", name))
+	if len(matches) > 0 {
+		return matches[len(matches)-1], nil
+	}
+	return "", fmt.Errorf("%s not found", name)
+}
+
+// startLocalPostgres creates a cluster in a temporary directory and starts it
+// on a free port, trusting the local connections
+func startLocalPostgres() {
+
+	initdb, err := postgresBinary("initdb")
+	if err != nil {
+		localPostgres.err = err
+		return
+	}
+	pgCtl, err := postgresBinary("pg_ctl")
+	if err != nil {
+		localPostgres.err = err
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "products-postgres-")
+	if err != nil {
+		localPostgres.err = err
+		return
+	}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		localPostgres.err = fmt.Errorf("initdb: %v: %s", err, out)
+		return
+	}
+
+	// the port is free now, the cluster takes it right away
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		localPostgres.err = err
+		return
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	options := fmt.Sprintf("-h 127.0.0.1 -p %d -k %s -F", port, dir)
+	if out, err := exec.Command(pgCtl, "-D", data, "-l", filepath.Join(dir, "postgres.log"), "-o", options, "-w", "start").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		localPostgres.err = fmt.Errorf("pg_ctl start: %v: %s", err, out)
+		return
+	}
+
+	localPostgres.dsn = fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+	localPostgres.stop = func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"goweb/app/internal"
+	"strconv"
+	"time"
+)
+
+// apiKeyPrefix starts every generated secret, so leaked keys are easy to grep for
+const apiKeyPrefix = "gwk_"
+
+// apiKeyPrefixLen is the length of the start of the secret kept to recognize a key
+const apiKeyPrefixLen = len(apiKeyPrefix) + 6
+
+// implements internal.APIKeyService and uses internal.APIKeyRepository
+type APIKeyService struct {
+	repo internal.APIKeyRepository
+	// now is replaced in the tests
+	now func() time.Time
+}
+
+func NewAPIKeyService(repo internal.APIKeyRepository) *APIKeyService {
+	return &APIKeyService{
+		repo: repo,
+		now:  time.Now,
+	}
+}
+
+// HashAPIKey returns the hex SHA-256 of the secret, which is what the repositories store
+func HashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// implement the methods from the interface internal.APIKeyService
+func (s *APIKeyService) GetAllAPIKeys(ctx context.Context) ([]internal.APIKey, error) {
+	return s.repo.GetAllAPIKeys(ctx)
+}
+
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, name string, scopes []string, expiresAt time.Time) (internal.APIKey, string, error) {
+
+	// 32 random bytes, base64 encoded
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return internal.APIKey{}, "", fmt.Errorf("generating the api key: %w", err)
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	if !expiresAt.IsZero() && !expiresAt.After(s.now()) {
+		return internal.APIKey{}, "", fmt.Errorf("%w: expires_at is in the past", internal.ErrAPIKeyExpired)
+	}
+
+	key, err := s.newAPIKey(name, secret, scopes, expiresAt)
+	if err != nil {
+		return internal.APIKey{}, "", err
+	}
+
+	key, err = s.repo.AddAPIKey(ctx, key)
+	if err != nil {
+		return internal.APIKey{}, "", err
+	}
+
+	return key, secret, nil
+}
+
+func (s *APIKeyService) EnsureAPIKey(ctx context.Context, name, secret string, scopes []string) (internal.APIKey, error) {
+
+	key, err := s.repo.GetAPIKeyByHash(ctx, HashAPIKey(secret))
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, internal.ErrAPIKeyNotFound) {
+		return internal.APIKey{}, err
+	}
+
+	key, err = s.newAPIKey(name, secret, scopes, time.Time{})
+	if err != nil {
+		return internal.APIKey{}, err
+	}
+
+	return s.repo.AddAPIKey(ctx, key)
+}
+
+func (s *APIKeyService) DeleteAPIKey(ctx context.Context, id int) error {
+	return s.repo.DeleteAPIKey(ctx, id)
+}
+
+func (s *APIKeyService) Authenticate(ctx context.Context, secret string) (internal.Principal, error) {
+
+	if secret == "" {
+		return internal.Principal{}, internal.ErrAPIKeyInvalid
+	}
+
+	key, err := s.repo.GetAPIKeyByHash(ctx, HashAPIKey(secret))
+	if err != nil {
+		if errors.Is(err, internal.ErrAPIKeyNotFound) {
+			return internal.Principal{}, internal.ErrAPIKeyInvalid
+		}
+		return internal.Principal{}, err
+	}
+	if key.Expired(s.now()) {
+		return internal.Principal{}, internal.ErrAPIKeyExpired
+	}
+
+	return internal.Principal{
+		ID:     "apikey:" + strconv.Itoa(key.ID),
+		Name:   key.Name,
+		Scopes: key.Scopes,
+	}, nil
+}
+
+// newAPIKey validates the name and the scopes and hashes the secret
+func (s *APIKeyService) newAPIKey(name, secret string, scopes []string, expiresAt time.Time) (internal.APIKey, error) {
+
+	if name == "" {
+		return internal.APIKey{}, internal.ErrAPIKeyNameEmpty
+	}
+	if len(scopes) == 0 {
+		return internal.APIKey{}, fmt.Errorf("%w: at least one scope is required", internal.ErrAPIKeyInvalidScope)
+	}
+
+	// drop the repeated scopes
+	unique := make([]string, 0, len(scopes))
+	seen := make(map[string]bool)
+	for _, scope := range scopes {
+		if !internal.IsValidScope(scope) {
+			return internal.APIKey{}, fmt.Errorf("%w: %q", internal.ErrAPIKeyInvalidScope, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			unique = append(unique, scope)
+		}
+	}
+
+	prefix := secret
+	if len(prefix) > apiKeyPrefixLen {
+		prefix = prefix[:apiKeyPrefixLen]
+	}
+
+	return internal.APIKey{
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      HashAPIKey(secret),
+		Scopes:    unique,
+		ExpiresAt: expiresAt.UTC().Truncate(time.Second),
+		CreatedAt: s.now().UTC().Truncate(time.Second),
+	}, nil
+}
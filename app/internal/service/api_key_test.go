@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"goweb/app/internal"
+	"goweb/app/internal/repository"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyService(t *testing.T) {
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	newService := func() *APIKeyService {
+		s := NewAPIKeyService(repository.NewAPIKeyRepositoryMap())
+		s.now = func() time.Time { return now }
+		return s
+	}
+
+	t.Run("Se crea una key y se autentica con su secreto", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		s := newService()
+
+		// Act
+		key, secret, err := s.CreateAPIKey(ctx, "reader", []string{internal.ScopeProductsRead, internal.ScopeProductsRead}, time.Time{})
+		require.NoError(t, err)
+		principal, err := s.Authenticate(ctx, secret)
+
+		// Assert
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(secret, key.Prefix))
+		require.NotContains(t, key.Hash, secret)
+		require.Equal(t, HashAPIKey(secret), key.Hash)
+		require.Equal(t, internal.Principal{ID: "apikey:1", Name: "reader", Scopes: []string{internal.ScopeProductsRead}}, principal)
+	})
+
+	t.Run("Una key vencida no autentica", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		s := newService()
+		_, secret, err := s.CreateAPIKey(ctx, "temporal", []string{internal.ScopeProductsRead}, now.Add(time.Hour))
+		require.NoError(t, err)
+
+		// Act
+		_, errBefore := s.Authenticate(ctx, secret)
+		now = now.Add(time.Hour)
+		_, errAfter := s.Authenticate(ctx, secret)
+
+		// Assert
+		require.NoError(t, errBefore)
+		require.ErrorIs(t, errAfter, internal.ErrAPIKeyExpired)
+	})
+
+	t.Run("Un secreto desconocido no autentica", func(t *testing.T) {
+		// Act
+		_, err := newService().Authenticate(context.Background(), "gwk_unknown")
+
+		// Assert
+		require.ErrorIs(t, err, internal.ErrAPIKeyInvalid)
+	})
+
+	t.Run("Se rechazan los scopes desconocidos y los vencimientos pasados", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		s := newService()
+
+		// Act
+		_, _, errScope := s.CreateAPIKey(ctx, "admin", []string{"products:*"}, time.Time{})
+		_, _, errNoScope := s.CreateAPIKey(ctx, "admin", nil, time.Time{})
+		_, _, errExpired := s.CreateAPIKey(ctx, "admin", []string{internal.ScopeProductsRead}, now.Add(-time.Second))
+		_, _, errName := s.CreateAPIKey(ctx, "", []string{internal.ScopeProductsRead}, time.Time{})
+
+		// Assert
+		require.ErrorIs(t, errScope, internal.ErrAPIKeyInvalidScope)
+		require.ErrorIs(t, errNoScope, internal.ErrAPIKeyInvalidScope)
+		require.ErrorIs(t, errExpired, internal.ErrAPIKeyExpired)
+		require.ErrorIs(t, errName, internal.ErrAPIKeyNameEmpty)
+	})
+
+	t.Run("EnsureAPIKey guarda la key una sola vez", func(t *testing.T) {
+		// Arrange
+		ctx := context.Background()
+		s := newService()
+
+		// Act
+		first, err := s.EnsureAPIKey(ctx, "bootstrap", "bootstrap-secret-0123456789", internal.Scopes)
+		require.NoError(t, err)
+		second, err := s.EnsureAPIKey(ctx, "bootstrap", "bootstrap-secret-0123456789", internal.Scopes)
+		require.NoError(t, err)
+
+		// Assert
+		require.Equal(t, first, second)
+		keys, err := s.GetAllAPIKeys(ctx)
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+	})
+}
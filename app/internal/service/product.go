@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"goweb/app/internal"
 )
 
@@ -17,64 +19,39 @@ func NewProductService(repo internal.ProductRepository) *ProductService {
 }
 
 // implement the methods from the interface internal.ProductService
-func (p *ProductService) GetAllProducts() []internal.Product {
-	return p.repo.GetAllProducts()
+func (p *ProductService) GetAllProducts(ctx context.Context) ([]internal.Product, error) {
+	return p.repo.GetAllProducts(ctx)
 }
 
-func (p *ProductService) GetProductByID(id int) (internal.Product, error) {
-
-	product := p.repo.GetProductByID(id)
-
-	if product.IsEmpty() {
-		return product, internal.ErrProductNotFound
-	}
-
-	return product, nil
+func (p *ProductService) SearchProducts(ctx context.Context, query internal.ProductQuery) (internal.ProductPage, error) {
+	return p.repo.SearchProducts(ctx, query)
 }
 
-func (p *ProductService) GetProductsByPriceGreaterThan(price float64) []internal.Product {
-	return p.repo.GetProductsByPriceGreaterThan(price)
+func (p *ProductService) GetProductByID(ctx context.Context, id int) (internal.Product, error) {
+	return p.repo.GetProductByID(ctx, id)
 }
 
-func (p *ProductService) CreateProduct(product internal.Product) (internal.Product, error) {
-
-	products := p.repo.GetAllProducts()
+func (p *ProductService) CreateProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
 
 	// check if product is empty
 	if product.IsEmpty() {
 		return internal.Product{}, internal.ErrProductEmpty
 	}
 
-	// check if the value_code already exists
-	for _, p := range products {
-		if p.CodeValue == product.CodeValue {
-			return internal.Product{}, internal.ErrProductExists
-		}
-	}
-
-	// add the product to the repo
-	product = p.repo.AddProduct(product)
-
-	return product, nil
+	// add the product to the repo, which rejects a code value that already exists
+	return p.repo.AddProduct(ctx, product)
 
 }
 
-func (p *ProductService) UpdateProduct(product internal.Product) (internal.Product, error) {
+func (p *ProductService) UpdateProduct(ctx context.Context, product internal.Product) (internal.Product, error) {
 
 	// check if product is empty
 	if product.IsEmpty() {
 		return internal.Product{}, internal.ErrProductEmpty
 	}
 
-	// check if the code value belongs to another product
-	products := p.repo.GetAllProducts()
-	for _, p := range products {
-		if p.CodeValue == product.CodeValue && p.ID != product.ID {
-			return internal.Product{}, internal.ErrCodeValueBelongsToOther
-		}
-	}
-
-	prodUpdt, err := p.repo.UpdateProduct(product)
+	// the repo rejects a code value that belongs to another product
+	prodUpdt, err := p.repo.UpdateProduct(ctx, product)
 	if err != nil {
 		return internal.Product{}, err
 	}
@@ -83,9 +60,9 @@ func (p *ProductService) UpdateProduct(product internal.Product) (internal.Produ
 
 }
 
-func (p *ProductService) DeleteProduct(id int) error {
+func (p *ProductService) DeleteProduct(ctx context.Context, id, version int) error {
 
-	err := p.repo.DeleteProduct(id)
+	err := p.repo.DeleteProduct(ctx, id, version)
 	if err != nil {
 		return err
 	}
@@ -93,7 +70,7 @@ func (p *ProductService) DeleteProduct(id int) error {
 	return nil
 }
 
-func (p *ProductService) CalculateConsumerPrice(idList ...int) ([]internal.Product, float64, error) {
+func (p *ProductService) CalculateConsumerPrice(ctx context.Context, idList ...int) ([]internal.Product, float64, error) {
 
 	// calculate the number of each product in the list
 	var idMap = make(map[int]int)
@@ -103,7 +80,11 @@ func (p *ProductService) CalculateConsumerPrice(idList ...int) ([]internal.Produ
 
 	// if no id is passed, then calculate the price for all products
 	if len(idList) == 0 {
-		for _, prod := range p.GetAllProducts() {
+		products, err := p.repo.GetAllProducts(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, prod := range products {
 			idMap[prod.ID]++
 		}
 	}
@@ -123,7 +104,14 @@ func (p *ProductService) CalculateConsumerPrice(idList ...int) ([]internal.Produ
 	prods := []internal.Product{}
 
 	for id, quantity := range idMap {
-		product, _ := p.GetProductByID(id)
+		product, err := p.repo.GetProductByID(ctx, id)
+		if err != nil {
+			// unknown ids are skipped, but storage failures are not
+			if errors.Is(err, internal.ErrProductNotFound) {
+				continue
+			}
+			return nil, 0, err
+		}
 		if product.Quantity >= quantity {
 			finalPrice += product.Price * float64(quantity)
 			product.Quantity = quantity // set the quantity requested by the consumer
@@ -0,0 +1,16 @@
+package internal
+
+import (
+	"context"
+	"errors"
+)
+
+// TokenVerifier authenticates the bearer tokens
+type TokenVerifier interface {
+	// VerifyToken checks the token and returns the principal it was issued to
+	VerifyToken(ctx context.Context, token string) (Principal, error)
+}
+
+// ErrTokenInvalid is returned for tokens that are malformed, badly signed,
+// expired, not yet valid or issued by or for someone else
+var ErrTokenInvalid = errors.New("invalid token")
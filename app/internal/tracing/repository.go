@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"goweb/app/internal"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentRepository wraps repo, starting a span named after the operation,
+// e.g. "ProductRepository.UpdateProduct", labeled with backend. The SQL
+// repositories add a span per statement below it. The wrapper implements
+// internal.HealthChecker only when repo does.
+func InstrumentRepository(repo internal.ProductRepository, backend string) internal.ProductRepository {
+
+	instrumented := &instrumentedRepository{repo: repo, backend: attribute.String("repository.backend", backend)}
+	if checker, ok := repo.(internal.HealthChecker); ok {
+		return &instrumentedHealthRepository{instrumentedRepository: instrumented, checker: checker}
+	}
+	return instrumented
+}
+
+// instrumentedRepository implements the ProductRepository interface on top of another one
+type instrumentedRepository struct {
+	repo    internal.ProductRepository
+	backend attribute.KeyValue
+}
+
+// start starts the span of an operation
+func (r *instrumentedRepository) start(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "ProductRepository."+operation, trace.WithAttributes(append(attrs, r.backend)...))
+}
+
+func (r *instrumentedRepository) GetAllProducts(ctx context.Context) (products []internal.Product, err error) {
+	ctx, span := r.start(ctx, "GetAllProducts")
+	defer func() { span.SetAttributes(attribute.Int("product.count", len(products))); end(span, err) }()
+	return r.repo.GetAllProducts(ctx)
+}
+
+func (r *instrumentedRepository) SearchProducts(ctx context.Context, query internal.ProductQuery) (page internal.ProductPage, err error) {
+	ctx, span := r.start(ctx, "SearchProducts", queryAttributes(query)...)
+	defer func() { span.SetAttributes(attribute.Int("product.count", len(page.Products))); end(span, err) }()
+	return r.repo.SearchProducts(ctx, query)
+}
+
+func (r *instrumentedRepository) GetProductByID(ctx context.Context, id int) (product internal.Product, err error) {
+	ctx, span := r.start(ctx, "GetProductByID", productID(id))
+	defer func() { end(span, err) }()
+	return r.repo.GetProductByID(ctx, id)
+}
+
+func (r *instrumentedRepository) AddProduct(ctx context.Context, product internal.Product) (added internal.Product, err error) {
+	ctx, span := r.start(ctx, "AddProduct")
+	defer func() { span.SetAttributes(productID(added.ID)); end(span, err) }()
+	return r.repo.AddProduct(ctx, product)
+}
+
+func (r *instrumentedRepository) UpdateProduct(ctx context.Context, product internal.Product) (updated internal.Product, err error) {
+	ctx, span := r.start(ctx, "UpdateProduct", productID(product.ID), productVersion(product.Version))
+	defer func() { end(span, err) }()
+	return r.repo.UpdateProduct(ctx, product)
+}
+
+func (r *instrumentedRepository) DeleteProduct(ctx context.Context, id, version int) (err error) {
+	ctx, span := r.start(ctx, "DeleteProduct", productID(id), productVersion(version))
+	defer func() { end(span, err) }()
+	return r.repo.DeleteProduct(ctx, id, version)
+}
+
+// instrumentedHealthRepository forwards the health checks too
+type instrumentedHealthRepository struct {
+	*instrumentedRepository
+	checker internal.HealthChecker
+}
+
+func (r *instrumentedHealthRepository) HealthCheck(ctx context.Context) error {
+	return r.checker.HealthCheck(ctx)
+}
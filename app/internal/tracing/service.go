@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"goweb/app/internal"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentService wraps service, starting a span named after the method,
+// e.g. "ProductService.UpdateProduct", around each call
+func InstrumentService(service internal.ProductService) internal.ProductService {
+	return &instrumentedService{service: service}
+}
+
+// instrumentedService implements the ProductService interface on top of another one
+type instrumentedService struct {
+	service internal.ProductService
+}
+
+func (s *instrumentedService) GetAllProducts(ctx context.Context) (products []internal.Product, err error) {
+	ctx, span := tracer().Start(ctx, "ProductService.GetAllProducts")
+	defer func() { span.SetAttributes(attribute.Int("product.count", len(products))); end(span, err) }()
+	return s.service.GetAllProducts(ctx)
+}
+
+func (s *instrumentedService) SearchProducts(ctx context.Context, query internal.ProductQuery) (page internal.ProductPage, err error) {
+	ctx, span := tracer().Start(ctx, "ProductService.SearchProducts", trace.WithAttributes(queryAttributes(query)...))
+	defer func() { span.SetAttributes(attribute.Int("product.count", len(page.Products))); end(span, err) }()
+	return s.service.SearchProducts(ctx, query)
+}
+
+func (s *instrumentedService) GetProductByID(ctx context.Context, id int) (product internal.Product, err error) {
+	ctx, span := tracer().Start(ctx, "ProductService.GetProductByID", trace.WithAttributes(productID(id)))
+	defer func() { end(span, err) }()
+	return s.service.GetProductByID(ctx, id)
+}
+
+func (s *instrumentedService) CreateProduct(ctx context.Context, product internal.Product) (created internal.Product, err error) {
+	ctx, span := tracer().Start(ctx, "ProductService.CreateProduct")
+	defer func() { span.SetAttributes(productID(created.ID)); end(span, err) }()
+	return s.service.CreateProduct(ctx, product)
+}
+
+func (s *instrumentedService) UpdateProduct(ctx context.Context, product internal.Product) (updated internal.Product, err error) {
+	ctx, span := tracer().Start(ctx, "ProductService.UpdateProduct", trace.WithAttributes(productID(product.ID), productVersion(product.Version)))
+	defer func() { end(span, err) }()
+	return s.service.UpdateProduct(ctx, product)
+}
+
+func (s *instrumentedService) DeleteProduct(ctx context.Context, id, version int) (err error) {
+	ctx, span := tracer().Start(ctx, "ProductService.DeleteProduct", trace.WithAttributes(productID(id), productVersion(version)))
+	defer func() { end(span, err) }()
+	return s.service.DeleteProduct(ctx, id, version)
+}
+
+func (s *instrumentedService) CalculateConsumerPrice(ctx context.Context, ids ...int) (products []internal.Product, total float64, err error) {
+	ctx, span := tracer().Start(ctx, "ProductService.CalculateConsumerPrice", trace.WithAttributes(attribute.IntSlice("product.ids", ids)))
+	defer func() { span.SetAttributes(attribute.Int("product.count", len(products))); end(span, err) }()
+	return s.service.CalculateConsumerPrice(ctx, ids...)
+}
+
+// productID is the attribute with the id of the product of the operation
+func productID(id int) attribute.KeyValue {
+	return attribute.Int("product.id", id)
+}
+
+// productVersion is the attribute with the version a change expects, 0 for any
+func productVersion(version int) attribute.KeyValue {
+	return attribute.Int("product.version", version)
+}
+
+// queryAttributes describe a search without the values of its filters, which
+// may carry what the clients typed
+func queryAttributes(query internal.ProductQuery) []attribute.KeyValue {
+
+	fields := make([]string, 0, len(query.Filters))
+	for _, filter := range query.Filters {
+		fields = append(fields, string(filter.Field)+" "+string(filter.Op))
+	}
+	return []attribute.KeyValue{
+		attribute.StringSlice("query.filters", fields),
+		attribute.Int("query.limit", query.Limit),
+		attribute.Int("query.offset", query.Offset),
+	}
+}
@@ -0,0 +1,82 @@
+// Package tracing sets up the OpenTelemetry traces of the server and the spans
+// of the product service and of the repositories. The spans of the routes are
+// started by middleware.Tracing and the ones of the SQL statements by the SQL
+// repositories, all of them with the global tracer provider.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"goweb/app/internal/config"
+	"io"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation is the name of the tracer of the service and of the repositories
+const instrumentation = "goweb/app/internal/tracing"
+
+// Setup installs the W3C trace context and baggage propagators and, unless the
+// exporter is none, a tracer provider exporting the spans to the OTLP/HTTP
+// collector of the configuration or to stdout as JSON. The returned function
+// flushes the pending spans and must be called on shutdown.
+func Setup(ctx context.Context, cfg config.TracingConfig, stdout io.Writer) (shutdown func(context.Context) error, err error) {
+
+	// the traceparent of the clients is propagated even if nothing is exported
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case config.TracingExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case config.TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(stdout))
+	case config.TracingExporterOTLP:
+		// the endpoint is the base URL of the collector, as in OTEL_EXPORTER_OTLP_ENDPOINT
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.Endpoint, "/")+"/v1/traces"))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating the %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	provider := NewProvider(exporter, cfg)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// NewProvider returns a tracer provider batching the spans to exporter. The new
+// traces are sampled with the ratio of the configuration, the ones started by a
+// client keep its decision.
+func NewProvider(exporter sdktrace.SpanExporter, cfg config.TracingConfig) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName))),
+	)
+}
+
+// tracer returns the tracer of the global provider, looked up on each span so
+// it follows the provider installed by Setup
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// end records the error of the operation, if any, and ends its span
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
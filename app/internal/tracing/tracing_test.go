@@ -0,0 +1,148 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"goweb/app/internal"
+	"goweb/app/internal/config"
+	"goweb/app/internal/repository"
+	"goweb/app/internal/service"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector stands in for an OTLP/HTTP collector, keeping the spans it receives
+type collector struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+	names map[string]string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil || r.URL.Path != "/v1/traces" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var request collectortrace.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, resource := range request.ResourceSpans {
+		for _, attr := range resource.Resource.Attributes {
+			c.names[attr.Key] = attr.Value.GetStringValue()
+		}
+		for _, scope := range resource.ScopeSpans {
+			c.spans = append(c.spans, scope.Spans...)
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+// span returns the span received with the name
+func (c *collector) span(t *testing.T, name string) *tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, span := range c.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	require.Failf(t, "span not exported", "no span named %q", name)
+	return nil
+}
+
+// restoreGlobals puts back the provider and the propagator replaced by Setup
+func restoreGlobals(t *testing.T) {
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+}
+
+// newTracedService returns the product service over a map repository, both traced
+func newTracedService() internal.ProductService {
+	repo := InstrumentRepository(repository.NewRepositoryMap(map[int]internal.Product{}), "map")
+	return InstrumentService(service.NewProductService(repo))
+}
+
+func TestSetup(t *testing.T) {
+	t.Run("El exporter otlp envia los spans del servicio y del repositorio al collector", func(t *testing.T) {
+		// Arrange
+		restoreGlobals(t)
+		received := &collector{names: map[string]string{}}
+		server := httptest.NewServer(received)
+		defer server.Close()
+		shutdown, err := Setup(context.Background(), config.TracingConfig{
+			Exporter: config.TracingExporterOTLP, Endpoint: server.URL, SampleRatio: 1, ServiceName: "products-test",
+		}, io.Discard)
+		require.NoError(t, err)
+		products := newTracedService()
+
+		// Act
+		_, errGet := products.GetProductByID(context.Background(), 7)
+		require.NoError(t, shutdown(context.Background()))
+
+		// Assert
+		require.ErrorIs(t, errGet, internal.ErrProductNotFound)
+		require.Equal(t, "products-test", received.names["service.name"])
+		serviceSpan := received.span(t, "ProductService.GetProductByID")
+		repoSpan := received.span(t, "ProductRepository.GetProductByID")
+		require.Equal(t, serviceSpan.TraceId, repoSpan.TraceId)
+		require.Equal(t, serviceSpan.SpanId, repoSpan.ParentSpanId)
+		require.Equal(t, tracepb.Status_STATUS_CODE_ERROR, repoSpan.Status.Code)
+	})
+
+	t.Run("El exporter stdout escribe los spans como JSON", func(t *testing.T) {
+		// Arrange
+		restoreGlobals(t)
+		var out bytes.Buffer
+		shutdown, err := Setup(context.Background(), config.TracingConfig{
+			Exporter: config.TracingExporterStdout, SampleRatio: 1, ServiceName: "products-test",
+		}, &out)
+		require.NoError(t, err)
+
+		// Act
+		_, errAll := newTracedService().GetAllProducts(context.Background())
+		require.NoError(t, shutdown(context.Background()))
+
+		// Assert
+		require.NoError(t, errAll)
+		require.Contains(t, out.String(), `"Name":"ProductService.GetAllProducts"`)
+		require.Contains(t, out.String(), `"Name":"ProductRepository.GetAllProducts"`)
+	})
+
+	t.Run("Sin exporter se propaga el traceparent", func(t *testing.T) {
+		// Arrange
+		restoreGlobals(t)
+		header := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+
+		// Act
+		shutdown, err := Setup(context.Background(), config.TracingConfig{Exporter: config.TracingExporterNone}, io.Discard)
+		require.NoError(t, err)
+		ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+		injected := http.Header{}
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(injected))
+
+		// Assert
+		require.NoError(t, shutdown(context.Background()))
+		require.Equal(t, header.Get("Traceparent"), injected.Get("Traceparent"))
+	})
+}
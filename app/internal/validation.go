@@ -0,0 +1,37 @@
+package internal
+
+import "strings"
+
+// the codes of the field errors, stable so the clients can rely on them
+const (
+	FieldRequired      = "required"
+	FieldInvalidType   = "invalid_type"
+	FieldInvalidFormat = "invalid_format"
+	FieldInvalidValue  = "invalid_value"
+	FieldInvalidLength = "invalid_length"
+	FieldOutOfRange    = "out_of_range"
+	FieldDuplicate     = "duplicate"
+	FieldUnknown       = "unknown"
+)
+
+// FieldError is what is wrong with a field of a request
+type FieldError struct {
+	// Field is the name of the field in the request, e.g. "expiration"
+	Field string
+	// Code is the rule the field breaks, e.g. FieldRequired
+	Code    string
+	Message string
+}
+
+// ValidationError lists the invalid fields of a request
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+" "+field.Message)
+	}
+	return "invalid fields: " + strings.Join(messages, "; ")
+}
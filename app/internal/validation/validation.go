@@ -0,0 +1,210 @@
+// Package validation checks the request bodies against the rules declared in
+// the validate tag of their fields, so each rule is written once next to the
+// field it applies to:
+//
+//	Name  string  `json:"name" validate:"required,notblank,max=100"`
+//	Price float64 `json:"price" validate:"required,min=0"`
+//
+// The rules are:
+//
+//	required      the field must be in the body
+//	notblank      the string must hold more than spaces
+//	min=N, max=N  bounds of a number, or of the length of a string
+//	pattern=RE    the string must match the regular expression, which cannot hold commas
+//	date          the string must be a date in the DD/MM/YYYY format of the API
+//	after=DATE    the date cannot be before DATE, in the same format
+package validation
+
+import (
+	"fmt"
+	"goweb/app/internal"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DateLayout is the format of the dates of the API, DD/MM/YYYY
+const DateLayout = "02/01/2006"
+
+// Struct checks every field of v, a struct, against the rules of its validate
+// tag. It returns an *internal.ValidationError with the first rule each field
+// breaks, naming the fields after their json tag, or nil when v is valid.
+// present holds the fields of the JSON body, the required rule fails for the
+// ones it lacks; a nil present skips the rule, e.g. for a value that is not
+// decoded from a body. The tags are parsed once per type and an invalid one panics.
+func Struct(v any, present map[string]any) error {
+
+	value := reflect.ValueOf(v)
+	var violations []internal.FieldError
+	for _, field := range fieldsOf(value.Type()) {
+		if violation, ok := field.check(value.Field(field.index), present); !ok {
+			violations = append(violations, violation)
+		}
+	}
+
+	if len(violations) > 0 {
+		return &internal.ValidationError{Fields: violations}
+	}
+	return nil
+}
+
+// field holds the parsed rules of a struct field
+type field struct {
+	index    int
+	name     string
+	required bool
+	rules    []rule
+}
+
+// rule checks a value, returning the code and the message of the violation
+type rule func(value reflect.Value) (code, message string, ok bool)
+
+// check applies the rules of the field in order, stopping at the first violation
+func (f field) check(value reflect.Value, present map[string]any) (internal.FieldError, bool) {
+
+	if present != nil {
+		if _, ok := present[f.name]; !ok {
+			if f.required {
+				return internal.FieldError{Field: f.name, Code: internal.FieldRequired, Message: "is required"}, false
+			}
+			// an optional field left out keeps its zero value, which is not checked
+			return internal.FieldError{}, true
+		}
+	}
+
+	for _, rule := range f.rules {
+		if code, message, ok := rule(value); !ok {
+			return internal.FieldError{Field: f.name, Code: code, Message: message}, false
+		}
+	}
+	return internal.FieldError{}, true
+}
+
+// types caches the parsed fields of each struct type
+var types sync.Map
+
+// fieldsOf returns the fields of t with a validate tag, parsing them the first time
+func fieldsOf(t reflect.Type) []field {
+
+	if fields, ok := types.Load(t); ok {
+		return fields.([]field)
+	}
+
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		tag, ok := structField.Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(structField.Tag.Get("json"), ",")
+		if name == "" {
+			name = structField.Name
+		}
+		f := field{index: i, name: name}
+		for _, spec := range strings.Split(tag, ",") {
+			if spec == "required" {
+				f.required = true
+				continue
+			}
+			r, err := parseRule(spec, structField.Type)
+			if err != nil {
+				panic(fmt.Sprintf("validation: field %s of %s: %v", structField.Name, t, err))
+			}
+			f.rules = append(f.rules, r)
+		}
+		fields = append(fields, f)
+	}
+
+	types.Store(t, fields)
+	return fields
+}
+
+// parseRule builds the rule of spec for a field of type t
+func parseRule(spec string, t reflect.Type) (rule, error) {
+
+	name, arg, _ := strings.Cut(spec, "=")
+	kind := t.Kind()
+	isString := kind == reflect.String
+	isNumber := kind >= reflect.Int && kind <= reflect.Float64
+
+	switch {
+	case name == "notblank" && isString:
+		return func(v reflect.Value) (string, string, bool) {
+			return internal.FieldInvalidValue, "must not be blank", strings.TrimSpace(v.String()) != ""
+		}, nil
+
+	case (name == "min" || name == "max") && (isString || isNumber):
+		bound, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s needs a number, not %q", name, arg)
+		}
+		return boundRule(name == "min", bound, isString), nil
+
+	case name == "pattern" && isString:
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(v reflect.Value) (string, string, bool) {
+			return internal.FieldInvalidFormat, "must match " + arg, re.MatchString(v.String())
+		}, nil
+
+	case name == "date" && isString:
+		return func(v reflect.Value) (string, string, bool) {
+			_, err := time.Parse(DateLayout, v.String())
+			return internal.FieldInvalidFormat, "must be a date in the DD/MM/YYYY format", err == nil
+		}, nil
+
+	case name == "after" && isString:
+		limit, err := time.Parse(DateLayout, arg)
+		if err != nil {
+			return nil, fmt.Errorf("after needs a DD/MM/YYYY date, not %q", arg)
+		}
+		return func(v reflect.Value) (string, string, bool) {
+			date, err := time.Parse(DateLayout, v.String())
+			if err != nil {
+				// the date rule reports it
+				return "", "", true
+			}
+			return internal.FieldOutOfRange, "cannot be before " + arg, !date.Before(limit)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown rule %q for a %s", spec, t)
+}
+
+// boundRule checks a number, or the length of a string, against a bound
+func boundRule(isMin bool, bound float64, isString bool) rule {
+
+	format := strconv.FormatFloat(bound, 'f', -1, 64)
+	code, message := internal.FieldOutOfRange, "must be at most "+format
+	if isMin {
+		message = "must be at least " + format
+	}
+	if isString {
+		code, message = internal.FieldInvalidLength, message+" characters long"
+	}
+
+	return func(v reflect.Value) (string, string, bool) {
+		var n float64
+		switch {
+		case isString:
+			n = float64(utf8.RuneCountInString(v.String()))
+		case v.CanInt():
+			n = float64(v.Int())
+		case v.CanUint():
+			n = float64(v.Uint())
+		default:
+			n = v.Float()
+		}
+		if isMin {
+			return code, message, n >= bound
+		}
+		return code, message, n <= bound
+	}
+}
@@ -0,0 +1,76 @@
+package validation
+
+import (
+	"goweb/app/internal"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	Name     string  `json:"name" validate:"required,notblank,max=5"`
+	Code     string  `json:"code,omitempty" validate:"pattern=^[A-Z]+$"`
+	Quantity int     `json:"quantity" validate:"required,min=0,max=10"`
+	Price    float64 `json:"price" validate:"min=0.5"`
+	Date     string  `json:"date" validate:"required,date,after=01/01/2000"`
+	Notes    string  `json:"notes"`
+}
+
+func TestStruct(t *testing.T) {
+	t.Run("Un valor que cumple las reglas es valido", func(t *testing.T) {
+		// Arrange
+		valid := item{Name: "ab", Code: "AB", Quantity: 10, Price: 0.5, Date: "01/01/2000"}
+
+		// Act
+		err := Struct(valid, map[string]any{"name": "", "code": "", "quantity": 0, "price": 0, "date": ""})
+
+		// Assert
+		require.NoError(t, err)
+	})
+
+	t.Run("Se informa la primera regla que rompe cada campo", func(t *testing.T) {
+		// Arrange
+		invalid := item{Name: "  ", Code: "a b", Quantity: -1, Price: 0.1, Date: "31/12/1999"}
+
+		// Act
+		err := Struct(invalid, map[string]any{"name": "", "code": "", "quantity": 0, "price": 0, "date": ""})
+
+		// Assert
+		require.Equal(t, &internal.ValidationError{Fields: []internal.FieldError{
+			{Field: "name", Code: internal.FieldInvalidValue, Message: "must not be blank"},
+			{Field: "code", Code: internal.FieldInvalidFormat, Message: "must match ^[A-Z]+$"},
+			{Field: "quantity", Code: internal.FieldOutOfRange, Message: "must be at least 0"},
+			{Field: "price", Code: internal.FieldOutOfRange, Message: "must be at least 0.5"},
+			{Field: "date", Code: internal.FieldOutOfRange, Message: "cannot be before 01/01/2000"},
+		}}, err)
+	})
+
+	t.Run("Los campos requeridos deben venir en el cuerpo, los opcionales no se revisan", func(t *testing.T) {
+		// Act
+		err := Struct(item{}, map[string]any{"notes": "x"})
+
+		// Assert
+		require.EqualError(t, err, "invalid fields: name is required; quantity is required; date is required")
+	})
+
+	t.Run("Sin cuerpo no se revisa la presencia, si el resto de las reglas", func(t *testing.T) {
+		// Act
+		err := Struct(item{Name: "abcdef", Quantity: 11, Price: 1, Date: "2000-01-01"}, nil)
+
+		// Assert
+		require.EqualError(t, err, "invalid fields: name must be at most 5 characters long; code must match ^[A-Z]+$; "+
+			"quantity must be at most 10; date must be a date in the DD/MM/YYYY format")
+	})
+
+	t.Run("Una regla desconocida es un error de programacion", func(t *testing.T) {
+		// Arrange
+		type broken struct {
+			Flag bool `validate:"min=1"`
+		}
+
+		// Act & Assert
+		require.PanicsWithValue(t, `validation: field Flag of validation.broken: unknown rule "min=1" for a bool`, func() {
+			Struct(broken{}, nil)
+		})
+	})
+}